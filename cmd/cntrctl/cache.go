@@ -0,0 +1,100 @@
+// SPDX-License-Identifier: BSD-2
+// Copyright 2023 VMware, Inc.
+
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"text/tabwriter"
+
+	"github.com/urfave/cli/v2"
+
+	"github.com/vmware-samples/photon-os-container-builder/pkg/conf"
+	"github.com/vmware-samples/photon-os-container-builder/pkg/rpm"
+	"github.com/vmware-samples/photon-os-container-builder/pkg/set"
+	"github.com/vmware-samples/photon-os-container-builder/pkg/system"
+)
+
+func cacheCommand(cfg *conf.Config) *cli.Command {
+	return &cli.Command{
+		Name:  "cache",
+		Usage: "Manage the package cache shared by all spawns",
+		Subcommands: []*cli.Command{
+			{
+				Name:  "ls",
+				Usage: "List cached releases",
+				Flags: []cli.Flag{
+					formatFlag("table", "json"),
+				},
+				Action: func(c *cli.Context) error {
+					entries, err := rpm.ListCache(cfg.Cache.Dir)
+					if err != nil {
+						return fmt.Errorf("failed to list package cache '%s': %w", cfg.Cache.Dir, err)
+					}
+
+					if c.String("format") == "json" {
+						e := json.NewEncoder(os.Stdout)
+						e.SetIndent("", "  ")
+						return e.Encode(entries)
+					}
+
+					t := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+					fmt.Fprintln(t, "RELEASE\tPACKAGES\tSIZE\tPATH")
+					for _, e := range entries {
+						fmt.Fprintf(t, "%s\t%d\t%s\t%s\n", e.Release, e.Packages, system.FormatBytes(e.Size), e.Path)
+					}
+					t.Flush()
+
+					return nil
+				},
+			},
+			{
+				Name:      "prune",
+				Usage:     "[RELEASE] Remove cached packages",
+				ArgsUsage: "[RELEASE]",
+				Flags: []cli.Flag{
+					&cli.DurationFlag{
+						Name:  "older-than",
+						Value: DefaultPruneAge,
+						Usage: "Only remove packages not downloaded within this duration, 0 removes all",
+					},
+				},
+				Action: func(c *cli.Context) error {
+					n, size, err := rpm.PruneCache(cfg.Cache.Dir, c.Args().First(), c.Duration("older-than"))
+					if err != nil {
+						return fmt.Errorf("failed to prune package cache '%s': %w", cfg.Cache.Dir, err)
+					}
+
+					fmt.Printf("Removed %d packages, freed %s\n", n, system.FormatBytes(size))
+					return nil
+				},
+			},
+			{
+				Name:      "warm",
+				Usage:     "[RELEASE] [PKGS...] Download packages and their dependencies into the cache",
+				ArgsUsage: "RELEASE [PKGS...]",
+				Action: func(c *cli.Context) error {
+					if c.NArg() < 1 {
+						return usageError(c, "wrong number of arguments")
+					}
+
+					s := set.New()
+					if c.NArg() == 1 {
+						s.AddAll(cfg.System.Packages)
+					}
+					for _, p := range c.Args().Tail() {
+						s.AddAll(p)
+					}
+
+					if err := rpm.WarmCache(cfg, os.Stdout, c.Args().First(), s); err != nil {
+						return fmt.Errorf("failed to warm package cache for release '%s': %w", c.Args().First(), err)
+					}
+
+					return nil
+				},
+			},
+		},
+	}
+}
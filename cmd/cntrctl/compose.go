@@ -0,0 +1,191 @@
+// SPDX-License-Identifier: BSD-2
+// Copyright 2023 VMware, Inc.
+
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"text/tabwriter"
+	"time"
+
+	"github.com/urfave/cli/v2"
+
+	"github.com/vmware-samples/photon-os-container-builder/pkg/compose"
+	"github.com/vmware-samples/photon-os-container-builder/pkg/conf"
+	"github.com/vmware-samples/photon-os-container-builder/pkg/container"
+	"github.com/vmware-samples/photon-os-container-builder/pkg/errdefs"
+)
+
+func loadProject(c *cli.Context) (*compose.Project, error) {
+	p, err := compose.Load(c.String("file"))
+	if err != nil {
+		return nil, fmt.Errorf("failed to load compose file '%s': %w", c.String("file"), err)
+	}
+
+	return p, nil
+}
+
+func composeCommand(cfg *conf.Config) *cli.Command {
+	return &cli.Command{
+		Name:  "compose",
+		Usage: "Manage a group of containers declared in a compose file",
+		Flags: []cli.Flag{
+			&cli.StringFlag{
+				Name:    "file",
+				Aliases: []string{"f"},
+				Value:   compose.DefaultFile,
+				Usage:   "Compose file",
+			},
+		},
+		Subcommands: []*cli.Command{
+			{
+				Name:  "up",
+				Usage: "Build missing containers, generate their units and start the group",
+				Flags: append(waitFlags(), timeoutFlag(), &cli.BoolFlag{
+					Name:  "offline",
+					Usage: "Install only from the shared package cache",
+				}),
+				Action: func(c *cli.Context) error {
+					p, err := loadProject(c)
+					if err != nil {
+						return err
+					}
+
+					if c.Bool("offline") {
+						cfg.Cache.Offline = true
+					}
+
+					if err := compose.Up(cfg, os.Stdout, cfg.System.StorageDir, p, c.Duration("timeout")); err != nil {
+						return err
+					}
+
+					if c.Bool("wait") || c.String("wait-for") != "" {
+						for _, n := range p.Containers() {
+							if err := waitForContainer(n, c.String("wait-for"), c.Duration("timeout")); err != nil {
+								return err
+							}
+						}
+					}
+
+					return nil
+				},
+			},
+			{
+				Name:  "down",
+				Usage: "Stop all containers of the group",
+				Flags: []cli.Flag{
+					timeoutFlag(),
+					&cli.BoolFlag{
+						Name:  "remove",
+						Usage: "Also remove the generated unit files (container roots and volumes are kept)",
+					},
+				},
+				Action: func(c *cli.Context) error {
+					p, err := loadProject(c)
+					if err != nil {
+						return err
+					}
+
+					return compose.Down(os.Stdout, p, c.Bool("remove"), c.Duration("timeout"))
+				},
+			},
+			{
+				Name:  "ps",
+				Usage: "Show the state of the containers of the group",
+				Flags: []cli.Flag{
+					&cli.StringFlag{
+						Name:  "format",
+						Value: "table",
+						Usage: "Output format (table, json)",
+					},
+				},
+				Action: func(c *cli.Context) error {
+					p, err := loadProject(c)
+					if err != nil {
+						return err
+					}
+
+					services := compose.Ps(cfg.System.StorageDir, p)
+
+					if c.String("format") == "json" {
+						e := json.NewEncoder(os.Stdout)
+						e.SetIndent("", "  ")
+						return e.Encode(services)
+					}
+
+					t := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+					fmt.Fprintln(t, "SERVICE\tCONTAINER\tMACHINE\tACTIVE\tSUB\tSINCE")
+					for _, s := range services {
+						active, sub, since := "-", "-", "-"
+						if !s.Exists {
+							active = "not-built"
+						}
+						if s.Status != nil {
+							active, sub = s.Status.ActiveState, s.Status.SubState
+							if !s.Status.ActiveSince.IsZero() {
+								since = time.Since(s.Status.ActiveSince).Round(time.Second).String()
+							}
+						}
+
+						fmt.Fprintf(t, "%s\t%s\t%s\t%s\t%s\t%s\n", s.Service, s.Container, s.Machine, active, sub, since)
+					}
+					t.Flush()
+
+					return nil
+				},
+			},
+			{
+				Name:      "logs",
+				Usage:     "[SERVICE] Show the service journals of the group, or the journal inside one service",
+				ArgsUsage: "[SERVICE]",
+				Flags: []cli.Flag{
+					&cli.BoolFlag{
+						Name:  "follow",
+						Usage: "Follow the journal",
+					},
+					&cli.StringFlag{
+						Name:    "since",
+						Aliases: []string{"S"},
+						Usage:   "Show entries not older than the specified date (e.g. '1 hour ago', '2023-05-01 10:00')",
+					},
+					&cli.IntFlag{
+						Name:    "lines",
+						Aliases: []string{"n"},
+						Usage:   "Number of most recent entries to show",
+					},
+					&cli.StringFlag{
+						Name:    "output-mode",
+						Aliases: []string{"o"},
+						Usage:   "journalctl output mode (short, json, json-pretty, cat, ...)",
+					},
+				},
+				Action: func(c *cli.Context) error {
+					p, err := loadProject(c)
+					if err != nil {
+						return err
+					}
+
+					o := container.LogOptions{
+						Follow: c.Bool("follow"),
+						Since:  c.String("since"),
+						Lines:  c.Int("lines"),
+						Output: c.String("output-mode"),
+					}
+
+					if c.NArg() == 0 {
+						return container.GroupLogs(p.Containers(), &o)
+					}
+
+					s, ok := p.Service(c.Args().First())
+					if !ok {
+						return errdefs.Errorf(errdefs.ErrNotFound, "service '%s' is not defined in '%s'", c.Args().First(), c.String("file"))
+					}
+
+					return container.Logs(cfg.System.StorageDir, p.Container(s), &o)
+				},
+			},
+		},
+	}
+}
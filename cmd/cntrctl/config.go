@@ -0,0 +1,141 @@
+// SPDX-License-Identifier: BSD-2
+// Copyright 2023 VMware, Inc.
+
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"path"
+	"strings"
+
+	"github.com/pelletier/go-toml/v2"
+	"github.com/urfave/cli/v2"
+
+	"github.com/vmware-samples/photon-os-container-builder/pkg/conf"
+	"github.com/vmware-samples/photon-os-container-builder/pkg/system"
+)
+
+func configCommand(cfg *conf.Config) *cli.Command {
+	return &cli.Command{
+		Name:  "config",
+		Usage: "Show, query, change and validate the layered configuration",
+		Subcommands: []*cli.Command{
+			{
+				Name:  "show",
+				Usage: "Print the effective configuration and where it was read from",
+				Flags: []cli.Flag{
+					&cli.StringFlag{
+						Name:  "format",
+						Value: "toml",
+						Usage: "Output format (toml, json)",
+					},
+				},
+				Action: func(c *cli.Context) error {
+					switch c.String("format") {
+					case "json":
+						e := json.NewEncoder(os.Stdout)
+						e.SetIndent("", "  ")
+						return e.Encode(cfg)
+					case "toml":
+					default:
+						return usageError(c, "unsupported format '%s'", c.String("format"))
+					}
+
+					fmt.Printf("# Sources: %s\n", strings.Join(append([]string{"defaults"}, cfg.Sources...), ", "))
+					return toml.NewEncoder(os.Stdout).Encode(cfg)
+				},
+			},
+			{
+				Name:      "get",
+				Usage:     "Print the effective value of Section.Key or of a whole section",
+				ArgsUsage: "KEY",
+				Action: func(c *cli.Context) error {
+					if c.NArg() != 1 {
+						return usageError(c, "expected a key")
+					}
+
+					v, err := cfg.Get(c.Args().First())
+					if err != nil {
+						return err
+					}
+
+					switch t := v.(type) {
+					case string:
+						fmt.Println(t)
+					case bool:
+						fmt.Println(t)
+					case []string:
+						fmt.Println(strings.Join(t, ","))
+					default:
+						return toml.NewEncoder(os.Stdout).Encode(t)
+					}
+
+					return nil
+				},
+			},
+			{
+				Name:      "set",
+				Usage:     "Set Section.Key in the system configuration, or the user or given file",
+				ArgsUsage: "KEY VALUE",
+				Flags: []cli.Flag{
+					&cli.BoolFlag{
+						Name:  "user",
+						Usage: "Write the per-user configuration file",
+					},
+					&cli.StringFlag{
+						Name:  "file",
+						Usage: "Write this configuration file",
+					},
+				},
+				Action: func(c *cli.Context) error {
+					if c.NArg() != 2 {
+						return usageError(c, "expected a key and a value")
+					}
+
+					file := conf.SystemFile()
+					if c.Bool("user") {
+						file = conf.UserFile()
+					}
+					if c.String("file") != "" {
+						file = c.String("file")
+					}
+
+					b, err := conf.Set(file, c.Args().Get(0), c.Args().Get(1))
+					if err != nil {
+						return fmt.Errorf("failed to set '%s' in '%s': %w", c.Args().Get(0), file, err)
+					}
+
+					if err := system.MkdirAll(path.Dir(file), 0755); err != nil {
+						return err
+					}
+
+					return system.WriteFile(file, b, 0644)
+				},
+			},
+			{
+				Name:      "validate",
+				Usage:     "Check the layered configuration for unknown keys and invalid values",
+				ArgsUsage: "[FILE]",
+				Action: func(c *cli.Context) error {
+					file := c.String("config")
+					if c.NArg() > 0 {
+						file = c.Args().First()
+					}
+
+					v, err := conf.LoadStrict(file)
+					if err != nil {
+						return err
+					}
+					if err := v.Validate(); err != nil {
+						return err
+					}
+
+					fmt.Printf("Configuration is valid (%s)\n", strings.Join(append([]string{"defaults"}, v.Sources...), ", "))
+					return nil
+				},
+			},
+		},
+	}
+}
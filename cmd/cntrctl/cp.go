@@ -0,0 +1,32 @@
+// SPDX-License-Identifier: BSD-2
+// Copyright 2023 VMware, Inc.
+
+package main
+
+import (
+	"fmt"
+
+	"github.com/urfave/cli/v2"
+
+	"github.com/vmware-samples/photon-os-container-builder/pkg/conf"
+	"github.com/vmware-samples/photon-os-container-builder/pkg/container"
+)
+
+func cpCommand(cfg *conf.Config) *cli.Command {
+	return &cli.Command{
+		Name:      "cp",
+		Usage:     "[SRC] [DST] copy files recursively between the host and a container (NAME:/path)",
+		ArgsUsage: "SRC DST",
+		Action: func(c *cli.Context) error {
+			if c.NArg() != 2 {
+				return usageError(c, "wrong number of arguments")
+			}
+
+			if err := container.Copy(cfg.System.StorageDir, c.Args().Get(0), c.Args().Get(1)); err != nil {
+				return fmt.Errorf("failed to copy: %w", err)
+			}
+
+			return nil
+		},
+	}
+}
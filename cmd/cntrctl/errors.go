@@ -0,0 +1,145 @@
+// SPDX-License-Identifier: BSD-2
+// Copyright 2023 VMware, Inc.
+
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"io"
+	"os"
+	"os/exec"
+
+	log "github.com/sirupsen/logrus"
+	"github.com/urfave/cli/v2"
+
+	"github.com/vmware-samples/photon-os-container-builder/pkg/errdefs"
+)
+
+// Exit codes of cntrctl. 1 is also used when tests fail, 3 is reserved for status
+// of an inactive container as with LSB init scripts.
+const (
+	exitFailure        = 1
+	exitInvalid        = 2
+	exitInactive       = 3
+	exitNotFound       = 4
+	exitExists         = 5
+	exitConflict       = 6
+	exitDependency     = 7
+	exitPackageManager = 8
+	exitBus            = 9
+	exitTimeout        = 10
+	exitPermission     = 11
+)
+
+var errorKinds = []struct {
+	kind error
+	name string
+	code int
+}{
+	{errdefs.ErrInvalid, "invalid", exitInvalid},
+	{errdefs.ErrNotFound, "not-found", exitNotFound},
+	{errdefs.ErrExists, "exists", exitExists},
+	{errdefs.ErrConflict, "conflict", exitConflict},
+	{errdefs.ErrDependency, "dependency", exitDependency},
+	{errdefs.ErrPackageManager, "package-manager", exitPackageManager},
+	{errdefs.ErrBus, "bus", exitBus},
+	{errdefs.ErrTimeout, "timeout", exitTimeout},
+	{errdefs.ErrPermission, "permission", exitPermission},
+}
+
+// errorOutput is set by the global --output flag, "json" reports errors as an
+// envelope on stdout instead of a message on stderr.
+var errorOutput = "text"
+
+type errorEnvelope struct {
+	Error struct {
+		Message string `json:"message"`
+		Kind    string `json:"kind"`
+		Code    int    `json:"code"`
+	} `json:"error"`
+}
+
+// outputFlag is the only flag named output, the output flags of commands name what
+// they write (output-file, output-dir, output-mode) so they cannot be mistaken for it.
+func outputFlag() cli.Flag {
+	return &cli.StringFlag{
+		Name:  "output",
+		Value: "text",
+		Usage: "Report errors as text or json",
+	}
+}
+
+// usageError shows the help of the command and returns an invalid argument error.
+// The help is left out with json output so stdout only carries the envelope.
+func usageError(c *cli.Context, format string, args ...interface{}) error {
+	if errorOutput != "json" {
+		cli.ShowSubcommandHelp(c)
+	}
+
+	return errdefs.Errorf(errdefs.ErrInvalid, format, args...)
+}
+
+func checkNetwork(network string, link string) error {
+	if network != "" && link == "" {
+		return errdefs.Errorf(errdefs.ErrInvalid, "network='%s' is specified but link is missing", network)
+	} else if link != "" && network == "" {
+		return errdefs.Errorf(errdefs.ErrInvalid, "link='%s' is specified but network is missing", link)
+	}
+
+	return nil
+}
+
+func errorKind(err error) (string, int) {
+	k := errdefs.Kind(err)
+	for _, e := range errorKinds {
+		if e.kind == k {
+			return e.name, e.code
+		}
+	}
+
+	return "failure", exitFailure
+}
+
+// exitStatus passes on the exit status of a command cntrctl waited for, such as 133
+// of systemd-nspawn for a reboot the service unit restarts the container on. The
+// command reported the failure itself.
+func exitStatus(err error) error {
+	var e *exec.ExitError
+	if errors.As(err, &e) && e.ExitCode() > 0 {
+		log.Debug(err)
+		return cli.Exit("", e.ExitCode())
+	}
+
+	return err
+}
+
+// reportError logs err, or writes it to stdout with json output, and returns the
+// exit code.
+func reportError(stdout io.Writer, err error) int {
+	var ec cli.ExitCoder
+	if errors.As(err, &ec) && err.Error() == "" {
+		return ec.ExitCode()
+	}
+
+	kind, code := errorKind(err)
+
+	if errorOutput == "json" {
+		e := errorEnvelope{}
+		e.Error.Message = err.Error()
+		e.Error.Kind = kind
+		e.Error.Code = code
+
+		json.NewEncoder(stdout).Encode(&e)
+		return code
+	}
+
+	log.WithFields(log.Fields{"kind": kind, "code": code}).Error(err)
+	return code
+}
+
+func exit(err error) {
+	if err != nil {
+		os.Exit(reportError(os.Stdout, err))
+	}
+}
@@ -0,0 +1,29 @@
+// SPDX-License-Identifier: BSD-2
+// Copyright 2023 VMware, Inc.
+
+package main
+
+import (
+	"strings"
+
+	"github.com/urfave/cli/v2"
+)
+
+// formatFlag returns a --format flag accepting formats, the first is the default.
+func formatFlag(formats ...string) cli.Flag {
+	return &cli.StringFlag{
+		Name:    "format",
+		Aliases: []string{"f"},
+		Value:   formats[0],
+		Usage:   "Output format (" + strings.Join(formats, ", ") + ")",
+		Action: func(c *cli.Context, v string) error {
+			for _, f := range formats {
+				if v == f {
+					return nil
+				}
+			}
+
+			return usageError(c, "unsupported format '%s', use one of %s", v, strings.Join(formats, ", "))
+		},
+	}
+}
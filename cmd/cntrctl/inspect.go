@@ -0,0 +1,113 @@
+// SPDX-License-Identifier: BSD-2
+// Copyright 2023 VMware, Inc.
+
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/urfave/cli/v2"
+
+	"github.com/vmware-samples/photon-os-container-builder/pkg/conf"
+	"github.com/vmware-samples/photon-os-container-builder/pkg/container"
+	"github.com/vmware-samples/photon-os-container-builder/pkg/system"
+)
+
+func displayInfo(i *container.Info) {
+	fmt.Printf("%s\n", i.Name)
+	fmt.Printf("  Path: %s\n", i.Path)
+
+	if i.Unit != nil {
+		fmt.Printf("  Unit file: %s\n", i.Unit.Path)
+		if i.Unit.Machine != "" {
+			fmt.Printf("  Machine name: %s\n", i.Unit.Machine)
+		}
+		if i.Unit.Network != "" {
+			fmt.Printf("  Network: %s on %s\n", i.Unit.Network, i.Unit.Link)
+		} else {
+			fmt.Printf("  Network: host\n")
+		}
+		fmt.Printf("  Ephemeral: %t\n", i.Unit.Ephemeral)
+	}
+	if i.NetworkFile != "" {
+		fmt.Printf("  Network file: %s\n", i.NetworkFile)
+	}
+
+	if s := i.Service; s != nil {
+		fmt.Printf("  State: %s (%s), %s\n", s.ActiveState, s.SubState, s.UnitFileState)
+		if s.MainPID != 0 {
+			fmt.Printf("  Main PID: %d\n", s.MainPID)
+		}
+		if s.ControlGroup != "" {
+			fmt.Printf("  CGroup: %s\n", s.ControlGroup)
+		}
+	}
+
+	if m := i.Machine; m != nil {
+		fmt.Printf("  Leader PID: %d\n", m.Leader)
+		if m.Unit != "" {
+			fmt.Printf("  Scope: %s\n", m.Unit)
+		}
+		if !m.Since.IsZero() {
+			fmt.Printf("  Up since: %s (%s)\n", m.Since.Format(time.RFC1123), m.Uptime)
+		}
+	}
+
+	if r := i.Resources; r != nil {
+		fmt.Printf("  Memory: %s", system.FormatBytes(int64(r.MemoryCurrent)))
+		if r.MemoryPeak != 0 {
+			fmt.Printf(" (peak %s)", system.FormatBytes(int64(r.MemoryPeak)))
+		}
+		fmt.Println()
+		fmt.Printf("  CPU: %s\n", (time.Duration(r.CPUUsageUsec) * time.Microsecond).Round(time.Millisecond))
+		fmt.Printf("  IO: read %s, written %s\n", system.FormatBytes(int64(r.IOReadBytes)), system.FormatBytes(int64(r.IOWriteBytes)))
+		fmt.Printf("  Tasks: %d\n", r.Tasks)
+	}
+
+	if len(i.Interfaces) > 0 {
+		fmt.Printf("  Interfaces:\n")
+		for _, n := range i.Interfaces {
+			fmt.Printf("    %s (%s) %s %s\n", n.Name, n.State, n.MAC, strings.Join(n.Addresses, " "))
+		}
+	}
+
+	if len(i.Mounts) > 0 {
+		fmt.Printf("  Bind mounts:\n")
+		for _, m := range i.Mounts {
+			fmt.Printf("    %s:%s -> %s\n", m.Device, m.Source, m.Target)
+		}
+	}
+}
+
+func inspectCommand(cfg *conf.Config) *cli.Command {
+	return &cli.Command{
+		Name:  "inspect",
+		Usage: "[NAME] show configuration, state, resource usage, network and mounts of a container",
+		Flags: []cli.Flag{
+			formatFlag("text", "json"),
+		},
+		Action: func(c *cli.Context) error {
+			if c.NArg() != 1 {
+				return usageError(c, "wrong number of arguments")
+			}
+
+			i, err := container.Inspect(cfg.System.StorageDir, c.Args().First())
+			if err != nil {
+				return fmt.Errorf("failed to inspect container: %w", err)
+			}
+
+			if c.String("format") == "json" {
+				e := json.NewEncoder(os.Stdout)
+				e.SetIndent("", "  ")
+				return e.Encode(i)
+			}
+
+			displayInfo(i)
+			return nil
+		},
+	}
+}
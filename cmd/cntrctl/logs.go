@@ -0,0 +1,71 @@
+// SPDX-License-Identifier: BSD-2
+// Copyright 2023 VMware, Inc.
+
+package main
+
+import (
+	"github.com/urfave/cli/v2"
+
+	"github.com/vmware-samples/photon-os-container-builder/pkg/conf"
+	"github.com/vmware-samples/photon-os-container-builder/pkg/container"
+)
+
+func logsCommand(cfg *conf.Config) *cli.Command {
+	return &cli.Command{
+		Name:  "logs",
+		Usage: "[NAME] show the journal of a container, running or stopped",
+		Flags: []cli.Flag{
+			&cli.BoolFlag{
+				Name:    "follow",
+				Aliases: []string{"f"},
+				Usage:   "Follow the journal",
+			},
+			&cli.StringFlag{
+				Name:    "since",
+				Aliases: []string{"S"},
+				Usage:   "Show entries not older than the specified date (e.g. '1 hour ago', '2023-05-01 10:00')",
+			},
+			&cli.StringFlag{
+				Name:    "unit",
+				Aliases: []string{"u"},
+				Usage:   "Show entries of this unit inside the container",
+			},
+			&cli.BoolFlag{
+				Name:    "boot",
+				Aliases: []string{"b"},
+				Usage:   "Show entries of the current boot only",
+			},
+			&cli.IntFlag{
+				Name:    "lines",
+				Aliases: []string{"n"},
+				Usage:   "Number of most recent entries to show",
+			},
+			&cli.StringFlag{
+				Name:    "output-mode",
+				Aliases: []string{"o"},
+				Usage:   "journalctl output mode (short, json, json-pretty, cat, ...)",
+			},
+			&cli.BoolFlag{
+				Name:  "service",
+				Usage: "Show the host side journal of the container service unit instead",
+			},
+		},
+		Action: func(c *cli.Context) error {
+			if c.NArg() != 1 {
+				return usageError(c, "wrong number of arguments")
+			}
+
+			o := container.LogOptions{
+				Follow:  c.Bool("follow"),
+				Since:   c.String("since"),
+				Unit:    c.String("unit"),
+				Boot:    c.Bool("boot"),
+				Lines:   c.Int("lines"),
+				Output:  c.String("output-mode"),
+				Service: c.Bool("service"),
+			}
+
+			return container.Logs(cfg.System.StorageDir, c.Args().First(), &o)
+		},
+	}
+}
@@ -6,12 +6,18 @@ package main
 import (
 	"fmt"
 	"os"
+	"time"
 
+	log "github.com/sirupsen/logrus"
 	"github.com/urfave/cli/v2"
 
 	"github.com/vmware-samples/photon-os-container-builder/pkg/conf"
 	"github.com/vmware-samples/photon-os-container-builder/pkg/container"
-	"github.com/vmware-samples/photon-os-container-builder/pkg/systemd"
+	"github.com/vmware-samples/photon-os-container-builder/pkg/errdefs"
+	"github.com/vmware-samples/photon-os-container-builder/pkg/lock"
+	"github.com/vmware-samples/photon-os-container-builder/pkg/logging"
+	"github.com/vmware-samples/photon-os-container-builder/pkg/profile"
+	"github.com/vmware-samples/photon-os-container-builder/pkg/system"
 )
 
 func main() {
@@ -19,7 +25,8 @@ func main() {
 		fmt.Printf("version=%s\n", c.App.Version)
 	}
 
-	cfg, _ := conf.Parse()
+	// Filled in by Before once the flags are parsed, commands hold on to the pointer.
+	cfg := &conf.Config{}
 
 	app := &cli.App{
 		Name:        "cntrctl",
@@ -33,16 +40,93 @@ func main() {
 	app.EnableBashCompletion = true
 	app.UseShortOptionHandling = true
 
+	app.Flags = []cli.Flag{
+		outputFlag(),
+		&cli.StringFlag{
+			Name:  "config",
+			Usage: "Configuration file applied over the system and user configuration",
+		},
+		&cli.StringFlag{
+			Name:  "log-level",
+			Usage: "Log level (error, warn, info, debug, trace), overrides LogLevel of the configuration",
+		},
+		&cli.StringFlag{
+			Name:  "log-format",
+			Usage: "Log format (text, json, journal), overrides LogFormat of the configuration",
+		},
+		&cli.DurationFlag{
+			Name:  "lock-timeout",
+			Value: lock.DefaultTimeout,
+			Usage: "How long to wait for a container another cntrctl works on, overrides LockTimeout of the configuration",
+		},
+		&cli.BoolFlag{
+			Name:  "dry-run",
+			Usage: "Print the commands, file contents and systemd calls instead of applying them",
+		},
+	}
+
+	app.Before = func(c *cli.Context) error {
+		switch c.String("output") {
+		case "text", "json":
+			errorOutput = c.String("output")
+		default:
+			return errdefs.Errorf(errdefs.ErrInvalid, "unsupported output '%s'", c.String("output"))
+		}
+
+		loaded, cfgErr := conf.Load(c.String("config"))
+		*cfg = *loaded
+		if cfgErr != nil && c.IsSet("config") {
+			return cfgErr
+		}
+		system.UnitDir = cfg.System.UnitDir
+
+		o := logging.Options{
+			Level:  cfg.System.LogLevel,
+			Format: cfg.System.LogFormat,
+		}
+		if c.IsSet("log-level") {
+			o.Level = c.String("log-level")
+		}
+		if c.IsSet("log-format") {
+			o.Format = c.String("log-format")
+		}
+
+		if err := logging.Setup(&o); err != nil {
+			return err
+		}
+
+		if cfgErr != nil {
+			log.Warnf("%v, using defaults", cfgErr)
+		}
+
+		if d, err := time.ParseDuration(cfg.System.LockTimeout); err == nil {
+			lock.Timeout = d
+		}
+		if c.IsSet("lock-timeout") {
+			lock.Timeout = c.Duration("lock-timeout")
+		}
+
+		if c.Bool("dry-run") {
+			system.SetExecutor(system.NewDryRunExecutor(os.Stdout))
+		}
+
+		return nil
+	}
+
 	app.Commands = []*cli.Command{
 		{
 			Name:    "spawn",
 			Aliases: []string{"s"},
 			Usage:   "[NAME] Spawn a container",
 			Flags: []cli.Flag{
+				&cli.StringFlag{
+					Name:  "profile",
+					Usage: "Package profile to install, see 'cntrctl profile ls'",
+				},
 				&cli.StringFlag{
 					Name:    "packages",
 					Aliases: []string{"p"},
-					Usage:   "List of packages to install separated by ','",
+					Usage:   "Packages separated by ',' (NAME, +NAME, -NAME, @FILE), edits the profile when prefixed",
 				},
 				&cli.StringFlag{
 					Name:    "release",
@@ -74,10 +158,31 @@ func main() {
 					Aliases: []string{"m"},
 					Usage:   "Sets the machine name for this container",
 				},
+				&cli.StringFlag{
+					Name:  "lock",
+					Usage: "Install exactly the package versions recorded in this lockfile",
+				},
+				&cli.BoolFlag{
+					Name:  "offline",
+					Usage: "Install only from the shared package cache",
+				},
+				&cli.StringSliceFlag{
+					Name:  "slim",
+					Usage: "Prune the rootfs after installation with profiles (minimal, docs, cache, locales:en_US)",
+				},
+				&cli.StringFlag{
+					Name:  "pool",
+					Usage: "Storage pool to create the container in, see 'cntrctl pool ls'",
+				},
 			},
 			Action: func(c *cli.Context) error {
 				if c.NArg() != 1 {
-					cli.ShowAppHelpAndExit(c, 1)
+					return usageError(c, "expected a container name")
+				}
+
+				// The lockfile is the complete package set
+				if c.String("lock") != "" && (c.IsSet("packages") || c.IsSet("profile")) {
+					return usageError(c, "--lock cannot be combined with --packages or --profile")
 				}
 
 				release := c.String("release")
@@ -88,29 +193,31 @@ func main() {
 				dir := c.Bool("dir")
 				ephemeral := c.Bool("ephemeral")
 
-				if network != "" && link == "" {
-					fmt.Printf("network='%s' is specified but link is missing", network)
-					os.Exit(1)
-				} else if link != "" && network == "" {
-					fmt.Printf("link='%s' is specified but network is missing", link)
-					os.Exit(1)
+				if err := checkNetwork(network, link); err != nil {
+					return err
+				}
+				if network == "" {
+					network, link = cfg.Network.Type, cfg.Network.Link
+				}
+
+				if c.Bool("offline") {
+					cfg.Cache.Offline = true
 				}
 
-				if release == "" {
+				if release == "" && c.String("lock") == "" {
 					release = cfg.System.Release
 				}
 
-				if c.String("packages") == "" {
-					if err := container.Spawn(conf.DefaultStorageDir, c.Args().First(), release, conf.DefaultPackages, network, link, machine, dir, ephemeral); err != nil {
-						os.Exit(1)
-					}
-				} else {
-					if err := container.Spawn(conf.DefaultStorageDir, c.Args().First(), release, c.String("packages"), network, link, machine, dir, ephemeral); err != nil {
-						os.Exit(1)
+				var packages string
+				if c.String("lock") == "" {
+					p, err := profile.Packages(cfg, c.String("profile"), release, c.String("packages"))
+					if err != nil {
+						return err
 					}
+					packages = p
 				}
 
-				return nil
+				return container.Spawn(cfg, cfg.System.StorageDir, c.Args().First(), release, packages, network, link, machine, dir, ephemeral, c.String("lock"), c.StringSlice("slim"), c.String("pool"))
 			},
 		},
 		{
@@ -138,29 +245,34 @@ func main() {
 					Aliases: []string{"m"},
 					Usage:   "Sets the machine name for this container",
 				},
+				&cli.StringSliceFlag{
+					Name:  "bind",
+					Usage: "Bind mount a host path into the container (SRC[:DST])",
+				},
+				&cli.StringSliceFlag{
+					Name:  "bind-ro",
+					Usage: "Bind mount a host path read-only into the container (SRC[:DST])",
+				},
+				&cli.BoolFlag{
+					Name:  "keep-unit",
+					Usage: "Run the container in the service unit invoking boot instead of a scope of its own",
+				},
 			},
 
 			Action: func(c *cli.Context) error {
 				if c.NArg() != 1 {
-					cli.ShowAppHelpAndExit(c, 1)
+					return usageError(c, "expected a container name")
 				}
 
 				network := c.String("network")
 				link := c.String("link")
 				machine := c.String("machine")
 
-				if network != "" && link == "" {
-					fmt.Printf("network='%s' is specified but link is missing", network)
-					os.Exit(1)
-				} else if link != "" && network == "" {
-					fmt.Printf("link='%s' is specified but network is missing", link)
-					os.Exit(1)
+				if err := checkNetwork(network, link); err != nil {
+					return err
 				}
 
-				if err := container.Boot(cfg, conf.DefaultStorageDir, c.Args().First(), network, link, machine, c.Bool("ephemeral")); err != nil {
-					os.Exit(1)
-				}
-				return nil
+				return exitStatus(container.Boot(cfg, cfg.System.StorageDir, c.Args().First(), network, link, machine, c.Bool("ephemeral"), c.Bool("keep-unit"), c.StringSlice("bind"), c.StringSlice("bind-ro")))
 			},
 		},
 		{
@@ -191,87 +303,52 @@ func main() {
 			},
 			Action: func(c *cli.Context) error {
 				if c.NArg() < 1 {
-					cli.ShowAppHelpAndExit(c, 1)
+					return usageError(c, "expected a container name")
 				}
 
 				network := c.String("network")
 				link := c.String("link")
 				machine := c.String("machine")
 
-				if network != "" && link == "" {
-					fmt.Printf("network='%s' is specified but link is missing", network)
-					os.Exit(1)
-				} else if link != "" && network == "" {
-					fmt.Printf("link='%s' is specified but network is missing", link)
-					os.Exit(1)
+				if err := checkNetwork(network, link); err != nil {
+					return err
 				}
 
-				if err := container.JumpStart(cfg, conf.DefaultStorageDir, c.Args().First(), network, link, machine, c.Bool("ephemeral")); err != nil {
-					fmt.Println(err)
-					os.Exit(1)
-				}
-				return nil
-			},
-		},
-		{
-			Name:  "start",
-			Usage: "[NAME] start container as a systemd service unit (use host networking)",
-			Action: func(c *cli.Context) error {
-				if c.NArg() < 1 {
-					cli.ShowAppHelpAndExit(c, 1)
-				}
-				u := systemd.Unit{
-					Name:    c.Args().First(),
-					Command: "start",
-				}
-
-				if err := u.ApplyCommand(); err != nil {
-					os.Exit(1)
-				}
-				return nil
-			},
-		},
-		{
-			Name:  "stop",
-			Usage: "[NAME] stop container as a systemd service unit",
-			Action: func(c *cli.Context) error {
-				if c.NArg() < 1 {
-					cli.ShowAppHelpAndExit(c, 1)
-				}
-
-				u := systemd.Unit{
-					Name:    c.Args().First(),
-					Command: "stop",
-				}
-
-				if err := u.ApplyCommand(); err != nil {
-					os.Exit(1)
-				}
-				return nil
-			},
-		},
-		{
-			Name:  "restart",
-			Usage: "[NAME] restart container as a systemd service unit",
-			Action: func(c *cli.Context) error {
-				if c.NArg() < 1 {
-					cli.ShowAppHelpAndExit(c, 1)
-				}
-
-				u := systemd.Unit{
-					Name:    c.Args().First(),
-					Command: "restart",
-				}
-
-				if err := u.ApplyCommand(); err != nil {
-					os.Exit(1)
-				}
-				return nil
+				return exitStatus(container.JumpStart(cfg, cfg.System.StorageDir, c.Args().First(), network, link, machine, c.Bool("ephemeral")))
 			},
 		},
+		startCommand(),
+		unitCommand("stop", "[NAME] stop container as a systemd service unit"),
+		unitCommand("restart", "[NAME] restart container as a systemd service unit"),
+		enableCommand(),
+		unitCommand("disable", "[NAME] disable container service unit from starting at boot"),
+		unitCommand("mask", "[NAME] mask container service unit"),
+		unitCommand("unmask", "[NAME] unmask container service unit"),
+		statusCommand(),
+		waitCommand(),
+		inspectCommand(cfg),
+		logsCommand(cfg),
+		cpCommand(cfg),
+		testCommand(cfg),
+		matrixCommand(cfg),
+		composeCommand(cfg),
+		serveCommand(cfg),
+		packagesCommand(cfg),
+		updateLockCommand(cfg),
+		cacheCommand(cfg),
+		configCommand(cfg),
+		profileCommand(cfg),
+		recoverCommand(cfg),
+		listCommand(cfg),
+		removeCommand(cfg),
+		moveCommand(cfg),
+		cloneCommand(cfg),
+		poolCommand(cfg),
+		dfCommand(cfg),
+		quotaCommand(cfg),
+		pruneCommand(cfg),
+		verifySignaturesCommand(cfg),
 	}
 
-	if err := app.Run(os.Args); err != nil {
-		fmt.Println(err)
-	}
+	exit(app.Run(os.Args))
 }
@@ -0,0 +1,225 @@
+// SPDX-License-Identifier: BSD-2
+// Copyright 2023 VMware, Inc.
+
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"text/tabwriter"
+
+	"github.com/urfave/cli/v2"
+
+	"github.com/vmware-samples/photon-os-container-builder/pkg/conf"
+	"github.com/vmware-samples/photon-os-container-builder/pkg/container"
+	"github.com/vmware-samples/photon-os-container-builder/pkg/errdefs"
+	"github.com/vmware-samples/photon-os-container-builder/pkg/rpm"
+	"github.com/vmware-samples/photon-os-container-builder/pkg/sbom"
+)
+
+// nopCloser keeps the caller from closing stdout.
+type nopCloser struct {
+	io.Writer
+}
+
+func (nopCloser) Close() error {
+	return nil
+}
+
+func openOutput(file string) (io.WriteCloser, error) {
+	if file == "" || file == "-" {
+		return nopCloser{os.Stdout}, nil
+	}
+
+	return os.Create(file)
+}
+
+func displayPackages(w io.Writer, pkgs []rpm.Package) {
+	t := tabwriter.NewWriter(w, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(t, "NAME\tVERSION\tRELEASE\tARCH\tLICENSE\tSOURCE RPM")
+	for _, p := range pkgs {
+		v := p.Version
+		if p.Epoch != "" && p.Epoch != "0" {
+			v = p.Epoch + ":" + v
+		}
+		fmt.Fprintf(t, "%s\t%s\t%s\t%s\t%s\t%s\n", p.Name, v, p.Release, p.Arch, p.License, p.SourceRPM)
+	}
+	t.Flush()
+}
+
+func displayPackageDiff(w io.Writer, d *rpm.Diff) {
+	t := tabwriter.NewWriter(w, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(t, "\tNAME\tARCH\tFROM\tTO")
+	for _, p := range d.Added {
+		fmt.Fprintf(t, "+\t%s\t%s\t\t%s\n", p.Name, p.Arch, p.EVR())
+	}
+	for _, p := range d.Removed {
+		fmt.Fprintf(t, "-\t%s\t%s\t%s\t\n", p.Name, p.Arch, p.EVR())
+	}
+	for _, c := range d.Changed {
+		fmt.Fprintf(t, "~\t%s\t%s\t%s\t%s\n", c.Name, c.To.Arch, c.From.EVR(), c.To.EVR())
+	}
+	t.Flush()
+
+	fmt.Fprintf(w, "\n%d added, %d removed, %d changed\n", len(d.Added), len(d.Removed), len(d.Changed))
+}
+
+func packagesCommand(cfg *conf.Config) *cli.Command {
+	return &cli.Command{
+		Name:      "packages",
+		Aliases:   []string{"pkg"},
+		Usage:     "[NAME] List packages installed in a container or export them as SBOM",
+		ArgsUsage: "NAME|PATH",
+		Flags: []cli.Flag{
+			formatFlag("table", "json", "spdx", "cyclonedx"),
+			&cli.StringFlag{
+				Name:  "output-file",
+				Usage: "Write output to file instead of stdout",
+			},
+		},
+		Subcommands: []*cli.Command{
+			{
+				Name:      "diff",
+				Usage:     "[NAME1] [NAME2] Show packages added, removed and changed between two containers or snapshots",
+				ArgsUsage: "NAME1|PATH1 NAME2|PATH2",
+				Flags: []cli.Flag{
+					formatFlag("table", "json"),
+				},
+				Action: func(c *cli.Context) error {
+					if c.NArg() != 2 {
+						return usageError(c, "wrong number of arguments")
+					}
+
+					d, err := container.DiffPackages(cfg.System.StorageDir, c.Args().Get(0), c.Args().Get(1))
+					if err != nil {
+						return fmt.Errorf("failed to compare packages: %w", err)
+					}
+
+					switch c.String("format") {
+					case "json":
+						e := json.NewEncoder(os.Stdout)
+						e.SetIndent("", "  ")
+						e.Encode(d)
+					case "table":
+						displayPackageDiff(os.Stdout, d)
+					default:
+						return errdefs.Errorf(errdefs.ErrInvalid, "unsupported format '%s'", c.String("format"))
+					}
+
+					return nil
+				},
+			},
+		},
+		Action: func(c *cli.Context) error {
+			if c.NArg() != 1 {
+				return usageError(c, "wrong number of arguments")
+			}
+
+			pkgs, err := container.Packages(cfg.System.StorageDir, c.Args().First())
+			if err != nil {
+				return fmt.Errorf("failed to list packages: %w", err)
+			}
+
+			w, err := openOutput(c.String("output-file"))
+			if err != nil {
+				return fmt.Errorf("failed to open output file '%s': %w", c.String("output-file"), err)
+			}
+
+			switch c.String("format") {
+			case "table":
+				displayPackages(w, pkgs)
+			case "json":
+				e := json.NewEncoder(w)
+				e.SetIndent("", "  ")
+				err = e.Encode(pkgs)
+			case sbom.FormatSPDX, sbom.FormatCycloneDX:
+				err = sbom.Write(w, c.String("format"), c.Args().First(), pkgs)
+			default:
+				w.Close()
+				return errdefs.Errorf(errdefs.ErrInvalid, "unsupported format '%s'", c.String("format"))
+			}
+
+			// A full disk may only show when the file is closed
+			if cerr := w.Close(); err == nil {
+				err = cerr
+			}
+			if err != nil {
+				return fmt.Errorf("failed to write packages of '%s': %w", c.Args().First(), err)
+			}
+
+			return nil
+		},
+	}
+}
+
+func updateLockCommand(cfg *conf.Config) *cli.Command {
+	return &cli.Command{
+		Name:  "update-lock",
+		Usage: "[NAME] Update packages of a container and refresh its lockfile",
+		Flags: []cli.Flag{
+			&cli.StringFlag{
+				Name:  "output-file",
+				Usage: "Write lockfile to file instead of the default location",
+			},
+			&cli.BoolFlag{
+				Name:  "no-update",
+				Usage: "Only record the currently installed packages without running tdnf update",
+			},
+		},
+		Action: func(c *cli.Context) error {
+			if c.NArg() != 1 {
+				return usageError(c, "wrong number of arguments")
+			}
+
+			return container.UpdateLock(cfg, os.Stdout, cfg.System.StorageDir, c.Args().First(), c.String("output-file"), !c.Bool("no-update"))
+		},
+	}
+}
+
+func verifySignaturesCommand(cfg *conf.Config) *cli.Command {
+	return &cli.Command{
+		Name:  "verify-signatures",
+		Usage: "[NAME] Report installed packages that are unsigned or signed by an unexpected key",
+		Flags: []cli.Flag{
+			&cli.StringFlag{
+				Name:    "release",
+				Aliases: []string{"r"},
+				Usage:   "Photon OS release whose keys are trusted (detected from the container by default)",
+			},
+			formatFlag("table", "json"),
+		},
+		Action: func(c *cli.Context) error {
+			if c.NArg() != 1 {
+				return usageError(c, "wrong number of arguments")
+			}
+
+			issues, err := container.VerifySignatures(cfg, cfg.System.StorageDir, c.Args().First(), c.String("release"))
+			if err != nil {
+				return err
+			}
+
+			if c.String("format") == "json" {
+				e := json.NewEncoder(os.Stdout)
+				e.SetIndent("", "  ")
+				e.Encode(issues)
+			} else if len(issues) > 0 {
+				t := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+				fmt.Fprintln(t, "PACKAGE\tKEY ID\tREASON")
+				for _, i := range issues {
+					fmt.Fprintf(t, "%s\t%s\t%s\n", i.Package, i.KeyID, i.Reason)
+				}
+				t.Flush()
+			} else {
+				fmt.Printf("All packages in '%s' are signed by trusted keys\n", c.Args().First())
+			}
+
+			if len(issues) > 0 {
+				return cli.Exit("", exitFailure)
+			}
+
+			return nil
+		},
+	}
+}
@@ -0,0 +1,130 @@
+// SPDX-License-Identifier: BSD-2
+// Copyright 2023 VMware, Inc.
+
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"strings"
+	"text/tabwriter"
+
+	"github.com/urfave/cli/v2"
+
+	"github.com/vmware-samples/photon-os-container-builder/pkg/conf"
+	"github.com/vmware-samples/photon-os-container-builder/pkg/profile"
+)
+
+type profileEntry struct {
+	Name        string   `json:"name"`
+	Description string   `json:"description,omitempty"`
+	Include     []string `json:"include,omitempty"`
+	Release     string   `json:"release"`
+	Packages    []string `json:"packages"`
+}
+
+func profileCommand(cfg *conf.Config) *cli.Command {
+	releaseFlag := &cli.StringFlag{
+		Name:    "release",
+		Aliases: []string{"r"},
+		Usage:   "Resolve per-release packages of this Photon OS release",
+	}
+	format := formatFlag("table", "json")
+
+	release := func(c *cli.Context) string {
+		if c.String("release") != "" {
+			return c.String("release")
+		}
+
+		return cfg.System.Release
+	}
+
+	return &cli.Command{
+		Name:  "profile",
+		Usage: "List and show the package profiles selectable with --profile",
+		Subcommands: []*cli.Command{
+			{
+				Name:  "ls",
+				Usage: "List package profiles with the number of resolved packages",
+				Flags: []cli.Flag{releaseFlag, format},
+				Action: func(c *cli.Context) error {
+					var entries []profileEntry
+					for _, name := range cfg.PackageProfiles() {
+						p, _ := cfg.PackageProfile(name)
+
+						pkgs, err := profile.Resolve(cfg, name, release(c), "")
+						if err != nil {
+							return err
+						}
+
+						entries = append(entries, profileEntry{
+							Name:        name,
+							Description: p.Description,
+							Include:     p.Include,
+							Release:     release(c),
+							Packages:    pkgs,
+						})
+					}
+
+					if c.String("format") == "json" {
+						e := json.NewEncoder(os.Stdout)
+						e.SetIndent("", "  ")
+						return e.Encode(entries)
+					}
+
+					t := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+					fmt.Fprintln(t, "NAME\tPACKAGES\tINCLUDE\tDESCRIPTION")
+					for _, e := range entries {
+						fmt.Fprintf(t, "%s\t%d\t%s\t%s\n", e.Name, len(e.Packages), strings.Join(e.Include, ","), e.Description)
+					}
+					t.Flush()
+
+					return nil
+				},
+			},
+			{
+				Name:      "show",
+				Usage:     "Print the resolved packages of a profile, one per line",
+				ArgsUsage: "NAME",
+				Flags: []cli.Flag{
+					releaseFlag,
+					format,
+					&cli.StringFlag{
+						Name:    "packages",
+						Aliases: []string{"p"},
+						Usage:   "Edits applied to the profile as with spawn (+NAME, -NAME, @FILE)",
+					},
+				},
+				Action: func(c *cli.Context) error {
+					if c.NArg() != 1 {
+						return usageError(c, "expected a profile name")
+					}
+
+					name := c.Args().First()
+					pkgs, err := profile.Resolve(cfg, name, release(c), c.String("packages"))
+					if err != nil {
+						return err
+					}
+
+					if c.String("format") == "json" {
+						p, _ := cfg.PackageProfile(name)
+
+						e := json.NewEncoder(os.Stdout)
+						e.SetIndent("", "  ")
+						return e.Encode(profileEntry{
+							Name:        name,
+							Description: p.Description,
+							Include:     p.Include,
+							Release:     release(c),
+							Packages:    pkgs,
+						})
+					}
+
+					fmt.Println(strings.Join(pkgs, "\n"))
+					return nil
+				},
+			},
+		},
+	}
+}
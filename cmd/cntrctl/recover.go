@@ -0,0 +1,150 @@
+// SPDX-License-Identifier: BSD-2
+// Copyright 2023 VMware, Inc.
+
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"os"
+	"strings"
+	"text/tabwriter"
+
+	"github.com/urfave/cli/v2"
+
+	"github.com/vmware-samples/photon-os-container-builder/pkg/conf"
+	"github.com/vmware-samples/photon-os-container-builder/pkg/container"
+	"github.com/vmware-samples/photon-os-container-builder/pkg/errdefs"
+	"github.com/vmware-samples/photon-os-container-builder/pkg/lock"
+)
+
+func recoverCommand(cfg *conf.Config) *cli.Command {
+	name := func(c *cli.Context) (string, error) {
+		if c.NArg() != 1 {
+			return "", usageError(c, "expected a container name")
+		}
+
+		return c.Args().First(), nil
+	}
+
+	return &cli.Command{
+		Name:  "recover",
+		Usage: "List, show, resume or roll back spawns interrupted by a crash or signal",
+		Subcommands: []*cli.Command{
+			{
+				Name:  "ls",
+				Usage: "List interrupted spawns with their completed and pending steps",
+				Flags: []cli.Flag{
+					formatFlag("table", "json"),
+				},
+				Action: func(c *cli.Context) error {
+					journals, err := container.Interrupted()
+					if err != nil {
+						return err
+					}
+
+					if c.String("format") == "json" {
+						e := json.NewEncoder(os.Stdout)
+						e.SetIndent("", "  ")
+						return e.Encode(journals)
+					}
+
+					t := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+					fmt.Fprintln(t, "NAME\tOPERATION\tPID\tSTATE")
+					for _, j := range journals {
+						state := "interrupted"
+						if lock.Held(j.Container) {
+							state = "running"
+						}
+						fmt.Fprintf(t, "%s\t%s\t%d\t%s\n", j.Container, j.Operation, j.PID, state)
+					}
+					t.Flush()
+
+					return nil
+				},
+			},
+			{
+				Name:      "show",
+				Usage:     "Show the steps of an interrupted spawn and what rolling them back removes",
+				ArgsUsage: "NAME",
+				Flags: []cli.Flag{
+					formatFlag("text", "json"),
+				},
+				Action: func(c *cli.Context) error {
+					n, err := name(c)
+					if err != nil {
+						return err
+					}
+
+					journals, err := container.Interrupted()
+					if err != nil {
+						return err
+					}
+
+					for _, j := range journals {
+						if j.Container != n {
+							continue
+						}
+
+						if c.String("format") == "json" {
+							e := json.NewEncoder(os.Stdout)
+							e.SetIndent("", "  ")
+							return e.Encode(j)
+						}
+
+						fmt.Println(j)
+						t := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+						fmt.Fprintln(t, "STEP\tSTATE\tUNDO")
+						for _, s := range j.Steps {
+							undo := strings.Join(s.Undo.Remove, " ")
+							if s.Undo.Reload {
+								undo = strings.TrimSpace(undo + " (reload)")
+							}
+							fmt.Fprintf(t, "%s\t%s\t%s\n", s.Name, s.State, undo)
+						}
+						return t.Flush()
+					}
+
+					return errdefs.Errorf(errdefs.ErrNotFound, "no interrupted operation of '%s'", n)
+				},
+			},
+			{
+				Name:      "resume",
+				Usage:     "Continue an interrupted spawn from the step it was interrupted in",
+				ArgsUsage: "NAME",
+				Action: func(c *cli.Context) error {
+					n, err := name(c)
+					if err != nil {
+						return err
+					}
+
+					if err := container.Resume(context.Background(), cfg, os.Stdout, cfg.System.StorageDir, n); err != nil {
+						return err
+					}
+
+					fmt.Printf("Spawn of '%s' completed\n", n)
+					return nil
+				},
+			},
+			{
+				Name:      "rollback",
+				Usage:     "Undo an interrupted spawn: remove its rootfs, lockfile and unit",
+				ArgsUsage: "NAME",
+				Action: func(c *cli.Context) error {
+					n, err := name(c)
+					if err != nil {
+						return err
+					}
+
+					if err := container.Rollback(n); err != nil {
+						return err
+					}
+
+					fmt.Printf("Spawn of '%s' rolled back\n", n)
+					return nil
+				},
+			},
+		},
+	}
+}
@@ -0,0 +1,48 @@
+// SPDX-License-Identifier: BSD-2
+// Copyright 2023 VMware, Inc.
+
+package main
+
+import (
+	"fmt"
+
+	"github.com/urfave/cli/v2"
+
+	"github.com/vmware-samples/photon-os-container-builder/pkg/api"
+	"github.com/vmware-samples/photon-os-container-builder/pkg/conf"
+)
+
+func serveCommand(cfg *conf.Config) *cli.Command {
+	return &cli.Command{
+		Name:  "serve",
+		Usage: "Run as daemon serving a JSON API on a unix socket",
+		Flags: []cli.Flag{
+			&cli.StringFlag{
+				Name:  "socket",
+				Value: api.DefaultSocket,
+				Usage: "Path of the unix socket",
+			},
+			&cli.StringFlag{
+				Name:  "group",
+				Usage: "Group whose members may use the whole API (root is always allowed)",
+			},
+			&cli.StringFlag{
+				Name:  "read-group",
+				Usage: "Group whose members may only use GET requests",
+			},
+		},
+		Action: func(c *cli.Context) error {
+			o := api.Options{
+				Socket:    c.String("socket"),
+				Group:     c.String("group"),
+				ReadGroup: c.String("read-group"),
+			}
+
+			if err := api.Serve(cfg, cfg.System.StorageDir, &o); err != nil {
+				return fmt.Errorf("failed to serve API on '%s': %w", o.Socket, err)
+			}
+
+			return nil
+		},
+	}
+}
@@ -0,0 +1,180 @@
+// SPDX-License-Identifier: BSD-2
+// Copyright 2023 VMware, Inc.
+
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"os"
+	"text/tabwriter"
+
+	log "github.com/sirupsen/logrus"
+	"github.com/urfave/cli/v2"
+
+	"github.com/vmware-samples/photon-os-container-builder/pkg/conf"
+	"github.com/vmware-samples/photon-os-container-builder/pkg/container"
+)
+
+type poolEntry struct {
+	Name       string   `json:"name"`
+	Dir        string   `json:"dir"`
+	Containers []string `json:"containers"`
+}
+
+func storageFormatFlag() cli.Flag {
+	return formatFlag("table", "json")
+}
+
+func listCommand(cfg *conf.Config) *cli.Command {
+	return &cli.Command{
+		Name:    "list",
+		Aliases: []string{"ls"},
+		Usage:   "List containers of all storage pools with the state of their service units",
+		Flags:   []cli.Flag{storageFormatFlag()},
+		Action: func(c *cli.Context) error {
+			l, err := container.List(cfg, cfg.System.StorageDir)
+			if err != nil {
+				return err
+			}
+
+			if c.String("format") == "json" {
+				e := json.NewEncoder(os.Stdout)
+				e.SetIndent("", "  ")
+				return e.Encode(l)
+			}
+
+			t := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+			fmt.Fprintln(t, "NAME\tPOOL\tMACHINE\tRUNNING\tSTATE\tQUOTA\tPATH")
+			for _, s := range l {
+				quota := "-"
+				if s.Quota != nil {
+					quota = s.Quota.String()
+				}
+				state := s.State
+				if s.Error != "" {
+					state = "broken"
+				}
+				fmt.Fprintf(t, "%s\t%s\t%s\t%t\t%s\t%s\t%s\n", s.Name, s.Pool, s.Machine, s.Running, state, quota, s.Path)
+			}
+			t.Flush()
+
+			for _, s := range l {
+				if s.Error != "" {
+					log.Warnf("'%s' is broken, %s, remove it with 'cntrctl remove %s'", s.Name, s.Error, s.Name)
+				}
+				if s.Quota != nil && s.Quota.Percent() >= container.QuotaWarnPercent {
+					log.Warnf("'%s' uses %d%% of its disk quota (%s)", s.Name, s.Quota.Percent(), s.Quota)
+				}
+			}
+
+			return nil
+		},
+	}
+}
+
+func removeCommand(cfg *conf.Config) *cli.Command {
+	return &cli.Command{
+		Name:      "remove",
+		Aliases:   []string{"rm"},
+		Usage:     "Delete a stopped container with its service unit and lockfile",
+		ArgsUsage: "NAME",
+		Action: func(c *cli.Context) error {
+			if c.NArg() != 1 {
+				return usageError(c, "expected a container name")
+			}
+
+			return container.Remove(cfg.System.StorageDir, c.Args().First())
+		},
+	}
+}
+
+func moveCommand(cfg *conf.Config) *cli.Command {
+	return &cli.Command{
+		Name:      "move",
+		Usage:     "Migrate a stopped container to another storage pool",
+		ArgsUsage: "NAME",
+		Flags: []cli.Flag{
+			&cli.StringFlag{
+				Name:     "pool",
+				Usage:    "Storage pool to move the container to",
+				Required: true,
+			},
+		},
+		Action: func(c *cli.Context) error {
+			if c.NArg() != 1 {
+				return usageError(c, "expected a container name")
+			}
+
+			return container.Move(context.Background(), cfg, os.Stdout, cfg.System.StorageDir, c.Args().First(), c.String("pool"))
+		},
+	}
+}
+
+func cloneCommand(cfg *conf.Config) *cli.Command {
+	return &cli.Command{
+		Name:      "clone",
+		Usage:     "Copy a stopped container to a new container, in the same or another storage pool",
+		ArgsUsage: "NAME NEW",
+		Flags: []cli.Flag{
+			&cli.StringFlag{
+				Name:  "pool",
+				Usage: "Storage pool to create the clone in (default: the pool of NAME)",
+			},
+		},
+		Action: func(c *cli.Context) error {
+			if c.NArg() != 2 {
+				return usageError(c, "expected the names of the container and its clone")
+			}
+
+			return container.Clone(context.Background(), cfg, os.Stdout, cfg.System.StorageDir, c.Args().Get(0), c.Args().Get(1), c.String("pool"))
+		},
+	}
+}
+
+func poolCommand(cfg *conf.Config) *cli.Command {
+	return &cli.Command{
+		Name:  "pool",
+		Usage: "List the storage pools selectable with --pool",
+		Subcommands: []*cli.Command{
+			{
+				Name:  "ls",
+				Usage: "List storage pools with their directory and containers",
+				Flags: []cli.Flag{storageFormatFlag()},
+				Action: func(c *cli.Context) error {
+					l, err := container.List(cfg, cfg.System.StorageDir)
+					if err != nil {
+						return err
+					}
+
+					var entries []poolEntry
+					for _, p := range cfg.StoragePools() {
+						e := poolEntry{Name: p.Name, Dir: p.Dir, Containers: []string{}}
+						for _, s := range l {
+							if s.Pool == p.Name {
+								e.Containers = append(e.Containers, s.Name)
+							}
+						}
+						entries = append(entries, e)
+					}
+
+					if c.String("format") == "json" {
+						e := json.NewEncoder(os.Stdout)
+						e.SetIndent("", "  ")
+						return e.Encode(entries)
+					}
+
+					t := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+					fmt.Fprintln(t, "NAME\tCONTAINERS\tDIR")
+					for _, e := range entries {
+						fmt.Fprintf(t, "%s\t%d\t%s\n", e.Name, len(e.Containers), e.Dir)
+					}
+					t.Flush()
+
+					return nil
+				},
+			},
+		},
+	}
+}
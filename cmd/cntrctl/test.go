@@ -0,0 +1,244 @@
+// SPDX-License-Identifier: BSD-2
+// Copyright 2023 VMware, Inc.
+
+package main
+
+import (
+	"fmt"
+	"os"
+	"path"
+	"text/tabwriter"
+	"time"
+
+	"github.com/urfave/cli/v2"
+
+	"github.com/vmware-samples/photon-os-container-builder/pkg/conf"
+	"github.com/vmware-samples/photon-os-container-builder/pkg/errdefs"
+	"github.com/vmware-samples/photon-os-container-builder/pkg/runner"
+	"github.com/vmware-samples/photon-os-container-builder/pkg/system"
+)
+
+func writeJUnit(file string, suite string, results []*runner.Result) error {
+	f, err := os.Create(file)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	return runner.WriteJUnit(f, suite, results)
+}
+
+// noDryRun refuses --dry-run for commands whose result is that of commands run for
+// real, such as the outcome and logs of a test suite.
+func noDryRun(c *cli.Context) error {
+	if system.DryRun() {
+		return errdefs.Errorf(errdefs.ErrInvalid, "'%s' cannot be combined with --dry-run", c.Command.Name)
+	}
+
+	return nil
+}
+
+func testCommand(cfg *conf.Config) *cli.Command {
+	return &cli.Command{
+		Name:   "test",
+		Usage:  "Run a test suite inside a fresh ephemeral container",
+		Before: noDryRun,
+		Flags: []cli.Flag{
+			&cli.StringFlag{
+				Name:    "release",
+				Aliases: []string{"r"},
+				Usage:   "Photon OS release version",
+			},
+			&cli.StringFlag{
+				Name:  "profile",
+				Usage: "Package profile to install, see 'cntrctl profile ls'",
+			},
+			&cli.StringFlag{
+				Name:    "packages",
+				Aliases: []string{"p"},
+				Usage:   "Packages separated by ',' (NAME, +NAME, -NAME, @FILE), edits the profile when prefixed",
+			},
+			&cli.StringFlag{
+				Name:  "clone",
+				Usage: "Run in an ephemeral snapshot of this existing container instead of building a new one",
+			},
+			&cli.StringFlag{
+				Name:    "suite",
+				Aliases: []string{"s"},
+				Usage:   "Host directory with the test suite, available as " + runner.SuiteDir + " inside the container",
+			},
+			&cli.StringFlag{
+				Name:     "cmd",
+				Aliases:  []string{"c"},
+				Required: true,
+				Usage:    "Command to run inside the container",
+			},
+			&cli.DurationFlag{
+				Name:    "timeout",
+				Aliases: []string{"t"},
+				Value:   runner.DefaultTimeout,
+				Usage:   "Fail the test when the command does not finish in time",
+			},
+			&cli.StringSliceFlag{
+				Name:    "artifact",
+				Aliases: []string{"a"},
+				Usage:   "Path inside the container to collect after the run (may be repeated)",
+			},
+			&cli.StringSliceFlag{
+				Name:    "env",
+				Aliases: []string{"e"},
+				Usage:   "Environment variable VAR=VALUE passed to the command (may be repeated)",
+			},
+			&cli.StringFlag{
+				Name:    "output-dir",
+				Aliases: []string{"o"},
+				Value:   runner.DefaultOutputDir,
+				Usage:   "Directory receiving logs, artifacts and reports",
+			},
+			&cli.StringFlag{
+				Name:  "junit",
+				Usage: "Write JUnit XML report to this file (default: OUTPUT/NAME/junit.xml)",
+			},
+			&cli.BoolFlag{
+				Name:  "keep-on-failure",
+				Usage: "Do not remove the container when the test fails",
+			},
+		},
+		Action: func(c *cli.Context) error {
+			release := c.String("release")
+			if release == "" {
+				release = cfg.System.Release
+			}
+
+			o := runner.Options{
+				Release:       release,
+				Profile:       c.String("profile"),
+				Packages:      c.String("packages"),
+				Clone:         c.String("clone"),
+				Suite:         c.String("suite"),
+				Command:       c.String("cmd"),
+				Timeout:       c.Duration("timeout"),
+				Artifacts:     c.StringSlice("artifact"),
+				Env:           c.StringSlice("env"),
+				OutputDir:     c.String("output-dir"),
+				KeepOnFailure: c.Bool("keep-on-failure"),
+				Output:        os.Stdout,
+			}
+
+			r, err := runner.Run(cfg, cfg.System.StorageDir, &o)
+			if err != nil {
+				return fmt.Errorf("failed to run test: %w", err)
+			}
+
+			junit := c.String("junit")
+			if junit == "" {
+				junit = path.Join(path.Dir(r.Log), "junit.xml")
+			}
+
+			if err := writeJUnit(junit, "cntrctl", []*runner.Result{r}); err != nil {
+				return fmt.Errorf("failed to write JUnit report '%s': %w", junit, err)
+			}
+
+			switch r.Status() {
+			case "ERROR":
+				fmt.Printf("ERROR %s: %s\n", r.Name, r.Error)
+			case "FAIL":
+				fmt.Printf("FAIL %s: exit status %d (%s)\n", r.Name, r.ExitCode, r.Duration.Round(time.Second))
+			default:
+				fmt.Printf("%s %s (%s)\n", r.Status(), r.Name, r.Duration.Round(time.Second))
+			}
+
+			fmt.Printf("Log: %s\nArtifacts: %s\nJUnit: %s\n", r.Log, r.Artifacts, junit)
+			if r.Kept != "" {
+				fmt.Printf("Kept container at '%s'\n", r.Kept)
+			}
+
+			if !r.Passed {
+				return cli.Exit("", exitFailure)
+			}
+
+			return nil
+		},
+	}
+}
+
+func matrixCommand(cfg *conf.Config) *cli.Command {
+	return &cli.Command{
+		Name:      "matrix",
+		Usage:     "[SPEC] Run a test matrix of releases, package profiles and commands in parallel containers",
+		ArgsUsage: "SPEC",
+		Before:    noDryRun,
+		Flags: []cli.Flag{
+			&cli.IntFlag{
+				Name:    "concurrency",
+				Aliases: []string{"j"},
+				Usage:   "Maximum number of containers running at the same time (overrides the spec)",
+			},
+			&cli.StringFlag{
+				Name:    "output-dir",
+				Aliases: []string{"o"},
+				Value:   runner.DefaultOutputDir,
+				Usage:   "Directory receiving logs, artifacts and reports",
+			},
+			&cli.BoolFlag{
+				Name:  "keep-on-failure",
+				Usage: "Do not remove containers of failed cells",
+			},
+		},
+		Action: func(c *cli.Context) error {
+			if c.NArg() != 1 {
+				return usageError(c, "wrong number of arguments")
+			}
+
+			m, err := runner.LoadMatrix(c.Args().First())
+			if err != nil {
+				return fmt.Errorf("failed to load matrix '%s': %w", c.Args().First(), err)
+			}
+
+			if c.Int("concurrency") > 0 {
+				m.Concurrency = c.Int("concurrency")
+			}
+
+			cells, err := m.Expand(c.String("output-dir"))
+			if err != nil {
+				return fmt.Errorf("failed to expand matrix '%s': %w", m.Name, err)
+			}
+
+			for _, cell := range cells {
+				cell.Options.KeepOnFailure = c.Bool("keep-on-failure")
+			}
+
+			fmt.Printf("Running %d cells of matrix '%s' with concurrency %d\n", len(cells), m.Name, m.Concurrency)
+			results := runner.RunMatrix(cfg, cfg.System.StorageDir, cells, m.Concurrency, os.Stdout)
+
+			dir := path.Join(c.String("output-dir"), m.Name)
+			junit := path.Join(dir, "junit.xml")
+			if err := writeJUnit(junit, m.Name, results); err != nil {
+				return fmt.Errorf("failed to write JUnit report '%s': %w", junit, err)
+			}
+
+			report := path.Join(dir, "report.json")
+			if err := runner.WriteReport(report, results); err != nil {
+				return fmt.Errorf("failed to write report '%s': %w", report, err)
+			}
+
+			failed := 0
+			t := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+			fmt.Fprintln(t, "\nSTATUS\tRELEASE.PROFILE\tCOMMAND\tDURATION\tLOG")
+			for _, r := range results {
+				if r.Status() != "PASS" {
+					failed++
+				}
+				fmt.Fprintf(t, "%s\t%s\t%s\t%s\t%s\n", r.Status(), r.Class, r.Case, r.Duration.Round(time.Second), r.Log)
+			}
+			t.Flush()
+
+			fmt.Printf("\n%d passed, %d failed\nJUnit: %s\nReport: %s\n", len(results)-failed, failed, junit, report)
+			if failed > 0 {
+				return cli.Exit("", exitFailure)
+			}
+
+			return nil
+		},
+	}
+}
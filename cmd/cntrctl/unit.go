@@ -0,0 +1,233 @@
+// SPDX-License-Identifier: BSD-2
+// Copyright 2023 VMware, Inc.
+
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"time"
+
+	"github.com/urfave/cli/v2"
+
+	"github.com/vmware-samples/photon-os-container-builder/pkg/container"
+	"github.com/vmware-samples/photon-os-container-builder/pkg/system"
+	"github.com/vmware-samples/photon-os-container-builder/pkg/systemd"
+)
+
+func timeoutFlag() cli.Flag {
+	return &cli.DurationFlag{
+		Name:    "timeout",
+		Aliases: []string{"t"},
+		Value:   systemd.DefaultJobTimeout,
+		Usage:   "Time to wait for the systemd job to complete",
+	}
+}
+
+func applyUnitCommand(name string, command string, timeout time.Duration) error {
+	if err := container.CheckExisting(name); err != nil {
+		return err
+	}
+
+	u := systemd.Unit{
+		Name:    name,
+		Command: command,
+		Timeout: timeout,
+	}
+
+	err := u.ApplyCommand()
+	if u.Result != "" {
+		fmt.Printf("%s %s: %s\n", command, u.Name, u.Result)
+	}
+
+	if err != nil {
+		return fmt.Errorf("failed to %s '%s': %w", command, name, err)
+	}
+
+	return nil
+}
+
+func unitCommand(command string, usage string) *cli.Command {
+	return &cli.Command{
+		Name:  command,
+		Usage: usage,
+		Flags: []cli.Flag{
+			timeoutFlag(),
+		},
+		Action: func(c *cli.Context) error {
+			if c.NArg() < 1 {
+				return usageError(c, "expected a container name")
+			}
+
+			return applyUnitCommand(c.Args().First(), command, c.Duration("timeout"))
+		},
+	}
+}
+
+func waitForContainer(name string, target string, timeout time.Duration) error {
+	if err := container.CheckExisting(name); err != nil {
+		return err
+	}
+
+	machine := system.MachineName(name)
+	if err := systemd.WaitForContainer(machine, target, timeout); err != nil {
+		return err
+	}
+
+	if target == "" {
+		target = "system"
+	}
+	fmt.Printf("%s reached %s\n", machine, systemd.NormalizeTarget(target))
+	return nil
+}
+
+func waitFlags() []cli.Flag {
+	return []cli.Flag{
+		&cli.BoolFlag{
+			Name:    "wait",
+			Aliases: []string{"w"},
+			Usage:   "Wait until the container finished booting",
+		},
+		&cli.StringFlag{
+			Name:  "wait-for",
+			Usage: "Wait until a target or unit inside the container is active (e.g. multi-user.target, sshd.service, network-online)",
+		},
+	}
+}
+
+func startCommand() *cli.Command {
+	return &cli.Command{
+		Name:  "start",
+		Usage: "[NAME] start container as a systemd service unit (use host networking)",
+		Flags: append(waitFlags(), timeoutFlag()),
+		Action: func(c *cli.Context) error {
+			if c.NArg() < 1 {
+				return usageError(c, "expected a container name")
+			}
+
+			if err := applyUnitCommand(c.Args().First(), "start", c.Duration("timeout")); err != nil {
+				return err
+			}
+			if c.Bool("wait") || c.String("wait-for") != "" {
+				return waitForContainer(c.Args().First(), c.String("wait-for"), c.Duration("timeout"))
+			}
+
+			return nil
+		},
+	}
+}
+
+func waitCommand() *cli.Command {
+	return &cli.Command{
+		Name:  "wait",
+		Usage: "[NAME] wait until a running container finished booting or reached a target",
+		Flags: []cli.Flag{
+			&cli.StringFlag{
+				Name:  "for",
+				Usage: "Target or unit inside the container to wait for (default: boot finished)",
+			},
+			timeoutFlag(),
+		},
+		Action: func(c *cli.Context) error {
+			if c.NArg() < 1 {
+				return usageError(c, "expected a container name")
+			}
+
+			return waitForContainer(c.Args().First(), c.String("for"), c.Duration("timeout"))
+		},
+	}
+}
+
+func enableCommand() *cli.Command {
+	return &cli.Command{
+		Name:  "enable",
+		Usage: "[NAME] enable container service unit to start at boot",
+		Flags: append([]cli.Flag{
+			&cli.BoolFlag{
+				Name:  "now",
+				Usage: "Also start the container",
+			},
+			timeoutFlag(),
+		}, waitFlags()...),
+		Action: func(c *cli.Context) error {
+			if c.NArg() < 1 {
+				return usageError(c, "expected a container name")
+			}
+
+			if err := applyUnitCommand(c.Args().First(), "enable", c.Duration("timeout")); err != nil {
+				return err
+			}
+			if c.Bool("now") {
+				if err := applyUnitCommand(c.Args().First(), "start", c.Duration("timeout")); err != nil {
+					return err
+				}
+				if c.Bool("wait") || c.String("wait-for") != "" {
+					return waitForContainer(c.Args().First(), c.String("wait-for"), c.Duration("timeout"))
+				}
+			}
+
+			return nil
+		},
+	}
+}
+
+// statusExit returns exit code 3 unless the unit is active, as the status action of
+// LSB init scripts and systemctl status do.
+func statusExit(s *systemd.UnitStatus) error {
+	if s.ActiveState != "active" {
+		return cli.Exit("", exitInactive)
+	}
+
+	return nil
+}
+
+func statusCommand() *cli.Command {
+	return &cli.Command{
+		Name:  "status",
+		Usage: "[NAME] show state of container service unit",
+		Flags: []cli.Flag{
+			formatFlag("text", "json"),
+		},
+		Action: func(c *cli.Context) error {
+			if c.NArg() < 1 {
+				return usageError(c, "expected a container name")
+			}
+			if err := container.CheckExisting(c.Args().First()); err != nil {
+				return err
+			}
+
+			u := systemd.Unit{
+				Name: c.Args().First(),
+			}
+
+			s, err := u.Status()
+			if err != nil {
+				return fmt.Errorf("failed to acquire status of '%s': %w", c.Args().First(), err)
+			}
+
+			if c.String("format") == "json" {
+				e := json.NewEncoder(os.Stdout)
+				e.SetIndent("", "  ")
+				if err := e.Encode(s); err != nil {
+					return err
+				}
+				return statusExit(s)
+			}
+
+			fmt.Printf("%s - %s\n", s.Name, s.Description)
+			fmt.Printf("    Loaded: %s (%s)\n", s.LoadState, s.UnitFileState)
+			fmt.Printf("    Active: %s (%s)", s.ActiveState, s.SubState)
+			if !s.ActiveSince.IsZero() {
+				fmt.Printf(" since %s; %s ago", s.ActiveSince.Format(time.RFC1123), time.Since(s.ActiveSince).Round(time.Second))
+			}
+			fmt.Println()
+			if s.MainPID != 0 {
+				fmt.Printf("  Main PID: %d\n", s.MainPID)
+			}
+			fmt.Printf("    Result: %s\n", s.Result)
+
+			return statusExit(s)
+		},
+	}
+}
@@ -0,0 +1,164 @@
+// SPDX-License-Identifier: BSD-2
+// Copyright 2023 VMware, Inc.
+
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"os"
+	"text/tabwriter"
+	"time"
+
+	"github.com/urfave/cli/v2"
+
+	"github.com/vmware-samples/photon-os-container-builder/pkg/conf"
+	"github.com/vmware-samples/photon-os-container-builder/pkg/container"
+	"github.com/vmware-samples/photon-os-container-builder/pkg/system"
+)
+
+// DefaultPruneAge is how long leftovers are kept by prune unless --older-than is given.
+const DefaultPruneAge = 7 * 24 * time.Hour
+
+func dfCommand(cfg *conf.Config) *cli.Command {
+	return &cli.Command{
+		Name:  "df",
+		Usage: "Show the disk usage of containers, storage pools and package caches",
+		Flags: []cli.Flag{storageFormatFlag()},
+		Action: func(c *cli.Context) error {
+			u, err := container.GetUsage(cfg, cfg.System.StorageDir)
+			if err != nil {
+				return err
+			}
+
+			if c.String("format") == "json" {
+				e := json.NewEncoder(os.Stdout)
+				e.SetIndent("", "  ")
+				return e.Encode(u)
+			}
+
+			t := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+			fmt.Fprintln(t, "CONTAINER\tPOOL\tROOTFS\tSNAPSHOTS\tSHARED\tQUOTA")
+			for _, d := range u.Containers {
+				quota := "-"
+				if d.Quota != nil {
+					quota = fmt.Sprintf("%s (%s)", d.Quota, d.Quota.Method)
+				}
+				fmt.Fprintf(t, "%s\t%s\t%s\t%s\t%s\t%s\n", d.Name, d.Pool, system.FormatBytes(d.Rootfs.Size), system.FormatBytes(d.Snapshots.Size),
+					system.FormatBytes(d.Rootfs.Shared+d.Snapshots.Shared), quota)
+			}
+			fmt.Fprintln(t)
+
+			fmt.Fprintln(t, "POOL\tCONTAINERS\tUSED\tSHARED\tFREE\tSIZE\tDIR")
+			for _, p := range u.Pools {
+				fmt.Fprintf(t, "%s\t%d\t%s\t%s\t%s\t%s\t%s\n", p.Name, p.Containers, system.FormatBytes(p.Used.Size), system.FormatBytes(p.Used.Shared),
+					system.FormatBytes(int64(p.Free)), system.FormatBytes(int64(p.Total)), p.Dir)
+			}
+			fmt.Fprintln(t)
+
+			fmt.Fprintln(t, "CACHE\tPACKAGES\tUSED\tPATH")
+			for _, e := range u.Caches {
+				fmt.Fprintf(t, "%s\t%d\t%s\t%s\n", e.Release, e.Packages, system.FormatBytes(e.Used.Size), e.Path)
+			}
+			t.Flush()
+
+			return nil
+		},
+	}
+}
+
+func quotaCommand(cfg *conf.Config) *cli.Command {
+	return &cli.Command{
+		Name:  "quota",
+		Usage: "Limit the disk space of containers",
+		Subcommands: []*cli.Command{
+			{
+				Name:      "set",
+				Usage:     "Limit a container to SIZE bytes, with optional K, M, G or T suffix",
+				ArgsUsage: "NAME SIZE",
+				Action: func(c *cli.Context) error {
+					if c.NArg() != 2 {
+						return usageError(c, "expected a container name and a size")
+					}
+
+					size, err := system.ParseBytes(c.Args().Get(1))
+					if err != nil || size <= 0 {
+						return usageError(c, "invalid size '%s'", c.Args().Get(1))
+					}
+
+					return container.SetQuota(context.Background(), os.Stdout, cfg.System.StorageDir, c.Args().First(), size)
+				},
+			},
+			{
+				Name:      "show",
+				Usage:     "Show the quota of a container and how much of it is used",
+				ArgsUsage: "NAME",
+				Flags:     []cli.Flag{storageFormatFlag()},
+				Action: func(c *cli.Context) error {
+					if c.NArg() != 1 {
+						return usageError(c, "expected a container name")
+					}
+
+					name := c.Args().First()
+					if err := container.CheckExisting(name); err != nil {
+						return err
+					}
+					if !system.PathExists(container.RootDir(cfg.System.StorageDir, name)) {
+						return fmt.Errorf("'%s': %w", name, container.ErrNotExist)
+					}
+
+					q, err := container.GetQuota(cfg.System.StorageDir, name)
+					if err != nil {
+						return err
+					}
+
+					if c.String("format") == "json" {
+						e := json.NewEncoder(os.Stdout)
+						e.SetIndent("", "  ")
+						return e.Encode(q)
+					}
+
+					if q == nil {
+						fmt.Printf("'%s' has no quota\n", name)
+						return nil
+					}
+
+					fmt.Printf("%s: %s used (%d%%), %s quota\n", name, q, q.Percent(), q.Method)
+					return nil
+				},
+			},
+		},
+	}
+}
+
+func pruneCommand(cfg *conf.Config) *cli.Command {
+	return &cli.Command{
+		Name:  "prune",
+		Usage: "Remove stale ephemeral snapshots, stopped test containers and old cached packages",
+		Flags: []cli.Flag{
+			&cli.DurationFlag{
+				Name:  "older-than",
+				Value: DefaultPruneAge,
+				Usage: "Only remove leftovers not modified within this duration",
+			},
+			storageFormatFlag(),
+		},
+		Action: func(c *cli.Context) error {
+			p, err := container.Prune(cfg, os.Stderr, cfg.System.StorageDir, c.Duration("older-than"))
+			if err != nil {
+				return err
+			}
+
+			if c.String("format") == "json" {
+				e := json.NewEncoder(os.Stdout)
+				e.SetIndent("", "  ")
+				return e.Encode(p)
+			}
+
+			fmt.Printf("Removed %d snapshots, %d containers and %d packages, freed %s\n",
+				len(p.Snapshots), len(p.Containers), p.Packages, system.FormatBytes(p.Size))
+			return nil
+		},
+	}
+}
@@ -0,0 +1,103 @@
+// SPDX-License-Identifier: BSD-2
+// Copyright 2023 VMware, Inc.
+
+package api
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"os/user"
+	"strconv"
+	"strings"
+
+	"golang.org/x/sys/unix"
+
+	"github.com/vmware-samples/photon-os-container-builder/pkg/system"
+)
+
+type credKey struct{}
+
+type Peer struct {
+	PID    int32
+	UID    uint32
+	Groups map[uint32]bool
+}
+
+// peerCredentials reads the credentials of the process at the other end of a unix
+// socket connection, including its supplementary groups.
+func peerCredentials(c net.Conn) (*Peer, error) {
+	uc, ok := c.(*net.UnixConn)
+	if !ok {
+		return nil, fmt.Errorf("not a unix socket connection")
+	}
+
+	raw, err := uc.SyscallConn()
+	if err != nil {
+		return nil, err
+	}
+
+	var cred *unix.Ucred
+	var credErr error
+	if err := raw.Control(func(fd uintptr) {
+		cred, credErr = unix.GetsockoptUcred(int(fd), unix.SOL_SOCKET, unix.SO_PEERCRED)
+	}); err != nil {
+		return nil, err
+	}
+	if credErr != nil {
+		return nil, credErr
+	}
+
+	p := Peer{
+		PID:    cred.Pid,
+		UID:    cred.Uid,
+		Groups: map[uint32]bool{cred.Gid: true},
+	}
+
+	lines, err := system.ReadLines(fmt.Sprintf("/proc/%d/status", cred.Pid))
+	if err != nil {
+		return &p, nil
+	}
+
+	for _, l := range lines {
+		if !strings.HasPrefix(l, "Groups:") {
+			continue
+		}
+
+		for _, g := range strings.Fields(strings.TrimPrefix(l, "Groups:")) {
+			if gid, err := strconv.ParseUint(g, 10, 32); err == nil {
+				p.Groups[uint32(gid)] = true
+			}
+		}
+	}
+
+	return &p, nil
+}
+
+func lookupGroup(name string) (uint32, error) {
+	g, err := user.LookupGroup(name)
+	if err != nil {
+		return 0, err
+	}
+
+	gid, err := strconv.ParseUint(g.Gid, 10, 32)
+	if err != nil {
+		return 0, err
+	}
+
+	return uint32(gid), nil
+}
+
+func withPeer(ctx context.Context, c net.Conn) context.Context {
+	p, err := peerCredentials(c)
+	if err != nil {
+		return ctx
+	}
+
+	return context.WithValue(ctx, credKey{}, p)
+}
+
+func peerOf(ctx context.Context) *Peer {
+	p, _ := ctx.Value(credKey{}).(*Peer)
+	return p
+}
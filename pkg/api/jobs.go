@@ -0,0 +1,197 @@
+// SPDX-License-Identifier: BSD-2
+// Copyright 2023 VMware, Inc.
+
+package api
+
+import (
+	"bytes"
+	"io"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/vmware-samples/photon-os-container-builder/pkg/logging"
+)
+
+const (
+	JobPending   = "pending"
+	JobRunning   = "running"
+	JobSucceeded = "succeeded"
+	JobFailed    = "failed"
+
+	EventOutput = "output"
+	EventState  = "state"
+)
+
+type Event struct {
+	Time  time.Time `json:"time"`
+	Type  string    `json:"type"`
+	Line  string    `json:"line,omitempty"`
+	State string    `json:"state,omitempty"`
+	Error string    `json:"error,omitempty"`
+}
+
+type Job struct {
+	ID       string     `json:"id"`
+	Kind     string     `json:"kind"`
+	Target   string     `json:"target"`
+	State    string     `json:"state"`
+	Error    string     `json:"error,omitempty"`
+	Created  time.Time  `json:"created"`
+	Started  *time.Time `json:"started,omitempty"`
+	Finished *time.Time `json:"finished,omitempty"`
+
+	mu      sync.Mutex
+	events  []Event
+	partial []byte
+	changed chan struct{}
+}
+
+// Jobs keeps the asynchronous operations of the daemon.
+type Jobs struct {
+	mu    sync.Mutex
+	jobs  map[string]*Job
+	order []string
+	next  int
+}
+
+func NewJobs() *Jobs {
+	return &Jobs{jobs: map[string]*Job{}}
+}
+
+func (j *Job) emit(e Event) {
+	e.Time = time.Now()
+	j.events = append(j.events, e)
+
+	close(j.changed)
+	j.changed = make(chan struct{})
+}
+
+// Write splits output into line events.
+func (j *Job) Write(p []byte) (int, error) {
+	j.mu.Lock()
+	defer j.mu.Unlock()
+
+	j.partial = append(j.partial, p...)
+	for {
+		i := bytes.IndexByte(j.partial, '\n')
+		if i < 0 {
+			break
+		}
+
+		j.emit(Event{Type: EventOutput, Line: string(j.partial[:i])})
+		j.partial = j.partial[i+1:]
+	}
+
+	return len(p), nil
+}
+
+func (j *Job) setState(state string, err error) {
+	j.mu.Lock()
+	defer j.mu.Unlock()
+
+	if len(j.partial) > 0 {
+		j.emit(Event{Type: EventOutput, Line: string(j.partial)})
+		j.partial = nil
+	}
+
+	now := time.Now()
+	j.State = state
+	switch state {
+	case JobRunning:
+		j.Started = &now
+	case JobSucceeded, JobFailed:
+		j.Finished = &now
+	}
+
+	e := Event{Type: EventState, State: state}
+	if err != nil {
+		j.Error = err.Error()
+		e.Error = j.Error
+	}
+	j.emit(e)
+}
+
+// Snapshot returns a copy of the job fields safe to encode.
+func (j *Job) Snapshot() Job {
+	j.mu.Lock()
+	defer j.mu.Unlock()
+
+	return Job{
+		ID:       j.ID,
+		Kind:     j.Kind,
+		Target:   j.Target,
+		State:    j.State,
+		Error:    j.Error,
+		Created:  j.Created,
+		Started:  j.Started,
+		Finished: j.Finished,
+	}
+}
+
+// Events returns the events starting at index from, a channel closed on the next
+// event and whether the job has finished.
+func (j *Job) Events(from int) ([]Event, <-chan struct{}, bool) {
+	j.mu.Lock()
+	defer j.mu.Unlock()
+
+	var r []Event
+	if from < len(j.events) {
+		r = append(r, j.events[from:]...)
+	}
+
+	return r, j.changed, j.State == JobSucceeded || j.State == JobFailed
+}
+
+func (s *Jobs) Get(id string) (*Job, bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	j, ok := s.jobs[id]
+	return j, ok
+}
+
+func (s *Jobs) List() []Job {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	r := []Job{}
+	for _, id := range s.order {
+		r = append(r, s.jobs[id].Snapshot())
+	}
+
+	return r
+}
+
+// Start runs fn as a new job, everything fn writes to w is recorded as progress.
+func (s *Jobs) Start(kind string, target string, fn func(w io.Writer) error) *Job {
+	s.mu.Lock()
+	s.next++
+	j := &Job{
+		ID:      strconv.Itoa(s.next),
+		Kind:    kind,
+		Target:  target,
+		State:   JobPending,
+		Created: time.Now(),
+		changed: make(chan struct{}),
+	}
+	s.jobs[j.ID] = j
+	s.order = append(s.order, j.ID)
+	s.mu.Unlock()
+
+	go func() {
+		l := logging.Operation(kind, target).WithField("job", j.ID)
+
+		l.Info("Job started")
+		j.setState(JobRunning, nil)
+		if err := fn(j); err != nil {
+			l.WithError(err).Error("Job failed")
+			j.setState(JobFailed, err)
+			return
+		}
+		l.Info("Job succeeded")
+		j.setState(JobSucceeded, nil)
+	}()
+
+	return j
+}
@@ -0,0 +1,553 @@
+// SPDX-License-Identifier: BSD-2
+// Copyright 2023 VMware, Inc.
+
+package api
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"os"
+	"os/signal"
+	"path"
+	"strconv"
+	"strings"
+	"syscall"
+	"time"
+
+	log "github.com/sirupsen/logrus"
+
+	"github.com/vmware-samples/photon-os-container-builder/pkg/cntr"
+	"github.com/vmware-samples/photon-os-container-builder/pkg/conf"
+	"github.com/vmware-samples/photon-os-container-builder/pkg/errdefs"
+	"github.com/vmware-samples/photon-os-container-builder/pkg/logging"
+	"github.com/vmware-samples/photon-os-container-builder/pkg/systemd"
+)
+
+const (
+	Version = "v1"
+
+	DefaultSocket = "/run/cntrctl/cntrctl.sock"
+	DefaultGroup  = "cntrctl"
+)
+
+type Options struct {
+	Socket string
+	// Members of Group may use the whole API, members of ReadGroup only GET requests.
+	// root is always allowed.
+	Group     string
+	ReadGroup string
+}
+
+type SpawnRequest struct {
+	Name      string   `json:"name"`
+	Release   string   `json:"release,omitempty"`
+	Profile   string   `json:"profile,omitempty"`
+	Packages  string   `json:"packages,omitempty"`
+	Network   string   `json:"network,omitempty"`
+	Link      string   `json:"link,omitempty"`
+	Machine   string   `json:"machine,omitempty"`
+	Ephemeral bool     `json:"ephemeral,omitempty"`
+	Lock      string   `json:"lock,omitempty"`
+	Slim      []string `json:"slim,omitempty"`
+	Offline   bool     `json:"offline,omitempty"`
+	Pool      string   `json:"pool,omitempty"`
+}
+
+type UnitRequest struct {
+	Timeout string `json:"timeout,omitempty"`
+	Wait    bool   `json:"wait,omitempty"`
+	WaitFor string `json:"wait_for,omitempty"`
+}
+
+type UnitResponse struct {
+	Unit   string `json:"unit"`
+	Result string `json:"result"`
+}
+
+type ExecRequest struct {
+	Command []string `json:"command"`
+	Timeout string   `json:"timeout,omitempty"`
+}
+
+type ExecResponse struct {
+	ExitCode int    `json:"exit_code"`
+	Stdout   string `json:"stdout"`
+	Stderr   string `json:"stderr"`
+}
+
+type ErrorResponse struct {
+	Error string `json:"error"`
+}
+
+type Server struct {
+	m    *cntr.Manager
+	jobs *Jobs
+
+	gid     uint32
+	readGID uint32
+	hasGID  bool
+	hasRead bool
+}
+
+func NewServer(cfg *conf.Config, base string, o *Options) (*Server, error) {
+	m := cntr.NewManager(cfg)
+	m.Storage = base
+
+	s := Server{
+		m:    m,
+		jobs: NewJobs(),
+	}
+
+	if o.Group != "" {
+		gid, err := lookupGroup(o.Group)
+		if err != nil {
+			return nil, fmt.Errorf("group '%s': %w", o.Group, err)
+		}
+		s.gid, s.hasGID = gid, true
+	}
+
+	if o.ReadGroup != "" {
+		gid, err := lookupGroup(o.ReadGroup)
+		if err != nil {
+			return nil, fmt.Errorf("group '%s': %w", o.ReadGroup, err)
+		}
+		s.readGID, s.hasRead = gid, true
+	}
+
+	return &s, nil
+}
+
+func writeJSON(w http.ResponseWriter, status int, v interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+
+	e := json.NewEncoder(w)
+	e.SetIndent("", "  ")
+	e.Encode(v)
+}
+
+func writeError(w http.ResponseWriter, status int, format string, args ...interface{}) {
+	writeJSON(w, status, ErrorResponse{Error: fmt.Sprintf(format, args...)})
+}
+
+func decode(r *http.Request, v interface{}) error {
+	if r.ContentLength == 0 {
+		return nil
+	}
+
+	d := json.NewDecoder(r.Body)
+	d.DisallowUnknownFields()
+	return d.Decode(v)
+}
+
+// status maps errors of the cntr API to HTTP status codes.
+func status(err error) int {
+	if errors.Is(err, context.DeadlineExceeded) {
+		return http.StatusGatewayTimeout
+	}
+
+	switch errdefs.Kind(err) {
+	case errdefs.ErrNotFound:
+		return http.StatusNotFound
+	case errdefs.ErrExists, errdefs.ErrConflict:
+		return http.StatusConflict
+	case errdefs.ErrInvalid:
+		return http.StatusBadRequest
+	case errdefs.ErrPermission:
+		return http.StatusForbidden
+	case errdefs.ErrTimeout:
+		return http.StatusGatewayTimeout
+	case errdefs.ErrBus, errdefs.ErrDependency:
+		return http.StatusServiceUnavailable
+	}
+
+	return http.StatusInternalServerError
+}
+
+func parseTimeout(s string) (time.Duration, error) {
+	if s == "" {
+		return systemd.DefaultJobTimeout, nil
+	}
+
+	return time.ParseDuration(s)
+}
+
+func (s *Server) authorized(r *http.Request) bool {
+	p := peerOf(r.Context())
+	if p == nil {
+		return false
+	}
+
+	switch {
+	case p.UID == 0:
+		return true
+	case s.hasGID && p.Groups[s.gid]:
+		return true
+	case s.hasRead && p.Groups[s.readGID]:
+		return r.Method == http.MethodGet
+	}
+
+	return false
+}
+
+// ServeHTTP routes /v1/... requests. Paths are matched by hand as the module still
+// targets Go versions without method and wildcard patterns.
+func (s *Server) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if !s.authorized(r) {
+		writeError(w, http.StatusForbidden, "permission denied")
+		return
+	}
+
+	p := strings.Split(strings.Trim(r.URL.Path, "/"), "/")
+	if len(p) < 2 || p[0] != Version {
+		writeError(w, http.StatusNotFound, "unknown endpoint '%s'", r.URL.Path)
+		return
+	}
+	p = p[1:]
+
+	log.WithFields(log.Fields{"method": r.Method, "path": r.URL.Path}).Debug("API request")
+
+	switch {
+	case len(p) == 1 && p[0] == "version" && r.Method == http.MethodGet:
+		writeJSON(w, http.StatusOK, map[string]string{"api": Version, "version": conf.Version})
+
+	case len(p) == 1 && p[0] == "containers" && r.Method == http.MethodGet:
+		s.list(w, r)
+	case len(p) == 1 && p[0] == "containers" && r.Method == http.MethodPost:
+		s.spawn(w, r)
+
+	case len(p) >= 2 && p[0] == "containers":
+		name := p[1]
+
+		switch {
+		case len(p) == 2 && r.Method == http.MethodGet:
+			s.inspect(w, r, name)
+		case len(p) == 2 && r.Method == http.MethodDelete:
+			s.remove(w, r, name)
+		case len(p) == 3 && r.Method == http.MethodPost && (p[2] == "start" || p[2] == "stop" || p[2] == "restart"):
+			s.unit(w, r, name, p[2])
+		case len(p) == 3 && r.Method == http.MethodPost && p[2] == "exec":
+			s.exec(w, r, name)
+		case len(p) == 3 && r.Method == http.MethodGet && p[2] == "logs":
+			s.logs(w, r, name)
+		default:
+			writeError(w, http.StatusNotFound, "unknown endpoint '%s %s'", r.Method, r.URL.Path)
+		}
+
+	case len(p) == 1 && p[0] == "jobs" && r.Method == http.MethodGet:
+		writeJSON(w, http.StatusOK, s.jobs.List())
+	case len(p) >= 2 && p[0] == "jobs" && r.Method == http.MethodGet:
+		j, ok := s.jobs.Get(p[1])
+		if !ok {
+			writeError(w, http.StatusNotFound, "job '%s' does not exist", p[1])
+			return
+		}
+
+		switch {
+		case len(p) == 2:
+			writeJSON(w, http.StatusOK, j.Snapshot())
+		case len(p) == 3 && p[2] == "events":
+			s.events(w, r, j)
+		default:
+			writeError(w, http.StatusNotFound, "unknown endpoint '%s %s'", r.Method, r.URL.Path)
+		}
+
+	default:
+		writeError(w, http.StatusNotFound, "unknown endpoint '%s %s'", r.Method, r.URL.Path)
+	}
+}
+
+func (s *Server) list(w http.ResponseWriter, r *http.Request) {
+	l, err := s.m.List(r.Context())
+	if err != nil {
+		writeError(w, status(err), "%v", err)
+		return
+	}
+
+	writeJSON(w, http.StatusOK, l)
+}
+
+func (s *Server) spawn(w http.ResponseWriter, r *http.Request) {
+	req := SpawnRequest{}
+	if err := decode(r, &req); err != nil {
+		writeError(w, http.StatusBadRequest, "invalid request: %v", err)
+		return
+	}
+
+	o := cntr.SpawnOptions{
+		Name:      req.Name,
+		Release:   req.Release,
+		Profile:   req.Profile,
+		Packages:  req.Packages,
+		Network:   req.Network,
+		Link:      req.Link,
+		Machine:   req.Machine,
+		Ephemeral: req.Ephemeral,
+		Lockfile:  req.Lock,
+		Slim:      req.Slim,
+		Offline:   req.Offline,
+		Pool:      req.Pool,
+	}
+
+	// Reject what can be detected right away instead of queueing a job bound to fail
+	if err := s.m.ValidateSpawn(o); err != nil {
+		writeError(w, status(err), "%v", err)
+		return
+	}
+
+	j := s.jobs.Start("spawn", req.Name, func(out io.Writer) error {
+		m := *s.m
+		m.Output = out
+
+		return m.Spawn(context.Background(), o)
+	})
+
+	w.Header().Set("Location", "/"+Version+"/jobs/"+j.ID)
+	writeJSON(w, http.StatusAccepted, j.Snapshot())
+}
+
+func (s *Server) inspect(w http.ResponseWriter, r *http.Request, name string) {
+	i, err := s.m.Inspect(r.Context(), name)
+	if err != nil {
+		writeError(w, status(err), "%v", err)
+		return
+	}
+
+	writeJSON(w, http.StatusOK, i)
+}
+
+func (s *Server) remove(w http.ResponseWriter, r *http.Request, name string) {
+	if err := s.m.Remove(r.Context(), name); err != nil {
+		writeError(w, status(err), "%v", err)
+		return
+	}
+
+	w.WriteHeader(http.StatusNoContent)
+}
+
+func (s *Server) unit(w http.ResponseWriter, r *http.Request, name string, command string) {
+	req := UnitRequest{}
+	if err := decode(r, &req); err != nil {
+		writeError(w, http.StatusBadRequest, "invalid request: %v", err)
+		return
+	}
+
+	timeout, err := parseTimeout(req.Timeout)
+	if err != nil {
+		writeError(w, http.StatusBadRequest, "invalid timeout: %v", err)
+		return
+	}
+
+	o := cntr.UnitOptions{
+		Timeout: timeout,
+		Wait:    req.Wait,
+		WaitFor: req.WaitFor,
+	}
+
+	var result string
+	switch command {
+	case "start":
+		result, err = s.m.Start(r.Context(), name, o)
+	case "stop":
+		result, err = s.m.Stop(r.Context(), name, o)
+	case "restart":
+		result, err = s.m.Restart(r.Context(), name, o)
+	}
+
+	if err != nil {
+		writeError(w, status(err), "%v", err)
+		return
+	}
+
+	writeJSON(w, http.StatusOK, UnitResponse{Unit: name + ".service", Result: result})
+}
+
+func (s *Server) exec(w http.ResponseWriter, r *http.Request, name string) {
+	req := ExecRequest{}
+	if err := decode(r, &req); err != nil {
+		writeError(w, http.StatusBadRequest, "invalid request: %v", err)
+		return
+	}
+
+	timeout, err := parseTimeout(req.Timeout)
+	if err != nil {
+		writeError(w, http.StatusBadRequest, "invalid timeout: %v", err)
+		return
+	}
+
+	ctx, cancel := context.WithTimeout(r.Context(), timeout)
+	defer cancel()
+
+	var stdout, stderr bytes.Buffer
+	code, err := s.m.Exec(ctx, name, cntr.ExecOptions{Command: req.Command, Stdout: &stdout, Stderr: &stderr})
+	if err != nil {
+		writeError(w, status(err), "%v", err)
+		return
+	}
+
+	writeJSON(w, http.StatusOK, ExecResponse{ExitCode: code, Stdout: stdout.String(), Stderr: stderr.String()})
+}
+
+// flushWriter pushes every write to the client so followed output arrives as it happens.
+type flushWriter struct {
+	w http.ResponseWriter
+}
+
+func (f flushWriter) Write(p []byte) (int, error) {
+	n, err := f.w.Write(p)
+	if fl, ok := f.w.(http.Flusher); ok {
+		fl.Flush()
+	}
+
+	return n, err
+}
+
+func (s *Server) logs(w http.ResponseWriter, r *http.Request, name string) {
+	q := r.URL.Query()
+
+	o := cntr.LogOptions{
+		Follow:  q.Get("follow") == "true",
+		Since:   q.Get("since"),
+		Unit:    q.Get("unit"),
+		Boot:    q.Get("boot") == "true",
+		Format:  q.Get("output"),
+		Service: q.Get("service") == "true",
+	}
+
+	if l := q.Get("lines"); l != "" {
+		n, err := strconv.Atoi(l)
+		if err != nil {
+			writeError(w, http.StatusBadRequest, "invalid lines '%s'", l)
+			return
+		}
+		o.Lines = n
+	}
+
+	// Errors can only be reported before the stream starts
+	if err := s.m.Exists(name); err != nil {
+		writeError(w, status(err), "%v", err)
+		return
+	}
+
+	w.Header().Set("Content-Type", "text/plain; charset=utf-8")
+	w.WriteHeader(http.StatusOK)
+
+	if err := s.m.Logs(r.Context(), name, o, flushWriter{w}); err != nil {
+		logging.Operation("logs", name).WithError(err).Error("Failed to stream journal")
+	}
+}
+
+// events streams the progress of a job as JSON lines until it finished or the client
+// went away.
+func (s *Server) events(w http.ResponseWriter, r *http.Request, j *Job) {
+	w.Header().Set("Content-Type", "application/x-ndjson")
+	w.WriteHeader(http.StatusOK)
+
+	e := json.NewEncoder(flushWriter{w})
+	next := 0
+
+	for {
+		events, changed, finished := j.Events(next)
+		for _, ev := range events {
+			if err := e.Encode(ev); err != nil {
+				return
+			}
+		}
+		next += len(events)
+
+		if finished {
+			return
+		}
+
+		select {
+		case <-changed:
+		case <-r.Context().Done():
+			return
+		}
+	}
+}
+
+func listen(o *Options) (net.Listener, error) {
+	if err := os.MkdirAll(path.Dir(o.Socket), 0755); err != nil {
+		return nil, err
+	}
+	os.Remove(o.Socket)
+
+	l, err := net.Listen("unix", o.Socket)
+	if err != nil {
+		return nil, err
+	}
+
+	// Peer credentials are checked for every request, the socket mode only keeps out
+	// everyone who could not pass that check anyway.
+	mode := os.FileMode(0600)
+	gid := -1
+	switch {
+	case o.ReadGroup != "":
+		mode = 0666
+	case o.Group != "":
+		mode = 0660
+		if g, err := lookupGroup(o.Group); err == nil {
+			gid = int(g)
+		}
+	}
+
+	if err := os.Chown(o.Socket, 0, gid); err != nil {
+		l.Close()
+		return nil, err
+	}
+
+	if err := os.Chmod(o.Socket, mode); err != nil {
+		l.Close()
+		return nil, err
+	}
+
+	return l, nil
+}
+
+// Serve runs the API on the unix socket until the process receives SIGINT or SIGTERM.
+func Serve(cfg *conf.Config, base string, o *Options) error {
+	if o.Socket == "" {
+		o.Socket = DefaultSocket
+	}
+
+	s, err := NewServer(cfg, base, o)
+	if err != nil {
+		return err
+	}
+
+	l, err := listen(o)
+	if err != nil {
+		return err
+	}
+	defer os.Remove(o.Socket)
+
+	h := http.Server{
+		Handler:     s,
+		ConnContext: withPeer,
+	}
+
+	ctx, stop := signal.NotifyContext(context.Background(), syscall.SIGINT, syscall.SIGTERM)
+	defer stop()
+
+	go func() {
+		<-ctx.Done()
+
+		c, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+		defer cancel()
+		h.Shutdown(c)
+	}()
+
+	log.WithFields(log.Fields{"socket": o.Socket, "api": Version}).Info("Listening")
+	if err := h.Serve(l); err != nil && !errors.Is(err, http.ErrServerClosed) {
+		return err
+	}
+
+	return nil
+}
@@ -32,3 +32,20 @@ func SystemBusPrivateConn() (*dbus.Conn, error) {
 
 	return conn, nil
 }
+
+// ContainerSystemdPrivateConn connects to the private systemd socket of the container
+// whose leader is pid, through the leader's root directory.
+func ContainerSystemdPrivateConn(pid int) (*dbus.Conn, error) {
+	conn, err := dbus.Dial("unix:path=/proc/" + strconv.Itoa(pid) + "/root/run/systemd/private")
+	if err != nil {
+		return nil, err
+	}
+
+	methods := []dbus.Auth{dbus.AuthExternal(strconv.Itoa(os.Getuid()))}
+	if err = conn.Auth(methods); err != nil {
+		conn.Close()
+		return nil, err
+	}
+
+	return conn, nil
+}
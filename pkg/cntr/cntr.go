@@ -0,0 +1,477 @@
+// SPDX-License-Identifier: BSD-2
+// Copyright 2023 VMware, Inc.
+
+// Package cntr is the Go API to build and manage Photon OS containers. Unlike the
+// command line it never prints or exits: failures are returned as *Error wrapping
+// one of the Err* values where applicable, and progress of long running operations
+// is written to Manager.Output.
+package cntr
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"path"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/vmware-samples/photon-os-container-builder/pkg/conf"
+	"github.com/vmware-samples/photon-os-container-builder/pkg/container"
+	"github.com/vmware-samples/photon-os-container-builder/pkg/errdefs"
+	"github.com/vmware-samples/photon-os-container-builder/pkg/lock"
+	"github.com/vmware-samples/photon-os-container-builder/pkg/nspawn"
+	"github.com/vmware-samples/photon-os-container-builder/pkg/profile"
+	"github.com/vmware-samples/photon-os-container-builder/pkg/rpm"
+	"github.com/vmware-samples/photon-os-container-builder/pkg/system"
+	"github.com/vmware-samples/photon-os-container-builder/pkg/systemd"
+)
+
+var (
+	ErrNotFound   = container.ErrNotExist
+	ErrExists     = container.ErrExist
+	ErrRunning    = container.ErrRunning
+	ErrNotRunning = container.ErrNotRunning
+	ErrInvalid    = errdefs.ErrInvalid
+)
+
+type (
+	Summary = container.Summary
+	Info    = container.Info
+	Package = rpm.Package
+)
+
+// Error describes a failed operation on a container.
+type Error struct {
+	Op        string
+	Container string
+	Err       error
+}
+
+func (e *Error) Error() string {
+	if e.Container == "" {
+		return e.Op + ": " + e.Err.Error()
+	}
+
+	return e.Op + " '" + e.Container + "': " + e.Err.Error()
+}
+
+func (e *Error) Unwrap() error {
+	return e.Err
+}
+
+func wrap(op string, name string, err error) error {
+	if err == nil {
+		return nil
+	}
+
+	return &Error{Op: op, Container: name, Err: err}
+}
+
+type Manager struct {
+	Config  *conf.Config
+	Storage string
+	// Output receives the progress of package installation and builds.
+	Output io.Writer
+}
+
+type SpawnOptions struct {
+	Name      string
+	Release   string
+	Profile   string
+	Packages  string
+	Network   string
+	Link      string
+	Machine   string
+	Ephemeral bool
+	Lockfile  string
+	Slim      []string
+	Offline   bool
+	Pool      string
+}
+
+type BootOptions struct {
+	Name          string
+	Network       string
+	Link          string
+	Machine       string
+	Ephemeral     bool
+	Binds         []string
+	ReadOnlyBinds []string
+}
+
+type UnitOptions struct {
+	// Timeout bounds the systemd job and waiting for the container, the deadline of
+	// the context is used when it is earlier.
+	Timeout time.Duration
+	// Wait for the container to finish booting, or for WaitFor to become active.
+	Wait    bool
+	WaitFor string
+}
+
+type ExecOptions struct {
+	Command []string
+	Stdout  io.Writer
+	Stderr  io.Writer
+}
+
+type LogOptions struct {
+	Follow  bool
+	Since   string
+	Unit    string
+	Boot    bool
+	Lines   int
+	Format  string
+	Service bool
+}
+
+// NewManager returns a Manager using the configured storage directory that discards
+// progress output.
+func NewManager(cfg *conf.Config) *Manager {
+	storage := cfg.System.StorageDir
+	if storage == "" {
+		storage = conf.DefaultStorageDir
+	}
+
+	return &Manager{
+		Config:  cfg,
+		Storage: storage,
+		Output:  io.Discard,
+	}
+}
+
+func (m *Manager) output() io.Writer {
+	if m.Output == nil {
+		return io.Discard
+	}
+
+	return m.Output
+}
+
+// Exists reports an error when there is no container name.
+func (m *Manager) Exists(name string) error {
+	return m.exists(name)
+}
+
+func (m *Manager) exists(name string) error {
+	if err := container.CheckExisting(name); err != nil {
+		return err
+	}
+
+	if !system.PathExists(path.Join(m.Storage, name)) {
+		return ErrNotFound
+	}
+
+	return nil
+}
+
+func timeout(ctx context.Context, d time.Duration) time.Duration {
+	if d == 0 {
+		d = systemd.DefaultJobTimeout
+	}
+
+	if dl, ok := ctx.Deadline(); ok && time.Until(dl) < d {
+		d = time.Until(dl)
+	}
+
+	return d
+}
+
+// ValidateSpawn reports the problems of o which can be detected without building.
+func (m *Manager) ValidateSpawn(o SpawnOptions) error {
+	if err := container.CheckName(o.Name); err != nil {
+		return wrap("spawn", o.Name, err)
+	}
+	if err := container.CheckMachine(o.Machine); err != nil {
+		return wrap("spawn", o.Name, err)
+	}
+	if (o.Network == "") != (o.Link == "") {
+		return wrap("spawn", o.Name, fmt.Errorf("network and link have to be specified together: %w", ErrInvalid))
+	}
+	// Clients of the daemon must not make it read host files, profiles of the
+	// configuration may still include package lists
+	for _, e := range strings.Split(o.Packages, ",") {
+		if strings.HasPrefix(strings.TrimSpace(e), "@") {
+			return wrap("spawn", o.Name, fmt.Errorf("package list files are not accepted, '%s': %w", strings.TrimSpace(e), ErrInvalid))
+		}
+	}
+	if o.Lockfile != "" {
+		if o.Packages != "" || o.Profile != "" {
+			return wrap("spawn", o.Name, fmt.Errorf("lock cannot be combined with packages or profile: %w", ErrInvalid))
+		}
+		if _, err := lockfilePath(o.Lockfile); err != nil {
+			return wrap("spawn", o.Name, err)
+		}
+	}
+	if system.PathExists(path.Join(m.Storage, o.Name)) {
+		return wrap("spawn", o.Name, ErrExists)
+	}
+	if _, err := container.PoolDir(m.Config, m.Storage, o.Pool); err != nil {
+		return wrap("spawn", o.Name, err)
+	}
+
+	return nil
+}
+
+// lockfilePath resolves the lockfile of a spawn request, the name of a container or
+// the path of its lockfile in conf.DefaultLockfileDir. The daemon reads no other
+// files on behalf of its clients.
+func lockfilePath(lockfile string) (string, error) {
+	p := lockfile
+	if !strings.Contains(p, "/") {
+		name := strings.TrimSuffix(p, ".json")
+		if err := container.CheckExisting(name); err != nil {
+			return "", err
+		}
+		p = container.LockfilePath(name)
+	}
+
+	r, err := filepath.EvalSymlinks(p)
+	if err != nil {
+		return "", fmt.Errorf("failed to resolve lockfile '%s': %w", lockfile, err)
+	}
+	dir, err := filepath.EvalSymlinks(conf.DefaultLockfileDir)
+	if err != nil || filepath.Dir(r) != dir {
+		return "", fmt.Errorf("lockfile '%s' is not in '%s': %w", lockfile, conf.DefaultLockfileDir, ErrInvalid)
+	}
+
+	return r, nil
+}
+
+// Spawn builds a new container and creates its service unit. Cancellation is
+// checked between the build steps.
+func (m *Manager) Spawn(ctx context.Context, o SpawnOptions) error {
+	if err := m.ValidateSpawn(o); err != nil {
+		return err
+	}
+
+	cfg := *m.Config
+	if o.Offline {
+		cfg.Cache.Offline = true
+	}
+	if o.Release == "" && o.Lockfile == "" {
+		o.Release = cfg.System.Release
+	}
+	if o.Network == "" && o.Link == "" {
+		o.Network, o.Link = cfg.Network.Type, cfg.Network.Link
+	}
+	if o.Lockfile == "" {
+		p, err := profile.Packages(&cfg, o.Profile, o.Release, o.Packages)
+		if err != nil {
+			return wrap("spawn", o.Name, err)
+		}
+		o.Packages = p
+	} else {
+		l, err := lockfilePath(o.Lockfile)
+		if err != nil {
+			return wrap("spawn", o.Name, err)
+		}
+		o.Lockfile = l
+	}
+
+	if err := ctx.Err(); err != nil {
+		return wrap("spawn", o.Name, err)
+	}
+
+	s := container.SpawnOptions{
+		Release:   o.Release,
+		Packages:  o.Packages,
+		Network:   o.Network,
+		Link:      o.Link,
+		Machine:   o.Machine,
+		Ephemeral: o.Ephemeral,
+		Lock:      o.Lockfile,
+		Slim:      o.Slim,
+		Pool:      o.Pool,
+		Unit:      container.UnitOptions(&cfg, m.Storage, o.Name),
+	}
+
+	return wrap("spawn", o.Name, container.Create(ctx, &cfg, m.output(), m.Storage, o.Name, &s))
+}
+
+// Boot runs the container in the foreground until it powers off or ctx is cancelled,
+// which shuts it down. Console output goes to Output.
+func (m *Manager) Boot(ctx context.Context, o BootOptions) error {
+	if err := m.exists(o.Name); err != nil {
+		return wrap("boot", o.Name, err)
+	}
+	if err := container.CheckMachine(o.Machine); err != nil {
+		return wrap("boot", o.Name, err)
+	}
+	if container.Running(o.Name) {
+		return wrap("boot", o.Name, ErrRunning)
+	}
+
+	l, err := lock.Container(ctx, o.Name, "boot")
+	if err != nil {
+		return wrap("boot", o.Name, err)
+	}
+	defer l.Release()
+
+	b := nspawn.BootOptions{
+		Directory: container.RootDir(m.Storage, o.Name),
+		Machine:   o.Machine,
+		Network:   o.Network,
+		Link:      o.Link,
+		Ephemeral: o.Ephemeral,
+		Binds:     o.Binds,
+		ReadOnly:  o.ReadOnlyBinds,
+		Security:  m.Config.Security.Profile,
+	}
+
+	return wrap("boot", o.Name, nspawn.BootContext(ctx, &b, m.output()))
+}
+
+// unit runs command on the service unit of container name and returns the result of
+// its job.
+func (m *Manager) unit(ctx context.Context, command string, name string, o UnitOptions) (string, error) {
+	if err := m.exists(name); err != nil {
+		return "", wrap(command, name, err)
+	}
+
+	u := systemd.Unit{
+		Name:    name,
+		Command: command,
+		Timeout: timeout(ctx, o.Timeout),
+	}
+
+	if err := u.ApplyCommand(); err != nil {
+		return u.Result, wrap(command, name, err)
+	}
+
+	if command != "stop" && (o.Wait || o.WaitFor != "") {
+		return u.Result, wrap(command, name, systemd.WaitForContainer(system.MachineName(name), o.WaitFor, timeout(ctx, o.Timeout)))
+	}
+
+	return u.Result, nil
+}
+
+// Start starts the service unit of a container.
+func (m *Manager) Start(ctx context.Context, name string, o UnitOptions) (string, error) {
+	return m.unit(ctx, "start", name, o)
+}
+
+func (m *Manager) Stop(ctx context.Context, name string, o UnitOptions) (string, error) {
+	return m.unit(ctx, "stop", name, o)
+}
+
+func (m *Manager) Restart(ctx context.Context, name string, o UnitOptions) (string, error) {
+	return m.unit(ctx, "restart", name, o)
+}
+
+// Wait blocks until a running container finished booting, or reached target when it
+// is not empty.
+func (m *Manager) Wait(ctx context.Context, name string, target string, d time.Duration) error {
+	if err := m.exists(name); err != nil {
+		return wrap("wait", name, err)
+	}
+
+	return wrap("wait", name, systemd.WaitForContainer(system.MachineName(name), target, timeout(ctx, d)))
+}
+
+func (m *Manager) List(ctx context.Context) ([]Summary, error) {
+	l, err := container.List(m.Config, m.Storage)
+	return l, wrap("list", "", err)
+}
+
+func (m *Manager) Inspect(ctx context.Context, name string) (*Info, error) {
+	if err := m.exists(name); err != nil {
+		return nil, wrap("inspect", name, err)
+	}
+
+	i, err := container.Inspect(m.Storage, name)
+	return i, wrap("inspect", name, err)
+}
+
+// Move migrates a stopped container to another storage pool.
+func (m *Manager) Move(ctx context.Context, name string, pool string) error {
+	if err := m.exists(name); err != nil {
+		return wrap("move", name, err)
+	}
+
+	return wrap("move", name, container.Move(ctx, m.Config, m.output(), m.Storage, name, pool))
+}
+
+// Remove deletes a stopped container with its unit and lockfile.
+func (m *Manager) Remove(ctx context.Context, name string) error {
+	// A link into a storage pool whose directory is gone still exists to be removed
+	if err := container.CheckExisting(name); err != nil {
+		return wrap("remove", name, err)
+	}
+
+	return wrap("remove", name, container.Remove(m.Storage, name))
+}
+
+// Exec runs a command inside a running container and returns its exit status.
+func (m *Manager) Exec(ctx context.Context, name string, o ExecOptions) (int, error) {
+	if err := m.exists(name); err != nil {
+		return -1, wrap("exec", name, err)
+	}
+	if len(o.Command) == 0 {
+		return -1, wrap("exec", name, fmt.Errorf("command is missing: %w", ErrInvalid))
+	}
+
+	stdout, stderr := o.Stdout, o.Stderr
+	if stdout == nil {
+		stdout = io.Discard
+	}
+	if stderr == nil {
+		stderr = io.Discard
+	}
+
+	code, err := container.Exec(ctx, name, o.Command, stdout, stderr)
+	return code, wrap("exec", name, err)
+}
+
+// Logs writes the journal of a container to w. With Follow it returns when ctx is
+// cancelled.
+func (m *Manager) Logs(ctx context.Context, name string, o LogOptions, w io.Writer) error {
+	if err := m.exists(name); err != nil {
+		return wrap("logs", name, err)
+	}
+
+	l := container.LogOptions{
+		Follow:  o.Follow,
+		Since:   o.Since,
+		Unit:    o.Unit,
+		Boot:    o.Boot,
+		Lines:   o.Lines,
+		Output:  o.Format,
+		Service: o.Service,
+	}
+
+	args, err := container.JournalArgs(m.Storage, name, &l)
+	if err != nil {
+		return wrap("logs", name, err)
+	}
+
+	err = system.ExecContextAndStream(ctx, w, args[0], args[1:]...)
+	if o.Follow && ctx.Err() != nil {
+		return nil
+	}
+
+	return wrap("logs", name, err)
+}
+
+// Copy copies files between the host and a container, one of src and dst has to
+// be NAME:/path.
+func (m *Manager) Copy(ctx context.Context, src string, dst string) error {
+	for _, p := range []string{src, dst} {
+		if name, _ := container.ParseCopyPath(p); name != "" {
+			if err := m.exists(name); err != nil {
+				return wrap("copy", name, err)
+			}
+		}
+	}
+
+	return wrap("copy", "", container.Copy(m.Storage, src, dst))
+}
+
+func (m *Manager) Packages(ctx context.Context, name string) ([]Package, error) {
+	if err := m.exists(name); err != nil {
+		return nil, wrap("packages", name, err)
+	}
+
+	p, err := container.Packages(m.Storage, name)
+	return p, wrap("packages", name, err)
+}
@@ -0,0 +1,434 @@
+// SPDX-License-Identifier: BSD-2
+// Copyright 2023 VMware, Inc.
+
+package compose
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/spf13/viper"
+
+	"github.com/vmware-samples/photon-os-container-builder/pkg/conf"
+	"github.com/vmware-samples/photon-os-container-builder/pkg/container"
+	"github.com/vmware-samples/photon-os-container-builder/pkg/errdefs"
+	"github.com/vmware-samples/photon-os-container-builder/pkg/lock"
+	"github.com/vmware-samples/photon-os-container-builder/pkg/profile"
+	"github.com/vmware-samples/photon-os-container-builder/pkg/system"
+	"github.com/vmware-samples/photon-os-container-builder/pkg/systemd"
+)
+
+const (
+	DefaultFile = "compose.toml"
+)
+
+type Service struct {
+	Name      string   `mapstructure:"Name"`
+	Release   string   `mapstructure:"Release"`
+	Profile   string   `mapstructure:"Profile"`
+	Packages  string   `mapstructure:"Packages"`
+	Network   string   `mapstructure:"Network"`
+	Link      string   `mapstructure:"Link"`
+	Machine   string   `mapstructure:"Machine"`
+	Ephemeral bool     `mapstructure:"Ephemeral"`
+	Lock      string   `mapstructure:"Lock"`
+	Slim      []string `mapstructure:"Slim"`
+	Pool      string   `mapstructure:"Pool"`
+	Requires  []string `mapstructure:"Requires"`
+	After     []string `mapstructure:"After"`
+	Volumes   []string `mapstructure:"Volumes"`
+}
+
+type Project struct {
+	Name     string    `mapstructure:"Name"`
+	Network  string    `mapstructure:"Network"`
+	Link     string    `mapstructure:"Link"`
+	Volumes  []string  `mapstructure:"Volumes"`
+	Services []Service `mapstructure:"Services"`
+
+	dir string
+}
+
+type ServiceStatus struct {
+	Service   string              `json:"service"`
+	Container string              `json:"container"`
+	Machine   string              `json:"machine"`
+	Exists    bool                `json:"exists"`
+	Status    *systemd.UnitStatus `json:"status,omitempty"`
+}
+
+// Load reads a compose file. Services inherit the project network, relative host
+// paths of volumes are taken relative to the file.
+func Load(file string) (*Project, error) {
+	v := viper.New()
+	v.SetConfigFile(file)
+
+	if err := v.ReadInConfig(); err != nil {
+		return nil, err
+	}
+
+	p := Project{}
+	if err := v.Unmarshal(&p); err != nil {
+		return nil, errdefs.Wrap(errdefs.ErrInvalid, err)
+	}
+
+	abs, err := filepath.Abs(file)
+	if err != nil {
+		return nil, err
+	}
+	p.dir = path.Dir(abs)
+
+	if p.Name == "" {
+		p.Name = path.Base(p.dir)
+	}
+
+	for i := range p.Services {
+		s := &p.Services[i]
+		if s.Network == "" && s.Link == "" {
+			s.Network = p.Network
+			s.Link = p.Link
+		}
+	}
+
+	if err := p.validate(); err != nil {
+		return nil, errdefs.Wrap(errdefs.ErrInvalid, err)
+	}
+
+	return &p, nil
+}
+
+func (p *Project) validate() error {
+	if len(p.Services) == 0 {
+		return errors.New("compose file defines no services")
+	}
+
+	seen := map[string]bool{}
+	for _, s := range p.Services {
+		if s.Name == "" {
+			return errors.New("service without name")
+		}
+		if seen[s.Name] {
+			return fmt.Errorf("service '%s' is defined twice", s.Name)
+		}
+		seen[s.Name] = true
+
+		if (s.Network == "") != (s.Link == "") {
+			return fmt.Errorf("service '%s': network and link have to be specified together", s.Name)
+		}
+		if s.Lock != "" && (s.Packages != "" || s.Profile != "") {
+			return fmt.Errorf("service '%s': lock cannot be combined with packages or profile", s.Name)
+		}
+
+		if err := container.CheckName(p.Container(&s)); err != nil {
+			return fmt.Errorf("service '%s': %w", s.Name, err)
+		}
+		if err := container.CheckMachine(s.Machine); err != nil {
+			return fmt.Errorf("service '%s': %w", s.Name, err)
+		}
+	}
+
+	for _, s := range p.Services {
+		for _, d := range append(append([]string{}, s.Requires...), s.After...) {
+			if !seen[d] {
+				return fmt.Errorf("service '%s' depends on unknown service '%s'", s.Name, d)
+			}
+		}
+	}
+
+	_, err := p.Order()
+	return err
+}
+
+func (p *Project) Service(name string) (*Service, bool) {
+	for i := range p.Services {
+		if p.Services[i].Name == name {
+			return &p.Services[i], true
+		}
+	}
+
+	return nil, false
+}
+
+func (p *Project) Container(s *Service) string {
+	return p.Name + "-" + s.Name
+}
+
+func (p *Project) Target() string {
+	return "cntrctl-" + p.Name + ".target"
+}
+
+// Slice returns the slice the containers of the project run in, below machine.slice.
+// Dashes denote nesting in slice names, so they are replaced in the project name.
+func (p *Project) Slice() string {
+	return "machine-" + strings.ReplaceAll(p.Name, "-", "_") + ".slice"
+}
+
+func (p *Project) Containers() []string {
+	var r []string
+	for i := range p.Services {
+		r = append(r, p.Container(&p.Services[i]))
+	}
+
+	return r
+}
+
+// Order sorts the services so every service comes after the ones it requires or is
+// ordered after.
+func (p *Project) Order() ([]*Service, error) {
+	const (
+		visiting = 1
+		visited  = 2
+	)
+
+	state := map[string]int{}
+	var order []*Service
+
+	var visit func(s *Service, chain []string) error
+	visit = func(s *Service, chain []string) error {
+		switch state[s.Name] {
+		case visited:
+			return nil
+		case visiting:
+			return fmt.Errorf("dependency cycle: %s", strings.Join(append(chain, s.Name), " -> "))
+		}
+
+		state[s.Name] = visiting
+		for _, d := range append(append([]string{}, s.Requires...), s.After...) {
+			dep, ok := p.Service(d)
+			if !ok {
+				continue
+			}
+
+			if err := visit(dep, append(chain, s.Name)); err != nil {
+				return err
+			}
+		}
+		state[s.Name] = visited
+
+		order = append(order, s)
+		return nil
+	}
+
+	for i := range p.Services {
+		if err := visit(&p.Services[i], nil); err != nil {
+			return nil, err
+		}
+	}
+
+	return order, nil
+}
+
+func (p *Project) VolumeDir(volume string) string {
+	return path.Join(conf.DefaultVolumesDir, p.Name, volume)
+}
+
+// binds translates the volumes of a service into systemd-nspawn bind specifications.
+// A volume is SRC:DST[:ro] where SRC is a named volume of the project or a host path.
+func (p *Project) binds(s *Service) ([]string, []string, error) {
+	var rw, ro []string
+
+	for _, v := range s.Volumes {
+		f := strings.Split(v, ":")
+		if len(f) < 2 || len(f) > 3 || (len(f) == 3 && f[2] != "ro" && f[2] != "rw") {
+			return nil, nil, fmt.Errorf("service '%s': invalid volume '%s'", s.Name, v)
+		}
+
+		src := f[0]
+		switch {
+		case path.IsAbs(src):
+		case strings.HasPrefix(src, "."):
+			src = path.Join(p.dir, src)
+		default:
+			named := false
+			for _, n := range p.Volumes {
+				if n == src {
+					named = true
+				}
+			}
+			if !named {
+				return nil, nil, errdefs.Errorf(errdefs.ErrInvalid, "service '%s': unknown volume '%s'", s.Name, src)
+			}
+
+			src = p.VolumeDir(src)
+		}
+
+		if len(f) == 3 && f[2] == "ro" {
+			ro = append(ro, src+":"+f[1])
+		} else {
+			rw = append(rw, src+":"+f[1])
+		}
+	}
+
+	return rw, ro, nil
+}
+
+// Up builds missing containers in dependency order, (re)creates their service units
+// within the project target and slice and starts the target. Progress is written to w.
+func Up(cfg *conf.Config, w io.Writer, base string, p *Project, timeout time.Duration) error {
+	order, err := p.Order()
+	if err != nil {
+		return err
+	}
+
+	for _, v := range p.Volumes {
+		if err := system.MkdirAll(p.VolumeDir(v), 0755); err != nil {
+			return fmt.Errorf("failed to create volume '%s': %w", v, err)
+		}
+	}
+
+	var units []string
+	for _, s := range order {
+		c := p.Container(s)
+
+		rw, ro, err := p.binds(s)
+		if err != nil {
+			return err
+		}
+
+		o := container.UnitOptions(cfg, base, c)
+		o.Description = fmt.Sprintf("Photon OS container %s of %s", s.Name, p.Name)
+		o.Target = p.Target()
+		o.Slice = p.Slice()
+		o.Binds = rw
+		o.ReadOnlyBinds = ro
+
+		for _, d := range s.Requires {
+			dep, _ := p.Service(d)
+			o.Requires = append(o.Requires, p.Container(dep)+".service")
+			o.After = append(o.After, p.Container(dep)+".service")
+		}
+		for _, d := range s.After {
+			dep, _ := p.Service(d)
+			o.After = append(o.After, p.Container(dep)+".service")
+		}
+
+		if system.PathExists(path.Join(base, c)) {
+			fmt.Fprintf(w, "Container '%s' of service '%s' exists\n", c, s.Name)
+
+			l, err := lock.Container(context.Background(), c, "compose-up")
+			if err != nil {
+				return err
+			}
+
+			err = systemd.SetupContainerService(c, s.Network, s.Link, s.Machine, s.Ephemeral, o)
+			l.Release()
+			if err != nil {
+				return fmt.Errorf("failed to create unit file for '%s': %w", c, err)
+			}
+		} else {
+			fmt.Fprintf(w, "Building container '%s' of service '%s'\n", c, s.Name)
+
+			release := s.Release
+			if release == "" && s.Lock == "" {
+				release = cfg.System.Release
+			}
+
+			var packages string
+			if s.Lock == "" {
+				pkgs, err := profile.Packages(cfg, s.Profile, release, s.Packages)
+				if err != nil {
+					return fmt.Errorf("service '%s': %w", s.Name, err)
+				}
+				packages = pkgs
+			}
+
+			so := container.SpawnOptions{
+				Release:   release,
+				Packages:  packages,
+				Network:   s.Network,
+				Link:      s.Link,
+				Machine:   s.Machine,
+				Ephemeral: s.Ephemeral,
+				Lock:      s.Lock,
+				Slim:      s.Slim,
+				Pool:      s.Pool,
+				Unit:      o,
+			}
+
+			if err := container.Create(context.Background(), cfg, w, base, c, &so); err != nil {
+				return fmt.Errorf("failed to build container '%s': %w", c, err)
+			}
+		}
+
+		units = append(units, c+".service")
+	}
+
+	if err := systemd.SetupTarget(p.Target(), "Photon OS containers of "+p.Name, units); err != nil {
+		return fmt.Errorf("failed to create target '%s': %w", p.Target(), err)
+	}
+
+	u := systemd.Unit{
+		Name:    p.Target(),
+		Command: "start",
+		Timeout: timeout,
+	}
+
+	if err := u.ApplyCommand(); err != nil {
+		return fmt.Errorf("failed to start '%s': %w", p.Target(), err)
+	}
+
+	fmt.Fprintf(w, "start %s: %s\n", u.Name, u.Result)
+	return nil
+}
+
+// Down stops the project target, which takes all containers of the project with it.
+// With remove the unit files are deleted as well, container roots and volumes are kept.
+func Down(w io.Writer, p *Project, remove bool, timeout time.Duration) error {
+	u := systemd.Unit{
+		Name:    p.Target(),
+		Command: "stop",
+		Timeout: timeout,
+	}
+
+	if err := u.ApplyCommand(); err != nil {
+		return fmt.Errorf("failed to stop '%s': %w", p.Target(), err)
+	}
+	fmt.Fprintf(w, "stop %s: %s\n", u.Name, u.Result)
+
+	if !remove {
+		return nil
+	}
+
+	for _, c := range p.Containers() {
+		if err := system.RemoveUnitFile(c); err != nil && !os.IsNotExist(err) {
+			return fmt.Errorf("failed to remove unit file of '%s': %w", c, err)
+		}
+	}
+
+	if err := system.Remove(system.TargetFilePath(p.Target())); err != nil && !os.IsNotExist(err) {
+		return fmt.Errorf("failed to remove target '%s': %w", p.Target(), err)
+	}
+
+	return systemd.Reload()
+}
+
+func Ps(base string, p *Project) []ServiceStatus {
+	var r []ServiceStatus
+
+	for i := range p.Services {
+		c := p.Container(&p.Services[i])
+
+		s := ServiceStatus{
+			Service:   p.Services[i].Name,
+			Container: c,
+			Machine:   system.MachineName(c),
+			Exists:    system.PathExists(path.Join(base, c)),
+		}
+
+		u := systemd.Unit{Name: c}
+		if st, err := u.Status(); err == nil {
+			s.Status = st
+		}
+
+		r = append(r, s)
+	}
+
+	return r
+}
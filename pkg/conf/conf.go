@@ -4,50 +4,460 @@
 package conf
 
 import (
-	"fmt"
+	"errors"
+	"io/fs"
+	"os"
+	"path"
+	"sort"
+	"strings"
 
+	"github.com/mitchellh/mapstructure"
 	"github.com/spf13/viper"
+
+	"github.com/vmware-samples/photon-os-container-builder/pkg/errdefs"
 )
 
 const (
 	DefaultLogLevel       = "info"
 	DefaultReleaseVersion = "5.0"
 	DefaultStorageDir     = "/var/lib/machines"
-	DefaultUnitFilePath   = "/etc/systemd/system"
+	DefaultUnitFilePath   = "/lib/systemd/system"
 	DefaultGPGDir         = "/etc/pki/rpm-gpg"
+	DefaultLockfileDir    = "/var/lib/photon-os-container/lockfiles"
+	DefaultJournalDir     = "/var/lib/photon-os-container/journal"
+	DefaultLockDir        = "/run/photon-os-container/lock"
+	DefaultLockTimeout    = "30s"
+	DefaultCacheDir       = "/var/cache/photon-os-container"
+	DefaultVolumesDir     = "/var/lib/photon-os-container/volumes"
 	DefaultPackages       = "systemd,dbus,iproute2,tdnf,photon-release,photon-repos,curl,shadow,ncurses-terminfo,iputils,glibc,zlib," +
 		"filesystem,pkg-config,bash,bzip2,procps-ng,iana-etc,coreutils,bc,libtool,net-tools,findutils,xz,util-linux," +
 		"ca-certificates,Linux-PAM,file,e2fsprogs,rpm,openssh,gdbm,python3,python3-libs,python3-xml,sed,grep,cpio,gzip," +
 		"vim,open-vm-tools,cloud-init,krb5,which,tzdata,"
 
-	Version  = "0.1"
-	ConfPath = "/etc/photon-os-container/"
-	ConfFile = "photon-os-container"
+	DefaultTasksMax = "16384"
+
+	Version   = "0.1"
+	ConfPath  = "/etc/photon-os-container/"
+	ConfFile  = "photon-os-container"
+	EnvPrefix = "CNTRCTL"
+)
+
+// Security profiles select the privileges of systemd-nspawn.
+const (
+	// SecurityPrivileged grants all capabilities to the container.
+	SecurityPrivileged = "privileged"
+	// SecurityDefault keeps the capability set of systemd-nspawn.
+	SecurityDefault = "default"
+	// SecurityUserNS additionally runs the container in its own user namespace.
+	SecurityUserNS = "userns"
+
+	DefaultSecurityProfile = SecurityPrivileged
 )
 
 type System struct {
-	Packages string `mapstructure:"Packages"`
-	Release  string `mapstructure:"Release"`
+	LogLevel   string `mapstructure:"LogLevel"`
+	LogFormat  string `mapstructure:"LogFormat"`
+	Packages   string `mapstructure:"Packages"`
+	Release    string `mapstructure:"Release"`
+	StorageDir string `mapstructure:"StorageDir"`
+	UnitDir    string `mapstructure:"UnitDir"`
+	// LockTimeout is how long to wait for a container another cntrctl is working on.
+	LockTimeout string `mapstructure:"LockTimeout"`
+}
+
+// Network is the network of new containers when spawn is given neither --network
+// nor --link.
+type Network struct {
+	Type string `mapstructure:"Type"`
+	Link string `mapstructure:"Link"`
+}
+
+type Security struct {
+	Profile string `mapstructure:"Profile"`
+}
+
+// Repos selects the tdnf repositories packages are installed from.
+type Repos struct {
+	Dir     string   `mapstructure:"Dir"`
+	Enable  []string `mapstructure:"Enable"`
+	Disable []string `mapstructure:"Disable"`
+}
+
+// Limits are the resource limits of the service units of containers, in the syntax
+// of systemd.resource-control(5).
+type Limits struct {
+	CPUQuota  string `mapstructure:"CPUQuota"`
+	MemoryMax string `mapstructure:"MemoryMax"`
+	TasksMax  string `mapstructure:"TasksMax"`
 }
+
+type Cache struct {
+	Dir     string `mapstructure:"Dir"`
+	Offline bool   `mapstructure:"Offline"`
+}
+
+type GPGRelease struct {
+	Release string   `mapstructure:"Release"`
+	Keys    []string `mapstructure:"Keys"`
+}
+
+type GPG struct {
+	Dir      string       `mapstructure:"Dir"`
+	Check    bool         `mapstructure:"Check"`
+	Releases []GPGRelease `mapstructure:"Releases"`
+}
+
+type SlimProfile struct {
+	Name        string   `mapstructure:"Name"`
+	Include     []string `mapstructure:"Include"`
+	ExcludeDocs bool     `mapstructure:"ExcludeDocs"`
+	Languages   bool     `mapstructure:"Languages"`
+	Paths       []string `mapstructure:"Paths"`
+}
+
+type Slim struct {
+	Profiles []SlimProfile `mapstructure:"Profiles"`
+}
+
+// PackageRelease edits the packages of a profile for one release.
+type PackageRelease struct {
+	Release  string   `mapstructure:"Release"`
+	Packages []string `mapstructure:"Packages"`
+}
+
+// PackageProfile is a named package set. Packages are names to add, +NAME to add,
+// -NAME to remove or @FILE to read further entries from a file, one per line.
+type PackageProfile struct {
+	Name        string           `mapstructure:"Name"`
+	Description string           `mapstructure:"Description"`
+	Include     []string         `mapstructure:"Include"`
+	Packages    []string         `mapstructure:"Packages"`
+	Releases    []PackageRelease `mapstructure:"Releases"`
+}
+
+type Packages struct {
+	Profiles []PackageProfile `mapstructure:"Profiles"`
+}
+
+// StoragePool is a directory containers are created in with spawn --pool, for example
+// on a faster or larger volume than System.StorageDir.
+type StoragePool struct {
+	Name string `mapstructure:"Name"`
+	Dir  string `mapstructure:"Dir"`
+}
+
+type Storage struct {
+	Pools []StoragePool `mapstructure:"Pools"`
+}
+
 type Config struct {
-	System System `mapstructure:"System"`
+	System   System   `mapstructure:"System"`
+	Network  Network  `mapstructure:"Network"`
+	Security Security `mapstructure:"Security"`
+	Repos    Repos    `mapstructure:"Repos"`
+	Cache    Cache    `mapstructure:"Cache"`
+	Limits   Limits   `mapstructure:"Limits"`
+	GPG      GPG      `mapstructure:"GPG"`
+	Slim     Slim     `mapstructure:"Slim"`
+	Packages Packages `mapstructure:"Packages"`
+	Storage  Storage  `mapstructure:"Storage"`
+
+	// Sources lists the files and environment variables the configuration was read
+	// from, in order of precedence.
+	Sources []string `mapstructure:"-" toml:"-" json:"-"`
 }
 
-func Parse() (*Config, error) {
-	viper.SetConfigName(ConfFile)
-	viper.AddConfigPath(ConfPath)
+// DefaultGPGKeys lists the keys bundled with photon-repos that sign each release.
+var DefaultGPGKeys = map[string][]string{
+	"3.0": {"VMWARE-RPM-GPG-KEY"},
+	"4.0": {"VMWARE-RPM-GPG-KEY", "VMWARE-RPM-GPG-KEY-4096"},
+	"5.0": {"VMWARE-RPM-GPG-KEY", "VMWARE-RPM-GPG-KEY-4096"},
+}
+
+// DefaultSlimProfiles are available to --slim unless a profile of the same name is
+// configured.
+var DefaultSlimProfiles = []SlimProfile{
+	{
+		Name:        "docs",
+		ExcludeDocs: true,
+		Paths:       []string{"usr/share/doc/*", "usr/share/man/*", "usr/share/info/*", "usr/share/gtk-doc/*"},
+	},
+	{
+		Name:      "locales",
+		Languages: true,
+		Paths:     []string{"usr/share/locale/*", "usr/lib/locale/*", "usr/share/i18n/locales/*"},
+	},
+	{
+		Name:  "cache",
+		Paths: []string{"var/cache/tdnf/*", "var/lib/rpm/__db.*"},
+	},
+	{
+		Name:    "minimal",
+		Include: []string{"docs", "locales", "cache"},
+	},
+}
 
-	if err := viper.ReadInConfig(); err != nil {
-		fmt.Errorf("Failed to read configuration: %+v", err)
+// DefaultPackageProfile is the profile of spawns that select none. Its packages are
+// System.Packages.
+const DefaultPackageProfile = "default"
+
+// DefaultPackageProfiles are available to --profile unless a profile of the same name
+// is configured.
+var DefaultPackageProfiles = []PackageProfile{
+	{
+		Name:        "minimal",
+		Description: "Shell, package manager and release files, no init system",
+		Packages: []string{"filesystem", "photon-release", "photon-repos", "bash", "coreutils", "shadow", "tdnf",
+			"ncurses-terminfo"},
+	},
+	{
+		Name:        "systemd-boot",
+		Description: "minimal with systemd and networking, enough to boot",
+		Include:     []string{"minimal"},
+		Packages:    []string{"systemd", "dbus", "iproute2", "iputils", "procps-ng", "util-linux"},
+	},
+	{
+		Name:        "dev",
+		Description: "default with compilers and debugging tools",
+		Include:     []string{DefaultPackageProfile},
+		Packages:    []string{"gcc", "make", "binutils", "glibc-devel", "git", "gdb", "strace"},
+	},
+	{
+		Name:        "test-kernel",
+		Description: "systemd-boot with a kernel and module tools for kernel tests",
+		Include:     []string{"systemd-boot"},
+		Packages:    []string{"linux", "kmod", "kbd"},
+	},
+}
+
+// PackageProfile returns the configured or bundled package profile called name.
+func (c *Config) PackageProfile(name string) (*PackageProfile, bool) {
+	for i := range c.Packages.Profiles {
+		if c.Packages.Profiles[i].Name == name {
+			return &c.Packages.Profiles[i], true
+		}
+	}
+
+	if name == DefaultPackageProfile {
+		return &PackageProfile{
+			Name:        DefaultPackageProfile,
+			Description: "System.Packages of the configuration",
+			Packages:    strings.Split(c.System.Packages, ","),
+		}, true
+	}
+
+	for i := range DefaultPackageProfiles {
+		if DefaultPackageProfiles[i].Name == name {
+			return &DefaultPackageProfiles[i], true
+		}
 	}
 
-	viper.SetDefault("System.Release", DefaultReleaseVersion)
-	viper.SetDefault("System.Packages", DefaultPackages)
+	return nil, false
+}
+
+// PackageProfiles returns the names of all configured and bundled package profiles.
+func (c *Config) PackageProfiles() []string {
+	names := []string{DefaultPackageProfile}
+	for _, p := range DefaultPackageProfiles {
+		names = append(names, p.Name)
+	}
+	for _, p := range c.Packages.Profiles {
+		if !contains(names, p.Name) {
+			names = append(names, p.Name)
+		}
+	}
+
+	return names
+}
+
+func contains(l []string, s string) bool {
+	for _, e := range l {
+		if e == s {
+			return true
+		}
+	}
+
+	return false
+}
+
+// DefaultStoragePool is the pool of spawns that select none. Its directory is
+// System.StorageDir, where machined discovers the containers of all pools.
+const DefaultStoragePool = "default"
+
+// StoragePool returns the configured storage pool called name.
+func (c *Config) StoragePool(name string) (*StoragePool, bool) {
+	for _, p := range c.StoragePools() {
+		if p.Name == name {
+			return &p, true
+		}
+	}
+
+	return nil, false
+}
+
+// StoragePools returns the default and all configured storage pools.
+func (c *Config) StoragePools() []StoragePool {
+	pools := []StoragePool{{Name: DefaultStoragePool, Dir: c.System.StorageDir}}
+	for _, p := range c.Storage.Pools {
+		if p.Name != DefaultStoragePool {
+			pools = append(pools, p)
+		}
+	}
+
+	return pools
+}
+
+// SlimProfile returns the configured or bundled profile called name.
+func (c *Config) SlimProfile(name string) (*SlimProfile, bool) {
+	for i := range c.Slim.Profiles {
+		if c.Slim.Profiles[i].Name == name {
+			return &c.Slim.Profiles[i], true
+		}
+	}
+
+	for i := range DefaultSlimProfiles {
+		if DefaultSlimProfiles[i].Name == name {
+			return &DefaultSlimProfiles[i], true
+		}
+	}
+
+	return nil, false
+}
+
+// ReleaseGPGKeys returns the key files trusted for release. Configured keys take
+// precedence over the bundled defaults.
+func (c *Config) ReleaseGPGKeys(release string) []string {
+	keys := DefaultGPGKeys[release]
+	for _, r := range c.GPG.Releases {
+		if r.Release == release {
+			keys = r.Keys
+		}
+	}
+
+	var r []string
+	for _, k := range keys {
+		if !path.IsAbs(k) {
+			k = path.Join(c.GPG.Dir, k)
+		}
+		r = append(r, k)
+	}
+
+	return r
+}
+
+// SystemFile returns the path of the system wide configuration file.
+func SystemFile() string {
+	return path.Join(ConfPath, ConfFile+".toml")
+}
+
+// UserFile returns the path of the per-user configuration file below
+// $XDG_CONFIG_HOME, or ~/.config when it is not set.
+func UserFile() string {
+	d := os.Getenv("XDG_CONFIG_HOME")
+	if d == "" {
+		h, err := os.UserHomeDir()
+		if err != nil {
+			return ""
+		}
+		d = path.Join(h, ".config")
+	}
+
+	return path.Join(d, "photon-os-container", ConfFile+".toml")
+}
+
+func setDefaults(v *viper.Viper) {
+	v.SetDefault("System.LogLevel", DefaultLogLevel)
+	v.SetDefault("System.LogFormat", "")
+	v.SetDefault("System.Release", DefaultReleaseVersion)
+	v.SetDefault("System.Packages", DefaultPackages)
+	v.SetDefault("System.StorageDir", DefaultStorageDir)
+	v.SetDefault("System.LockTimeout", DefaultLockTimeout)
+	v.SetDefault("System.UnitDir", DefaultUnitFilePath)
+	v.SetDefault("Network.Type", "")
+	v.SetDefault("Network.Link", "")
+	v.SetDefault("Security.Profile", DefaultSecurityProfile)
+	v.SetDefault("Repos.Dir", "")
+	v.SetDefault("Repos.Enable", []string{})
+	v.SetDefault("Repos.Disable", []string{})
+	v.SetDefault("Cache.Dir", DefaultCacheDir)
+	v.SetDefault("Cache.Offline", false)
+	v.SetDefault("Limits.CPUQuota", "")
+	v.SetDefault("Limits.MemoryMax", "")
+	v.SetDefault("Limits.TasksMax", DefaultTasksMax)
+	v.SetDefault("GPG.Dir", DefaultGPGDir)
+	v.SetDefault("GPG.Check", true)
+}
+
+func load(file string, strict bool) (*Config, error) {
+	v := viper.New()
+	v.SetConfigType("toml")
+	setDefaults(v)
 
 	c := Config{}
-	if err := viper.Unmarshal(&c); err != nil {
-		fmt.Println("Failed to parse config file: '/etc/photon-os-container/photon-os-container.toml'")
+	var errs []error
+
+	for _, f := range []string{SystemFile(), UserFile(), file} {
+		if f == "" {
+			continue
+		}
+
+		v.SetConfigFile(f)
+		if err := v.MergeInConfig(); err != nil {
+			if errors.Is(err, fs.ErrNotExist) {
+				if f == file {
+					errs = append(errs, err)
+				}
+				continue
+			}
+
+			errs = append(errs, errdefs.Errorf(errdefs.ErrInvalid, "failed to read configuration '%s': %w", f, err))
+			continue
+		}
+
+		c.Sources = append(c.Sources, f)
+	}
+
+	v.SetEnvPrefix(EnvPrefix)
+	v.SetEnvKeyReplacer(strings.NewReplacer(".", "_"))
+	v.AutomaticEnv()
+
+	var env []string
+	for _, e := range os.Environ() {
+		if k, _, _ := strings.Cut(e, "="); strings.HasPrefix(k, EnvPrefix+"_") {
+			env = append(env, "$"+k)
+		}
+	}
+	sort.Strings(env)
+	c.Sources = append(c.Sources, env...)
+
+	var opts []viper.DecoderConfigOption
+	if strict {
+		opts = append(opts, func(d *mapstructure.DecoderConfig) {
+			d.ErrorUnused = true
+		})
 	}
 
-	return &c, nil
+	if err := v.Unmarshal(&c, opts...); err != nil {
+		errs = append(errs, errdefs.Errorf(errdefs.ErrInvalid, "failed to parse configuration: %w", err))
+	}
+
+	return &c, errors.Join(errs...)
+}
+
+// Load reads the configuration in layers: the system file, the per-user file, file
+// when not empty and CNTRCTL_SECTION_KEY environment variables, each overriding the
+// ones before. The returned Config carries the defaults even when a layer is
+// malformed, the error is returned alongside.
+func Load(file string) (*Config, error) {
+	return load(file, false)
+}
+
+// LoadStrict is Load that also fails on keys the configuration does not know.
+func LoadStrict(file string) (*Config, error) {
+	return load(file, true)
+}
+
+// Parse reads the configuration without an explicit file.
+func Parse() (*Config, error) {
+	return Load("")
 }
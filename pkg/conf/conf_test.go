@@ -0,0 +1,189 @@
+// SPDX-License-Identifier: BSD-2
+// Copyright 2023 VMware, Inc.
+
+package conf
+
+import (
+	"os"
+	"path"
+	"reflect"
+	"testing"
+
+	"github.com/vmware-samples/photon-os-container-builder/pkg/errdefs"
+)
+
+// setupLayers points the per-user file into a scratch directory and writes the user
+// and explicit files, an empty content leaves the file out.
+func setupLayers(t *testing.T, user string, file string) string {
+	t.Helper()
+
+	if _, err := os.Stat(SystemFile()); err == nil {
+		t.Skipf("'%s' exists on this host", SystemFile())
+	}
+
+	dir := t.TempDir()
+	t.Setenv("XDG_CONFIG_HOME", dir)
+
+	if user != "" {
+		if err := os.MkdirAll(path.Dir(UserFile()), 0755); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.WriteFile(UserFile(), []byte(user), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	if file == "" {
+		return ""
+	}
+
+	f := path.Join(dir, "cntrctl.toml")
+	if err := os.WriteFile(f, []byte(file), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	return f
+}
+
+func TestLoadLayers(t *testing.T) {
+	tests := []struct {
+		name    string
+		user    string
+		file    string
+		env     map[string]string
+		release string
+		level   string
+		storage string
+		sources []string
+	}{
+		{
+			name:    "defaults",
+			release: DefaultReleaseVersion,
+			level:   DefaultLogLevel,
+			storage: DefaultStorageDir,
+		},
+		{
+			name:    "user file",
+			user:    "[System]\nRelease = \"4.0\"\nLogLevel = \"debug\"\n",
+			release: "4.0",
+			level:   "debug",
+			storage: DefaultStorageDir,
+			sources: []string{"user"},
+		},
+		{
+			name:    "file over user file",
+			user:    "[System]\nRelease = \"4.0\"\nLogLevel = \"debug\"\n",
+			file:    "[System]\nRelease = \"3.0\"\nStorageDir = \"/srv/machines\"\n",
+			release: "3.0",
+			level:   "debug",
+			storage: "/srv/machines",
+			sources: []string{"user", "file"},
+		},
+		{
+			name:    "environment over files",
+			user:    "[System]\nRelease = \"4.0\"\nLogLevel = \"debug\"\n",
+			file:    "[System]\nRelease = \"3.0\"\n",
+			env:     map[string]string{"CNTRCTL_SYSTEM_RELEASE": "5.0", "CNTRCTL_SYSTEM_STORAGEDIR": "/data"},
+			release: "5.0",
+			level:   "debug",
+			storage: "/data",
+			sources: []string{"user", "file", "$CNTRCTL_SYSTEM_RELEASE", "$CNTRCTL_SYSTEM_STORAGEDIR"},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			f := setupLayers(t, tt.user, tt.file)
+			for k, v := range tt.env {
+				t.Setenv(k, v)
+			}
+
+			c, err := Load(f)
+			if err != nil {
+				t.Fatalf("Load() error = %v", err)
+			}
+
+			if c.System.Release != tt.release || c.System.LogLevel != tt.level || c.System.StorageDir != tt.storage {
+				t.Errorf("Release, LogLevel, StorageDir = %q, %q, %q, want %q, %q, %q", c.System.Release,
+					c.System.LogLevel, c.System.StorageDir, tt.release, tt.level, tt.storage)
+			}
+
+			var sources []string
+			for _, s := range tt.sources {
+				switch s {
+				case "user":
+					s = UserFile()
+				case "file":
+					s = f
+				}
+				sources = append(sources, s)
+			}
+			if !reflect.DeepEqual(c.Sources, sources) {
+				t.Errorf("Sources = %q, want %q", c.Sources, sources)
+			}
+		})
+	}
+}
+
+func TestLoadErrors(t *testing.T) {
+	tests := []struct {
+		name   string
+		user   string
+		file   string
+		strict bool
+		kind   error
+	}{
+		{
+			name: "malformed user file",
+			user: "[System\nRelease = \"4.0\"\n",
+			kind: errdefs.ErrInvalid,
+		},
+		{
+			name: "malformed file",
+			file: "Release = ",
+			kind: errdefs.ErrInvalid,
+		},
+		{
+			name:   "unknown key",
+			file:   "[System]\nReleases = \"4.0\"\n",
+			strict: true,
+			kind:   errdefs.ErrInvalid,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			f := setupLayers(t, tt.user, tt.file)
+
+			load := Load
+			if tt.strict {
+				load = LoadStrict
+			}
+
+			c, err := load(f)
+			if err == nil {
+				t.Fatal("Load() succeeded")
+			}
+			if errdefs.Kind(err) != tt.kind {
+				t.Errorf("Load() error = %v, want kind %v", err, tt.kind)
+			}
+			if c == nil || c.System.StorageDir != DefaultStorageDir {
+				t.Errorf("Load() did not return the defaults alongside the error")
+			}
+		})
+	}
+
+	t.Run("unknown key without strict", func(t *testing.T) {
+		f := setupLayers(t, "", "[System]\nReleases = \"4.0\"\n")
+		if _, err := Load(f); err != nil {
+			t.Errorf("Load() error = %v", err)
+		}
+	})
+
+	t.Run("missing file", func(t *testing.T) {
+		setupLayers(t, "", "")
+		if _, err := Load(path.Join(t.TempDir(), "missing.toml")); err == nil {
+			t.Error("Load() of a missing file succeeded")
+		}
+	})
+}
@@ -0,0 +1,216 @@
+// SPDX-License-Identifier: BSD-2
+// Copyright 2023 VMware, Inc.
+
+package conf
+
+import (
+	"bytes"
+	"errors"
+	"io/fs"
+	"os"
+	"reflect"
+	"strconv"
+	"strings"
+
+	"github.com/pelletier/go-toml/v2"
+	"github.com/spf13/viper"
+
+	"github.com/vmware-samples/photon-os-container-builder/pkg/errdefs"
+)
+
+// field resolves a case insensitive Section.Key to its struct field and returns the
+// key as spelled in the configuration file.
+func field(v reflect.Value, key string) (reflect.Value, string, error) {
+	var names []string
+
+	for _, part := range strings.Split(key, ".") {
+		if v.Kind() != reflect.Struct {
+			return reflect.Value{}, "", errdefs.Errorf(errdefs.ErrNotFound, "unknown configuration key '%s'", key)
+		}
+
+		found := false
+		for i := 0; i < v.NumField(); i++ {
+			name := v.Type().Field(i).Tag.Get("mapstructure")
+			if name != "" && name != "-" && strings.EqualFold(name, part) {
+				v = v.Field(i)
+				names = append(names, name)
+				found = true
+				break
+			}
+		}
+
+		if !found {
+			return reflect.Value{}, "", errdefs.Errorf(errdefs.ErrNotFound, "unknown configuration key '%s'", key)
+		}
+	}
+
+	return v, strings.Join(names, "."), nil
+}
+
+// Get returns the value of Section.Key, or of a whole section.
+func (c *Config) Get(key string) (interface{}, error) {
+	v, _, err := field(reflect.ValueOf(c).Elem(), key)
+	if err != nil {
+		return nil, err
+	}
+
+	return v.Interface(), nil
+}
+
+// parseValue converts value to the type of the key it is set for. Lists are
+// separated by ','.
+func parseValue(t reflect.Type, key string, value string) (interface{}, error) {
+	switch t.Kind() {
+	case reflect.String:
+		return value, nil
+	case reflect.Bool:
+		b, err := strconv.ParseBool(value)
+		if err != nil {
+			return nil, errdefs.Errorf(errdefs.ErrInvalid, "%s: expected true or false", key)
+		}
+		return b, nil
+	case reflect.Slice:
+		if t.Elem().Kind() == reflect.String {
+			l := []string{}
+			for _, s := range strings.Split(value, ",") {
+				if s = strings.TrimSpace(s); s != "" {
+					l = append(l, s)
+				}
+			}
+			return l, nil
+		}
+	}
+
+	return nil, errdefs.Errorf(errdefs.ErrInvalid, "%s cannot be set from the command line, edit the file instead", key)
+}
+
+// Set returns the contents of file with Section.Key = value, keeping its other
+// settings, for the caller to write. The value is validated first. The file is edited
+// in place, so its comments and the spelling of other keys are preserved.
+func Set(file string, key string, value string) ([]byte, error) {
+	c := Config{}
+	f, name, err := field(reflect.ValueOf(&c).Elem(), key)
+	if err != nil {
+		return nil, err
+	}
+
+	val, err := parseValue(f.Type(), name, value)
+	if err != nil {
+		return nil, err
+	}
+
+	b, err := os.ReadFile(file)
+	if err != nil && !errors.Is(err, fs.ErrNotExist) {
+		return nil, errdefs.Errorf(errdefs.ErrInvalid, "failed to read configuration '%s': %w", file, err)
+	}
+
+	section, k, _ := strings.Cut(name, ".")
+	b, err = setKey(b, section, k, val)
+	if err != nil {
+		return nil, err
+	}
+
+	v := viper.New()
+	v.SetConfigType("toml")
+	setDefaults(v)
+	if err := v.ReadConfig(bytes.NewReader(b)); err != nil {
+		return nil, errdefs.Errorf(errdefs.ErrInvalid, "failed to read configuration '%s': %w", file, err)
+	}
+	if err := v.Unmarshal(&c); err != nil {
+		return nil, errdefs.Errorf(errdefs.ErrInvalid, "failed to parse configuration: %w", err)
+	}
+	if err := c.Validate(); err != nil {
+		return nil, err
+	}
+
+	return b, nil
+}
+
+// setKey replaces the value of key in section of the TOML document b, or adds the
+// key, and the section when it is missing.
+func setKey(b []byte, section string, key string, val interface{}) ([]byte, error) {
+	enc, err := toml.Marshal(map[string]interface{}{key: val})
+	if err != nil {
+		return nil, err
+	}
+	line := strings.TrimSpace(string(enc))
+
+	lines := strings.Split(strings.TrimRight(string(b), "\n"), "\n")
+	if len(b) == 0 {
+		lines = nil
+	}
+
+	in, last := false, -1
+	for i := 0; i < len(lines); i++ {
+		t := strings.TrimSpace(lines[i])
+
+		if strings.HasPrefix(t, "[") {
+			if in {
+				break
+			}
+
+			h := strings.TrimSpace(strings.SplitN(t, "#", 2)[0])
+			in = !strings.HasPrefix(h, "[[") && strings.EqualFold(strings.TrimSpace(strings.Trim(h, "[]")), section)
+			if in {
+				last = i
+			}
+			continue
+		}
+
+		if !in || t == "" || strings.HasPrefix(t, "#") {
+			continue
+		}
+		last = i
+
+		k, rest, ok := strings.Cut(t, "=")
+		if !ok || !strings.EqualFold(strings.Trim(strings.TrimSpace(k), `"'`), key) {
+			continue
+		}
+
+		// A value may be an array spanning several lines
+		end := i
+		for depth := brackets(rest); depth > 0 && end+1 < len(lines); {
+			end++
+			depth += brackets(lines[end])
+		}
+
+		lines = append(lines[:i], append([]string{line}, lines[end+1:]...)...)
+		return []byte(strings.Join(lines, "\n") + "\n"), nil
+	}
+
+	if last < 0 {
+		if len(lines) > 0 {
+			lines = append(lines, "")
+		}
+		lines = append(lines, "["+section+"]", line)
+	} else {
+		lines = append(lines[:last+1], append([]string{line}, lines[last+1:]...)...)
+	}
+
+	return []byte(strings.Join(lines, "\n") + "\n"), nil
+}
+
+// brackets returns how many more '[' than ']' s contains outside of strings and
+// comments.
+func brackets(s string) int {
+	n := 0
+	var quote rune
+	for _, r := range s {
+		switch {
+		case quote != 0:
+			if r == quote {
+				quote = 0
+			}
+		case r == '"' || r == '\'':
+			quote = r
+		case r == '#':
+			return n
+		case r == '[':
+			n++
+		case r == ']':
+			n--
+		}
+	}
+
+	return n
+}
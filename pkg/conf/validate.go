@@ -0,0 +1,108 @@
+// SPDX-License-Identifier: BSD-2
+// Copyright 2023 VMware, Inc.
+
+package conf
+
+import (
+	"errors"
+	"path"
+	"regexp"
+	"time"
+
+	log "github.com/sirupsen/logrus"
+
+	"github.com/vmware-samples/photon-os-container-builder/pkg/errdefs"
+)
+
+var (
+	releaseRegexp   = regexp.MustCompile(`^[0-9]+\.[0-9]+$`)
+	cpuQuotaRegexp  = regexp.MustCompile(`^[0-9]+%$`)
+	memoryMaxRegexp = regexp.MustCompile(`^([0-9]+[KMGT]?|[0-9]+%|infinity)$`)
+	tasksMaxRegexp  = regexp.MustCompile(`^([0-9]+|[0-9]+%|infinity)$`)
+)
+
+// Validate reports every value of c that cntrctl cannot use.
+func (c *Config) Validate() error {
+	var errs []error
+	invalid := func(key string, value string, reason string) {
+		errs = append(errs, errdefs.Errorf(errdefs.ErrInvalid, "%s='%s': %s", key, value, reason))
+	}
+
+	if _, err := log.ParseLevel(c.System.LogLevel); err != nil {
+		invalid("System.LogLevel", c.System.LogLevel, "unknown log level")
+	}
+
+	switch c.System.LogFormat {
+	case "", "text", "json", "journal":
+	default:
+		invalid("System.LogFormat", c.System.LogFormat, "expected text, json or journal")
+	}
+
+	if !releaseRegexp.MatchString(c.System.Release) {
+		invalid("System.Release", c.System.Release, "expected a release like 5.0")
+	}
+
+	for _, d := range []struct {
+		key      string
+		dir      string
+		optional bool
+	}{
+		{"System.StorageDir", c.System.StorageDir, false},
+		{"System.UnitDir", c.System.UnitDir, false},
+		{"Repos.Dir", c.Repos.Dir, true},
+		{"Cache.Dir", c.Cache.Dir, false},
+		{"GPG.Dir", c.GPG.Dir, false},
+	} {
+		if !path.IsAbs(d.dir) && !(d.optional && d.dir == "") {
+			invalid(d.key, d.dir, "expected an absolute path")
+		}
+	}
+
+	if _, err := time.ParseDuration(c.System.LockTimeout); err != nil {
+		invalid("System.LockTimeout", c.System.LockTimeout, "expected a duration like 30s or 2m")
+	}
+
+	pools := map[string]bool{DefaultStoragePool: true}
+	for _, p := range c.Storage.Pools {
+		switch {
+		case p.Name == DefaultStoragePool:
+			invalid("Storage.Pools.Name", p.Name, "the default pool is System.StorageDir")
+		case pools[p.Name]:
+			invalid("Storage.Pools.Name", p.Name, "defined twice")
+		case p.Name == "":
+			invalid("Storage.Pools.Name", p.Name, "pool without name")
+		}
+		pools[p.Name] = true
+
+		if !path.IsAbs(p.Dir) {
+			invalid("Storage.Pools.Dir", p.Dir, "expected an absolute path")
+		}
+	}
+
+	switch c.Network.Type {
+	case "", "macvlan", "ipvlan":
+	default:
+		invalid("Network.Type", c.Network.Type, "expected macvlan or ipvlan")
+	}
+	if (c.Network.Type == "") != (c.Network.Link == "") {
+		invalid("Network.Link", c.Network.Link, "type and link have to be specified together")
+	}
+
+	switch c.Security.Profile {
+	case SecurityPrivileged, SecurityDefault, SecurityUserNS:
+	default:
+		invalid("Security.Profile", c.Security.Profile, "expected privileged, default or userns")
+	}
+
+	if c.Limits.CPUQuota != "" && !cpuQuotaRegexp.MatchString(c.Limits.CPUQuota) {
+		invalid("Limits.CPUQuota", c.Limits.CPUQuota, "expected a percentage like 200%")
+	}
+	if c.Limits.MemoryMax != "" && !memoryMaxRegexp.MatchString(c.Limits.MemoryMax) {
+		invalid("Limits.MemoryMax", c.Limits.MemoryMax, "expected bytes with optional K, M, G or T suffix, a percentage or infinity")
+	}
+	if c.Limits.TasksMax != "" && !tasksMaxRegexp.MatchString(c.Limits.TasksMax) {
+		invalid("Limits.TasksMax", c.Limits.TasksMax, "expected a number, a percentage or infinity")
+	}
+
+	return errors.Join(errs...)
+}
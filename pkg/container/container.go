@@ -4,73 +4,351 @@
 package container
 
 import (
-	"errors"
+	"context"
 	"fmt"
+	"io"
 	"os"
 	"path"
+	"strings"
 
 	"github.com/vmware-samples/photon-os-container-builder/pkg/conf"
+	"github.com/vmware-samples/photon-os-container-builder/pkg/errdefs"
+	"github.com/vmware-samples/photon-os-container-builder/pkg/lock"
+	"github.com/vmware-samples/photon-os-container-builder/pkg/logging"
 	"github.com/vmware-samples/photon-os-container-builder/pkg/nspawn"
 	"github.com/vmware-samples/photon-os-container-builder/pkg/rpm"
 	"github.com/vmware-samples/photon-os-container-builder/pkg/set"
+	"github.com/vmware-samples/photon-os-container-builder/pkg/slim"
 	"github.com/vmware-samples/photon-os-container-builder/pkg/system"
-	"github.com/vmware-samples/photon-os-container-builder/pkg/systemd"
+	"github.com/vmware-samples/photon-os-container-builder/pkg/txn"
 )
 
-func Spawn(base string, c string, release string, packages string, network string, link string, machine string, dir bool, ephemeral bool) error {
-	d := path.Join(base, c)
+var (
+	ErrNotExist   = errdefs.New(errdefs.ErrNotFound, "container does not exist")
+	ErrExist      = errdefs.New(errdefs.ErrExists, "container already exists")
+	ErrRunning    = errdefs.New(errdefs.ErrConflict, "container is running")
+	ErrNotRunning = errdefs.New(errdefs.ErrConflict, "container is not running")
+)
+
+// UnitOptions returns the unit options of container c with the configured limits,
+// storage directory and security profile.
+func UnitOptions(cfg *conf.Config, base string, c string) *system.UnitOptions {
+	return &system.UnitOptions{
+		Directory:  RootDir(base, c),
+		StorageDir: base,
+		Security:   cfg.Security.Profile,
+		CPUQuota:   cfg.Limits.CPUQuota,
+		MemoryMax:  cfg.Limits.MemoryMax,
+		TasksMax:   cfg.Limits.TasksMax,
+	}
+}
+
+func Spawn(cfg *conf.Config, base string, c string, release string, packages string, network string, link string, machine string, dir bool, ephemeral bool, lock string, slimProfiles []string, pool string) error {
+	o := SpawnOptions{
+		Release:   release,
+		Packages:  packages,
+		Network:   network,
+		Link:      link,
+		Machine:   machine,
+		Ephemeral: ephemeral,
+		Lock:      lock,
+		Slim:      slimProfiles,
+		Pool:      pool,
+		Unit:      UnitOptions(cfg, base, c),
+	}
 
-	if err := system.CreateDirectory(base, c); err != nil {
-		fmt.Printf("Failed to create container image dir: %+v\n", err)
-		return errors.New("dir exists")
+	if err := Create(context.Background(), cfg, os.Stdout, base, c, &o); err != nil {
+		return fmt.Errorf("failed to spawn container '%s': %w", c, err)
 	}
 
-	s := set.New()
-	s.AddAll(packages)
+	return nspawn.Spawn(RootDir(base, c), dir)
+}
 
-	if err := rpm.ConstructOSTree(release, d, s); err != nil {
-		defer system.RemoveDir(d)
+// Build creates the root directory of container c, installs packages or the exact set
+// of lockfile l into it and prunes it according to rules. The directory is removed
+// when any step fails.
+func Build(cfg *conf.Config, w io.Writer, base string, c string, release string, packages string, l *rpm.Lockfile, rules *slim.Rules) error {
+	if err := CheckName(c); err != nil {
+		return err
+	}
 
-		fmt.Printf("Failed to construct container root directory '%s': +%v\n", d, err)
+	lk, err := lock.Container(context.Background(), c, "build")
+	if err != nil {
 		return err
 	}
+	defer lk.Release()
 
-	if err := system.ExecAndDisplay(os.Stdout, "/usr/bin/systemd-machine-id-setup", "--root", d); err != nil {
-		fmt.Printf("Failed to execute systemd-machine-id-setup for '%s': %+v\n", c, err)
+	d := path.Join(base, c)
+	if system.PathExists(d) {
+		return fmt.Errorf("'%s': %w", c, ErrExist)
+	}
+
+	if err := build(context.Background(), nil, cfg, w, base, c, release, packages, l, rules); err != nil {
+		system.RemoveDir(d)
 		return err
 	}
 
-	// Host networking
-	if network == "" {
-		system.DisableNetworkd(d)
+	return nil
+}
+
+// build runs the steps of Build in journal j.
+func build(ctx context.Context, j *txn.Journal, cfg *conf.Config, w io.Writer, base string, c string, release string, packages string, l *rpm.Lockfile, rules *slim.Rules) error {
+	d := path.Join(base, c)
+
+	if l != nil {
+		release = l.Release
+	}
+	log := logging.Operation("build", c).WithField(logging.FieldRelease, release)
+
+	err := j.Step(ctx, stepRootfs, txn.Undo{Remove: []string{d}}, func() error {
+		return system.MkdirAll(d, 0755)
+	})
+	if err != nil {
+		return fmt.Errorf("failed to create container image dir: %w", err)
 	}
 
-	if err := systemd.SetupContainerService(c, network, link, machine, ephemeral); err != nil {
-		fmt.Printf("Failed to create unit file for '%s': %+v\n", c, err)
+	log.Debug("Building container root directory")
+
+	err = j.Step(ctx, stepInstall, txn.Undo{}, func() error {
+		if rules != nil {
+			if err := slim.WriteMacros(d, rules); err != nil {
+				return fmt.Errorf("failed to write rpm macros for '%s': %w", c, err)
+			}
+		}
+
+		if l != nil {
+			if err := rpm.ConstructOSTreeFromLock(cfg, w, d, l, rules.TDNFOptions()); err != nil {
+				return fmt.Errorf("failed to construct container root directory '%s' from lockfile: %w", d, err)
+			}
+		} else {
+			s := set.New()
+			s.AddAll(packages)
+
+			if err := rpm.ConstructOSTree(cfg, w, release, d, s, rules.TDNFOptions()); err != nil {
+				return fmt.Errorf("failed to construct container root directory '%s': %w", d, err)
+			}
+		}
+
+		// Keep the journal persistent so logs can be read after the container stopped
+		if err := system.MkdirAll(path.Join(d, "var/log/journal"), 0755); err != nil {
+			return fmt.Errorf("failed to create journal directory for '%s': %w", c, err)
+		}
+
+		return nil
+	})
+	if err != nil {
 		return err
 	}
 
-	return nspawn.Spawn(d, dir)
+	if rules != nil {
+		err = j.Step(ctx, stepSlim, txn.Undo{}, func() error {
+			return slimOSTree(w, d, rules)
+		})
+		if err != nil {
+			return fmt.Errorf("failed to slim container root directory '%s': %w", d, err)
+		}
+	}
+
+	err = j.Step(ctx, stepMachineID, txn.Undo{}, func() error {
+		return system.ExecAndDisplay(w, "/usr/bin/systemd-machine-id-setup", "--root", d)
+	})
+	if err != nil {
+		return fmt.Errorf("failed to execute systemd-machine-id-setup for '%s': %w", c, err)
+	}
+
+	log.Debug("Built container root directory")
+	return nil
 }
 
 func JumpStart(c *conf.Config, base string, container string, network string, link string, machine string, ephemeral bool) error {
-	dir := path.Join(base, container)
+	if err := CheckExisting(container); err != nil {
+		return err
+	}
+	if err := CheckMachine(machine); err != nil {
+		return err
+	}
+
+	dir := RootDir(base, container)
 
 	if !system.PathExists(dir) {
-		fmt.Printf("Container '%s' does not exist\n", container)
-		return errors.New("not exist")
+		return fmt.Errorf("'%s': %w", container, ErrNotExist)
+	}
+
+	l, err := lock.Container(context.Background(), container, "dir")
+	if err != nil {
+		return err
 	}
+	defer l.Release()
 
 	return nspawn.ThunderBolt(c, dir, network, link, machine, ephemeral)
 }
 
-func Boot(c *conf.Config, storage string, container string, network string, link string, machine string, ephemeral bool) error {
-	dir := path.Join(storage, container)
+func Boot(c *conf.Config, storage string, container string, network string, link string, machine string, ephemeral bool, keepUnit bool, binds []string, readOnly []string) error {
+	if err := CheckExisting(container); err != nil {
+		return err
+	}
+	if err := CheckMachine(machine); err != nil {
+		return err
+	}
+
+	dir := RootDir(storage, container)
+
+	if !system.PathExists(dir) {
+		return fmt.Errorf("'%s': %w", container, ErrNotExist)
+	}
+
+	l, err := lock.Container(context.Background(), container, "boot")
+	if err != nil {
+		return err
+	}
+	defer l.Release()
+
+	return nspawn.Boot(c, dir, network, link, machine, ephemeral, keepUnit, binds, readOnly)
+}
+
+func rootOf(base string, container string) (string, error) {
+	// A path is used as is, anything else names a container below base
+	dir := container
+	if !strings.Contains(container, "/") {
+		if err := CheckExisting(container); err != nil {
+			return "", err
+		}
+		dir = RootDir(base, container)
+	}
 
 	if !system.PathExists(dir) {
-		fmt.Printf("Container '%s' does not exist\n", container)
-		return errors.New("not exist")
+		return "", fmt.Errorf("'%s': %w", container, ErrNotExist)
+	}
+
+	return dir, nil
+}
+
+func Packages(base string, container string) ([]rpm.Package, error) {
+	dir, err := rootOf(base, container)
+	if err != nil {
+		return nil, err
+	}
+
+	pkgs, err := rpm.QueryPackages(dir)
+	if err != nil {
+		return nil, fmt.Errorf("failed to query RPM database of '%s': %w", container, err)
+	}
+
+	return pkgs, nil
+}
+
+func DiffPackages(base string, a string, b string) (*rpm.Diff, error) {
+	old, err := Packages(base, a)
+	if err != nil {
+		return nil, err
+	}
+
+	new, err := Packages(base, b)
+	if err != nil {
+		return nil, err
+	}
+
+	return rpm.DiffPackages(old, new), nil
+}
+
+func slimOSTree(w io.Writer, dir string, rules *slim.Rules) error {
+	before, err := system.DirSize(dir)
+	if err != nil {
+		return err
+	}
+
+	if err := slim.Apply(dir, rules); err != nil {
+		return err
+	}
+
+	after, err := system.DirSize(dir)
+	if err != nil {
+		return err
+	}
+
+	fmt.Fprintf(w, "Slimmed rootfs with profiles '%s': %s -> %s (freed %s)\n", strings.Join(rules.Profiles, ","),
+		system.FormatBytes(before), system.FormatBytes(after), system.FormatBytes(before-after))
+	return nil
+}
+
+func LockfilePath(container string) string {
+	return path.Join(conf.DefaultLockfileDir, container+".json")
+}
+
+func writeLockfile(w io.Writer, dir string, container string, release string) error {
+	if release == "" {
+		release = conf.DefaultReleaseVersion
+	}
+
+	pkgs, err := rpm.QueryPackages(dir)
+	if err != nil {
+		return err
+	}
+
+	f := LockfilePath(container)
+	if err := rpm.NewLockfile(release, pkgs).Save(f); err != nil {
+		return err
+	}
+
+	fmt.Fprintf(w, "Wrote lockfile '%s' with %d packages\n", f, len(pkgs))
+	return nil
+}
+
+func UpdateLock(cfg *conf.Config, w io.Writer, base string, container string, output string, update bool) error {
+	dir, err := rootOf(base, container)
+	if err != nil {
+		return err
+	}
+
+	lk, err := lock.Container(context.Background(), path.Base(dir), "update-lock")
+	if err != nil {
+		return err
+	}
+	defer lk.Release()
+
+	if output == "" {
+		output = LockfilePath(container)
+	}
+
+	release := conf.DefaultReleaseVersion
+	if old, err := rpm.LoadLockfile(LockfilePath(container)); err == nil {
+		release = old.Release
+	}
+
+	l, err := rpm.UpdateLockfile(cfg, w, release, dir, update)
+	if err != nil {
+		return fmt.Errorf("failed to refresh lockfile of '%s': %w", container, err)
+	}
+
+	if err := l.Save(output); err != nil {
+		return fmt.Errorf("failed to write lockfile '%s': %w", output, err)
+	}
+
+	fmt.Fprintf(w, "Wrote lockfile '%s' with %d packages\n", output, len(l.Packages))
+	return nil
+}
+
+func VerifySignatures(cfg *conf.Config, base string, container string, release string) ([]rpm.SignatureIssue, error) {
+	dir, err := rootOf(base, container)
+	if err != nil {
+		return nil, err
+	}
+
+	if release == "" {
+		if release, err = rpm.Release(dir); err != nil {
+			return nil, fmt.Errorf("failed to determine release of '%s': %w", container, err)
+		}
+	}
+
+	ids, err := rpm.ReleaseKeyIDs(cfg, release)
+	if err != nil {
+		return nil, fmt.Errorf("failed to determine GPG keys of release '%s': %w", release, err)
+	}
+
+	issues, err := rpm.VerifySignatures(dir, ids)
+	if err != nil {
+		return nil, fmt.Errorf("failed to query signatures of '%s': %w", container, err)
 	}
 
-	return nspawn.Boot(c, dir, network, link, machine, ephemeral)
+	return issues, nil
 }
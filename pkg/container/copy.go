@@ -0,0 +1,122 @@
+// SPDX-License-Identifier: BSD-2
+// Copyright 2023 VMware, Inc.
+
+package container
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"path"
+	"path/filepath"
+	"strconv"
+	"strings"
+
+	"github.com/vmware-samples/photon-os-container-builder/pkg/lock"
+	"github.com/vmware-samples/photon-os-container-builder/pkg/system"
+)
+
+// ParseCopyPath splits NAME:/path into container and path. Host paths return an
+// empty container name.
+func ParseCopyPath(arg string) (string, string) {
+	name, p, ok := strings.Cut(arg, ":")
+	if !ok || name == "" || strings.Contains(name, "/") {
+		return "", arg
+	}
+
+	return name, p
+}
+
+// fsRoot returns the root through which the container's files are reached. Running
+// containers are accessed through the root of their leader so files on tmpfs and
+// other mounts inside the container are visible.
+func fsRoot(base string, container string) (string, error) {
+	if m := machineInfo(system.MachineName(container)); m != nil {
+		return path.Join("/proc", strconv.Itoa(m.Leader), "root"), nil
+	}
+
+	return rootOf(base, container)
+}
+
+func copyDestination(root string, dst string, src string) (string, error) {
+	d, err := system.SecureJoin(root, dst)
+	if err != nil {
+		return "", err
+	}
+
+	if info, err := os.Stat(d); err == nil && info.IsDir() {
+		return path.Join(dst, filepath.Base(src)), nil
+	}
+
+	return dst, nil
+}
+
+func Copy(base string, src string, dst string) error {
+	srcContainer, srcPath := ParseCopyPath(src)
+	dstContainer, dstPath := ParseCopyPath(dst)
+
+	if (srcContainer == "") == (dstContainer == "") {
+		return errors.New("exactly one of source and destination must be a container path NAME:/path")
+	}
+
+	if dstContainer != "" {
+		l, err := lock.Container(context.Background(), dstContainer, "cp")
+		if err != nil {
+			return err
+		}
+		defer l.Release()
+	}
+
+	srcRoot, dstRoot := "/", "/"
+	if srcContainer != "" {
+		r, err := fsRoot(base, srcContainer)
+		if err != nil {
+			return err
+		}
+		srcRoot = r
+	} else {
+		p, err := filepath.Abs(srcPath)
+		if err != nil {
+			return err
+		}
+		srcPath = p
+	}
+
+	if dstContainer != "" {
+		r, err := fsRoot(base, dstContainer)
+		if err != nil {
+			return err
+		}
+		dstRoot = r
+	} else {
+		p, err := filepath.Abs(dstPath)
+		if err != nil {
+			return err
+		}
+		dstPath = p
+	}
+
+	s, err := system.SecureJoin(srcRoot, srcPath)
+	if err != nil {
+		return fmt.Errorf("failed to resolve '%s': %w", src, err)
+	}
+
+	if !system.PathExists(s) {
+		return fmt.Errorf("source '%s': %w", src, os.ErrNotExist)
+	}
+
+	d, err := copyDestination(dstRoot, dstPath, s)
+	if err != nil {
+		return fmt.Errorf("failed to resolve '%s': %w", dst, err)
+	}
+
+	err = system.Do(system.CommandLine("cp", "-a", s, d), func() error {
+		return system.CopyTree(s, dstRoot, d)
+	})
+	if err != nil {
+		return fmt.Errorf("failed to copy '%s' to '%s': %w", src, dst, err)
+	}
+
+	return nil
+}
@@ -0,0 +1,43 @@
+// SPDX-License-Identifier: BSD-2
+// Copyright 2023 VMware, Inc.
+
+package container
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"os/exec"
+
+	"github.com/vmware-samples/photon-os-container-builder/pkg/system"
+)
+
+const (
+	systemdRun = "/usr/bin/systemd-run"
+)
+
+// Exec runs args inside the running container as a transient unit of its service
+// manager and returns the exit status of the command.
+func Exec(ctx context.Context, container string, args []string, stdout io.Writer, stderr io.Writer) (int, error) {
+	if !Running(container) {
+		return -1, fmt.Errorf("'%s': %w", container, ErrNotRunning)
+	}
+
+	argv := append([]string{systemdRun, "-M", system.MachineName(container), "--quiet", "--wait", "--pipe", "--collect", "--"}, args...)
+	cmd := exec.CommandContext(ctx, argv[0], argv[1:]...)
+	cmd.Stdout = stdout
+	cmd.Stderr = stderr
+
+	err := system.Do(system.CommandLine(argv...), cmd.Run)
+
+	var exitErr *exec.ExitError
+	if errors.As(err, &exitErr) {
+		return exitErr.ExitCode(), nil
+	}
+	if err != nil {
+		return -1, err
+	}
+
+	return 0, nil
+}
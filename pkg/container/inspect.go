@@ -0,0 +1,103 @@
+// SPDX-License-Identifier: BSD-2
+// Copyright 2023 VMware, Inc.
+
+package container
+
+import (
+	"path"
+	"strconv"
+	"time"
+
+	"github.com/vmware-samples/photon-os-container-builder/pkg/system"
+	"github.com/vmware-samples/photon-os-container-builder/pkg/systemd"
+)
+
+const (
+	machinesDir = "/run/systemd/machines"
+)
+
+type MachineInfo struct {
+	Name   string    `json:"name"`
+	Unit   string    `json:"unit,omitempty"`
+	Leader int       `json:"leader"`
+	Since  time.Time `json:"since"`
+	Uptime string    `json:"uptime"`
+}
+
+type Info struct {
+	Name        string              `json:"name"`
+	Path        string              `json:"path"`
+	Unit        *system.UnitConfig  `json:"unit,omitempty"`
+	NetworkFile string              `json:"network_file,omitempty"`
+	Service     *systemd.UnitStatus `json:"service,omitempty"`
+	Machine     *MachineInfo        `json:"machine,omitempty"`
+	Resources   *system.CgroupStats `json:"resources,omitempty"`
+	Interfaces  []system.Interface  `json:"interfaces,omitempty"`
+	Mounts      []system.Mount      `json:"mounts,omitempty"`
+}
+
+func machineInfo(machine string) *MachineInfo {
+	m, err := system.ParseMachine(path.Join(machinesDir, machine))
+	if err != nil {
+		return nil
+	}
+
+	leader, err := strconv.Atoi(m["LEADER"])
+	if err != nil {
+		return nil
+	}
+
+	i := MachineInfo{
+		Name:   m["NAME"],
+		Unit:   m["UNIT"],
+		Leader: leader,
+	}
+
+	if usec, err := strconv.ParseInt(m["TIMESTAMP"], 10, 64); err == nil {
+		i.Since = time.UnixMicro(usec)
+		i.Uptime = time.Since(i.Since).Round(time.Second).String()
+	}
+
+	return &i
+}
+
+// Inspect gathers the on-disk configuration of a container together with the live
+// state of its service, machine registration, cgroup, network and mounts.
+func Inspect(base string, container string) (*Info, error) {
+	dir, err := rootOf(base, container)
+	if err != nil {
+		return nil, err
+	}
+
+	i := Info{
+		Name: container,
+		Path: dir,
+	}
+
+	if u, err := system.LoadUnitFile(container); err == nil {
+		i.Unit = u
+	}
+
+	if f := system.NetworkUnitFilePath(dir, container); system.PathExists(f) {
+		i.NetworkFile = f
+	}
+
+	u := systemd.Unit{Name: container}
+	if s, err := u.Status(); err == nil {
+		i.Service = s
+	}
+
+	// The payload runs in the unit the machine registered, the service when booted by
+	// it or a scope of its own otherwise
+	i.Machine = machineInfo(system.MachineName(container))
+	if i.Machine != nil {
+		if cg, err := system.UnitCgroup(i.Machine.Leader, i.Machine.Unit); err == nil {
+			i.Resources, _ = system.ReadCgroupStats(cg)
+		}
+
+		i.Interfaces, _ = system.NetworkInterfaces(i.Machine.Leader)
+		i.Mounts, _ = system.BindMounts(i.Machine.Leader)
+	}
+
+	return &i, nil
+}
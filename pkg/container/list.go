@@ -0,0 +1,80 @@
+// SPDX-License-Identifier: BSD-2
+// Copyright 2023 VMware, Inc.
+
+package container
+
+import (
+	"fmt"
+	"os"
+	"path"
+	"sort"
+
+	"github.com/vmware-samples/photon-os-container-builder/pkg/conf"
+	"github.com/vmware-samples/photon-os-container-builder/pkg/system"
+	"github.com/vmware-samples/photon-os-container-builder/pkg/systemd"
+)
+
+type Summary struct {
+	Name    string `json:"name"`
+	Path    string `json:"path"`
+	Machine string `json:"machine"`
+	Pool    string `json:"pool"`
+	Running bool   `json:"running"`
+	State   string `json:"state,omitempty"`
+	Quota   *Quota `json:"quota,omitempty"`
+	// Error tells why the container is broken, such as a link into a storage pool
+	// whose directory is gone. remove deletes what is left of it.
+	Error string `json:"error,omitempty"`
+}
+
+// Running reports whether the machine of a container is registered with machined,
+// independent of how it was started.
+func Running(container string) bool {
+	return system.PathExists(path.Join(machinesDir, system.MachineName(container)))
+}
+
+// List returns the containers below base, including those linked from other storage
+// pools, together with the state of their service units.
+func List(cfg *conf.Config, base string) ([]Summary, error) {
+	r := []Summary{}
+
+	entries, err := os.ReadDir(base)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return r, nil
+		}
+		return nil, err
+	}
+
+	for _, e := range entries {
+		if e.Name()[0] == '.' || !e.IsDir() && e.Type()&os.ModeSymlink == 0 {
+			continue
+		}
+
+		s := Summary{
+			Name:    e.Name(),
+			Path:    RootDir(base, e.Name()),
+			Machine: system.MachineName(e.Name()),
+			Pool:    PoolOf(cfg, base, e.Name()),
+			Running: Running(e.Name()),
+		}
+		if !system.PathExists(s.Path) {
+			target, _ := os.Readlink(s.Path)
+			s.Error = fmt.Sprintf("linked root directory '%s' does not exist", target)
+		} else {
+			s.Quota, _ = GetQuota(base, e.Name())
+		}
+
+		if system.PathExists(system.UnitFilePath(e.Name())) {
+			u := systemd.Unit{Name: e.Name()}
+			if st, err := u.Status(); err == nil {
+				s.State = st.ActiveState
+			}
+		}
+
+		r = append(r, s)
+	}
+
+	sort.Slice(r, func(i, j int) bool { return r[i].Name < r[j].Name })
+	return r, nil
+}
@@ -0,0 +1,104 @@
+// SPDX-License-Identifier: BSD-2
+// Copyright 2023 VMware, Inc.
+
+package container
+
+import (
+	"fmt"
+	"path"
+
+	"github.com/vmware-samples/photon-os-container-builder/pkg/system"
+)
+
+const (
+	journalctl = "/usr/bin/journalctl"
+)
+
+type LogOptions struct {
+	Follow  bool
+	Since   string
+	Unit    string
+	Boot    bool
+	Lines   int
+	Output  string
+	Service bool
+}
+
+// journalArgs selects the journal to read: the host side journal of the service unit,
+// the live journal of a running machine or the persistent journal in the rootfs.
+func journalArgs(dir string, container string, o *LogOptions) []string {
+	args := []string{journalctl}
+
+	machine := system.MachineName(container)
+	switch {
+	case o.Service:
+		args = append(args, "-u", container+".service")
+	case system.PathExists(path.Join(machinesDir, machine)):
+		args = append(args, "-M", machine)
+	default:
+		args = append(args, "--root", dir)
+	}
+
+	if o.Unit != "" && !o.Service {
+		args = append(args, "-u", o.Unit)
+	}
+
+	return appendJournalOptions(args, o)
+}
+
+func appendJournalOptions(args []string, o *LogOptions) []string {
+	if o.Boot {
+		args = append(args, "-b")
+	}
+	if o.Since != "" {
+		args = append(args, "--since", o.Since)
+	}
+	if o.Lines > 0 {
+		args = append(args, "-n", fmt.Sprint(o.Lines))
+	}
+	if o.Output != "" {
+		args = append(args, "-o", o.Output)
+	}
+	if o.Follow {
+		args = append(args, "-f")
+	}
+
+	return append(args, "--no-pager")
+}
+
+// JournalArgs returns the journalctl command line reading the journal of a container.
+func JournalArgs(base string, container string, o *LogOptions) ([]string, error) {
+	dir, err := rootOf(base, container)
+	if err != nil {
+		return nil, err
+	}
+
+	return journalArgs(dir, container, o), nil
+}
+
+func Logs(base string, container string, o *LogOptions) error {
+	args, err := JournalArgs(base, container, o)
+	if err != nil {
+		return fmt.Errorf("failed to read journal: %w", err)
+	}
+
+	if err := system.ExecAndRenounce(args...); err != nil {
+		return fmt.Errorf("failed to read journal of '%s': %w", container, err)
+	}
+
+	return nil
+}
+
+// GroupLogs interleaves the host side journals of the service units of containers.
+func GroupLogs(containers []string, o *LogOptions) error {
+	args := []string{journalctl}
+	for _, c := range containers {
+		args = append(args, "-u", c+".service")
+	}
+
+	if err := system.ExecAndRenounce(appendJournalOptions(args, o)...); err != nil {
+		return fmt.Errorf("failed to read journal: %w", err)
+	}
+
+	return nil
+}
@@ -0,0 +1,117 @@
+// SPDX-License-Identifier: BSD-2
+// Copyright 2023 VMware, Inc.
+
+package container
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/vmware-samples/photon-os-container-builder/pkg/errdefs"
+)
+
+// MaxNameLength is the longest machine name systemd-machined accepts, HOST_NAME_MAX.
+const MaxNameLength = 64
+
+// CheckName validates a container name. It names the root directory below the storage
+// directory, the service unit and, unless -m is given, the machine registered with
+// systemd-machined, so it has to be a valid hostname.
+func CheckName(name string) error {
+	return checkHostname("container", name)
+}
+
+// CheckExisting validates the name of an existing container. Containers created
+// before names had to be hostnames are accepted, only names that would leave the
+// storage directory are refused.
+func CheckExisting(name string) error {
+	if name == "" || strings.Contains(name, "/") || strings.HasPrefix(name, ".") {
+		return errdefs.Errorf(errdefs.ErrInvalid, "invalid container name '%s': must not be empty, contain '/' or start with '.'", name)
+	}
+
+	return nil
+}
+
+// CheckMachine validates a machine name given with -m. Empty means the container name.
+func CheckMachine(machine string) error {
+	if machine == "" {
+		return nil
+	}
+
+	return checkHostname("machine", machine)
+}
+
+func checkHostname(what string, name string) error {
+	reason := hostnameProblem(name)
+	if reason == "" {
+		return nil
+	}
+
+	if s := SanitizeName(name); s != "" {
+		return errdefs.Errorf(errdefs.ErrInvalid, "invalid %s name '%s': %s, try '%s'", what, name, reason, s)
+	}
+
+	return errdefs.Errorf(errdefs.ErrInvalid, "invalid %s name '%s': %s", what, name, reason)
+}
+
+// hostnameProblem describes why name is not a hostname as accepted by machined:
+// dot separated labels of ASCII letters, digits and '-', which do not start or end
+// with '-'.
+func hostnameProblem(name string) string {
+	switch {
+	case name == "":
+		return "name is empty"
+	case len(name) > MaxNameLength:
+		return fmt.Sprintf("longer than %d characters", MaxNameLength)
+	}
+
+	for _, r := range name {
+		if !validRune(r) && r != '.' {
+			return fmt.Sprintf("character %q is not allowed, use letters, digits, '-' and '.'", r)
+		}
+	}
+
+	for _, l := range strings.Split(name, ".") {
+		switch {
+		case l == "":
+			return "'.' may only separate labels"
+		case l[0] == '-' || l[len(l)-1] == '-':
+			return "labels may not start or end with '-'"
+		}
+	}
+
+	return ""
+}
+
+func validRune(r rune) bool {
+	return r >= 'a' && r <= 'z' || r >= 'A' && r <= 'Z' || r >= '0' && r <= '9' || r == '-'
+}
+
+// SanitizeName turns name into a valid container name: other characters become '-',
+// empty labels are dropped and the result is cut to MaxNameLength. It returns the
+// empty string when nothing usable is left.
+func SanitizeName(name string) string {
+	var labels []string
+	for _, l := range strings.Split(name, ".") {
+		l = strings.Map(func(r rune) rune {
+			if validRune(r) {
+				return r
+			}
+			return '-'
+		}, l)
+
+		for strings.Contains(l, "--") {
+			l = strings.ReplaceAll(l, "--", "-")
+		}
+
+		if l = strings.Trim(l, "-"); l != "" {
+			labels = append(labels, l)
+		}
+	}
+
+	s := strings.Join(labels, ".")
+	if len(s) > MaxNameLength {
+		s = strings.TrimRight(s[:MaxNameLength], "-.")
+	}
+
+	return s
+}
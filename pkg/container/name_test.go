@@ -0,0 +1,97 @@
+// SPDX-License-Identifier: BSD-2
+// Copyright 2023 VMware, Inc.
+
+package container
+
+import (
+	"errors"
+	"strings"
+	"testing"
+
+	"github.com/vmware-samples/photon-os-container-builder/pkg/errdefs"
+)
+
+func TestCheckName(t *testing.T) {
+	tests := []struct {
+		name string
+		ok   bool
+	}{
+		{"web", true},
+		{"web-1", true},
+		{"Photon5", true},
+		{"db.prod", true},
+		{strings.Repeat("a", MaxNameLength), true},
+		{"", false},
+		{strings.Repeat("a", MaxNameLength+1), false},
+		{"web_1", false},
+		{"web 1", false},
+		{"../etc", false},
+		{"a/b", false},
+		{".hidden", false},
+		{"web.", false},
+		{"a..b", false},
+		{"-web", false},
+		{"web-", false},
+		{"db.-prod", false},
+		{"wéb", false},
+	}
+
+	for _, tt := range tests {
+		err := CheckName(tt.name)
+		if (err == nil) != tt.ok {
+			t.Errorf("CheckName(%q) = %v, want ok %v", tt.name, err, tt.ok)
+		}
+		if err != nil && !errors.Is(err, errdefs.ErrInvalid) {
+			t.Errorf("CheckName(%q) = %v, want kind invalid", tt.name, err)
+		}
+	}
+}
+
+func TestCheckExisting(t *testing.T) {
+	tests := []struct {
+		name string
+		ok   bool
+	}{
+		{"web", true},
+		{"web_1", true},
+		{"Old Name", true},
+		{"", false},
+		{".", false},
+		{"..", false},
+		{".hidden", false},
+		{"../x", false},
+		{"a/b", false},
+		{"/", false},
+	}
+
+	for _, tt := range tests {
+		if err := CheckExisting(tt.name); (err == nil) != tt.ok {
+			t.Errorf("CheckExisting(%q) = %v, want ok %v", tt.name, err, tt.ok)
+		}
+	}
+}
+
+func TestSanitizeName(t *testing.T) {
+	tests := []struct {
+		in   string
+		want string
+	}{
+		{"web", "web"},
+		{"web_1", "web-1"},
+		{"my  container", "my-container"},
+		{"-web-", "web"},
+		{"a..b", "a.b"},
+		{"___", ""},
+		{strings.Repeat("ab", MaxNameLength), strings.Repeat("ab", MaxNameLength/2)},
+	}
+
+	for _, tt := range tests {
+		got := SanitizeName(tt.in)
+		if got != tt.want {
+			t.Errorf("SanitizeName(%q) = %q, want %q", tt.in, got, tt.want)
+		}
+		if got != "" && CheckName(got) != nil {
+			t.Errorf("SanitizeName(%q) = %q is not a valid name", tt.in, got)
+		}
+	}
+}
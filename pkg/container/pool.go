@@ -0,0 +1,297 @@
+// SPDX-License-Identifier: BSD-2
+// Copyright 2023 VMware, Inc.
+
+package container
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path"
+	"path/filepath"
+	"syscall"
+
+	"github.com/vmware-samples/photon-os-container-builder/pkg/conf"
+	"github.com/vmware-samples/photon-os-container-builder/pkg/errdefs"
+	"github.com/vmware-samples/photon-os-container-builder/pkg/lock"
+	"github.com/vmware-samples/photon-os-container-builder/pkg/rpm"
+	"github.com/vmware-samples/photon-os-container-builder/pkg/system"
+	"github.com/vmware-samples/photon-os-container-builder/pkg/systemd"
+)
+
+// PoolDir returns the directory of storage pool name. The default pool, also selected
+// by the empty name, is base.
+func PoolDir(cfg *conf.Config, base string, name string) (string, error) {
+	if name == "" || name == conf.DefaultStoragePool {
+		return base, nil
+	}
+
+	p, ok := cfg.StoragePool(name)
+	if !ok {
+		return "", errdefs.Errorf(errdefs.ErrNotFound, "storage pool '%s' is not configured", name)
+	}
+
+	return p.Dir, nil
+}
+
+// RootDir returns the root directory of container c. Containers of pools other than
+// the default are symlinks in base, so machined discovers them.
+func RootDir(base string, c string) string {
+	d := path.Join(base, c)
+	if r, err := filepath.EvalSymlinks(d); err == nil {
+		return r
+	}
+
+	return d
+}
+
+// PoolOf returns the name of the storage pool container c is in.
+func PoolOf(cfg *conf.Config, base string, c string) string {
+	parent := path.Dir(RootDir(base, c))
+
+	for _, p := range cfg.StoragePools() {
+		dir := p.Dir
+		if p.Name == conf.DefaultStoragePool {
+			dir = base
+		}
+		if r, err := filepath.EvalSymlinks(dir); err == nil {
+			dir = r
+		}
+
+		if dir == parent {
+			return p.Name
+		}
+	}
+
+	return ""
+}
+
+// lexists reports whether p exists, without following a final symlink.
+func lexists(p string) bool {
+	_, err := os.Lstat(p)
+	return err == nil
+}
+
+// linked reports whether container c is a symlink into another pool.
+func linked(base string, c string) bool {
+	fi, err := os.Lstat(path.Join(base, c))
+	return err == nil && fi.Mode()&os.ModeSymlink != 0
+}
+
+func symlink(target string, link string) error {
+	return system.Do(system.CommandLine("ln", "-s", target, link), func() error {
+		return os.Symlink(target, link)
+	})
+}
+
+func rename(src string, dst string) error {
+	return system.Do(system.CommandLine("mv", src, dst), func() error {
+		return os.Rename(src, dst)
+	})
+}
+
+// transfer renames src to dst, or copies it with reflinks where the file system
+// supports them when dst is on another file system.
+func transfer(w io.Writer, src string, dst string) error {
+	err := rename(src, dst)
+	if !errors.Is(err, syscall.EXDEV) {
+		return err
+	}
+
+	return copyRoot(w, src, dst)
+}
+
+// copyRoot copies the rootfs src to dst, sharing extents through reflinks where the
+// file system supports them.
+func copyRoot(w io.Writer, src string, dst string) error {
+	if err := system.ExecAndDisplay(w, "cp", "-a", "--reflink=auto", src, dst); err != nil {
+		system.RemoveAll(dst)
+		return err
+	}
+
+	return nil
+}
+
+// Move migrates the stopped container c to storage pool name. Its service unit is
+// updated to the new root directory.
+func Move(ctx context.Context, cfg *conf.Config, w io.Writer, base string, c string, pool string) error {
+	if err := CheckExisting(c); err != nil {
+		return err
+	}
+
+	if pool == "" {
+		pool = conf.DefaultStoragePool
+	}
+
+	to, err := PoolDir(cfg, base, pool)
+	if err != nil {
+		return err
+	}
+
+	l, err := lock.Container(ctx, c, "move")
+	if err != nil {
+		return err
+	}
+	defer l.Release()
+
+	link := path.Join(base, c)
+	src := RootDir(base, c)
+	dst := path.Join(to, c)
+
+	if !system.PathExists(src) {
+		return fmt.Errorf("'%s': %w", c, ErrNotExist)
+	}
+	if Running(c) {
+		return fmt.Errorf("'%s': %w", c, ErrRunning)
+	}
+	if system.PathExists(QuotaImage(src)) {
+		return errdefs.Errorf(errdefs.ErrConflict, "'%s' has a loopback quota, which cannot be moved", c)
+	}
+	if PoolOf(cfg, base, c) == pool {
+		return errdefs.Errorf(errdefs.ErrInvalid, "'%s' is already in pool '%s'", c, pool)
+	}
+	if dst != link && lexists(dst) {
+		return errdefs.Errorf(errdefs.ErrExists, "'%s' already exists in pool '%s'", dst, pool)
+	}
+
+	if err := system.MkdirAll(to, 0755); err != nil {
+		return err
+	}
+
+	// Moving into the default pool replaces the link, so the rootfs is staged next to it
+	stage := dst
+	if dst == link {
+		stage = path.Join(base, "."+c+".move")
+	}
+
+	if err := rpm.UnmountCache(src); err != nil {
+		return err
+	}
+
+	fmt.Fprintf(w, "Moving '%s' to '%s'\n", src, dst)
+	if err := transfer(w, src, stage); err != nil {
+		return fmt.Errorf("failed to move '%s' to '%s': %w", src, dst, err)
+	}
+
+	if linked(base, c) {
+		if err := system.Remove(link); err != nil {
+			return err
+		}
+	}
+	if err := system.RemoveAll(src); err != nil {
+		return fmt.Errorf("failed to remove '%s': %w", src, err)
+	}
+
+	if stage != dst {
+		err = rename(stage, dst)
+	} else {
+		err = symlink(dst, link)
+	}
+	if err != nil {
+		return err
+	}
+
+	if system.PathExists(system.UnitFilePath(c)) {
+		if err := system.SetUnitDirectory(c, dst); err != nil {
+			return fmt.Errorf("failed to update unit of '%s': %w", c, err)
+		}
+
+		return systemd.Reload()
+	}
+
+	return nil
+}
+
+// Clone copies the stopped container c to a new container name in storage pool
+// pool, by default the pool of c. The clone gets its own machine ID and the lockfile
+// of c, but no service unit.
+func Clone(ctx context.Context, cfg *conf.Config, w io.Writer, base string, c string, name string, pool string) error {
+	if err := CheckExisting(c); err != nil {
+		return err
+	}
+	if err := CheckName(name); err != nil {
+		return err
+	}
+	if name == c {
+		return fmt.Errorf("'%s': %w", name, ErrExist)
+	}
+
+	if pool == "" {
+		pool = PoolOf(cfg, base, c)
+	}
+
+	to, err := PoolDir(cfg, base, pool)
+	if err != nil {
+		return err
+	}
+
+	l, err := lock.Container(ctx, c, "clone")
+	if err != nil {
+		return err
+	}
+	defer l.Release()
+
+	n, err := lock.Container(ctx, name, "clone")
+	if err != nil {
+		return err
+	}
+	defer n.Release()
+
+	link := path.Join(base, name)
+	src := RootDir(base, c)
+	dst := path.Join(to, name)
+
+	if !system.PathExists(src) {
+		return fmt.Errorf("'%s': %w", c, ErrNotExist)
+	}
+	if Running(c) {
+		return fmt.Errorf("'%s': %w", c, ErrRunning)
+	}
+	if lexists(link) || lexists(dst) || system.PathExists(system.UnitFilePath(name)) {
+		return fmt.Errorf("'%s': %w", name, ErrExist)
+	}
+
+	if err := system.MkdirAll(to, 0755); err != nil {
+		return err
+	}
+
+	if err := rpm.UnmountCache(src); err != nil {
+		return err
+	}
+
+	fmt.Fprintf(w, "Cloning '%s' to '%s'\n", src, dst)
+	if err := copyRoot(w, src, dst); err != nil {
+		return fmt.Errorf("failed to copy '%s' to '%s': %w", src, dst, err)
+	}
+
+	err = func() error {
+		// A machine ID is unique to each container
+		if err := system.Remove(path.Join(dst, "etc/machine-id")); err != nil && !os.IsNotExist(err) {
+			return err
+		}
+		if err := system.ExecAndDisplay(w, "/usr/bin/systemd-machine-id-setup", "--root", dst); err != nil {
+			return fmt.Errorf("failed to execute systemd-machine-id-setup for '%s': %w", name, err)
+		}
+
+		if b, err := os.ReadFile(LockfilePath(c)); err == nil {
+			if err := system.WriteFile(LockfilePath(name), b, 0644); err != nil {
+				return fmt.Errorf("failed to copy lockfile of '%s': %w", c, err)
+			}
+		}
+
+		if dst != link {
+			return symlink(dst, link)
+		}
+
+		return nil
+	}()
+	if err != nil {
+		system.Remove(LockfilePath(name))
+		system.RemoveAll(dst)
+		return err
+	}
+
+	return nil
+}
@@ -0,0 +1,300 @@
+// SPDX-License-Identifier: BSD-2
+// Copyright 2023 VMware, Inc.
+
+package container
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"os"
+	"path"
+	"strconv"
+	"strings"
+
+	"golang.org/x/sys/unix"
+
+	"github.com/vmware-samples/photon-os-container-builder/pkg/errdefs"
+	"github.com/vmware-samples/photon-os-container-builder/pkg/lock"
+	"github.com/vmware-samples/photon-os-container-builder/pkg/rpm"
+	"github.com/vmware-samples/photon-os-container-builder/pkg/system"
+	"github.com/vmware-samples/photon-os-container-builder/pkg/systemd"
+)
+
+// Ways a quota is enforced.
+const (
+	// QuotaBtrfs limits the qgroup of the rootfs, which is a btrfs subvolume.
+	QuotaBtrfs = "btrfs"
+	// QuotaLoop mounts a fixed size ext4 image on the rootfs.
+	QuotaLoop = "loop"
+)
+
+// QuotaWarnPercent is the usage of its quota from which list warns about a container.
+const QuotaWarnPercent = 90
+
+type Quota struct {
+	Method string `json:"method"`
+	Limit  int64  `json:"limit"`
+	Used   int64  `json:"used"`
+}
+
+// Percent returns the part of the quota in use.
+func (q *Quota) Percent() int {
+	if q.Limit == 0 {
+		return 0
+	}
+
+	return int(q.Used * 100 / q.Limit)
+}
+
+func (q *Quota) String() string {
+	return fmt.Sprintf("%s/%s", system.FormatBytes(q.Used), system.FormatBytes(q.Limit))
+}
+
+// QuotaImage returns the path of the image backing a loopback quota of the container
+// rooted at dir.
+func QuotaImage(dir string) string {
+	return path.Join(path.Dir(dir), "."+path.Base(dir)+".img")
+}
+
+// mounted reports whether a file system is mounted on dir.
+func mounted(dir string) bool {
+	var d, p unix.Stat_t
+	if unix.Stat(dir, &d) != nil || unix.Stat(path.Dir(dir), &p) != nil {
+		return false
+	}
+
+	return d.Dev != p.Dev
+}
+
+// GetQuota returns the quota of container c, nil when it has none.
+func GetQuota(base string, c string) (*Quota, error) {
+	dir := RootDir(base, c)
+
+	if info, err := os.Stat(QuotaImage(dir)); err == nil {
+		q := Quota{Method: QuotaLoop, Limit: info.Size()}
+		if mounted(dir) {
+			f, err := system.StatFilesystem(dir)
+			if err != nil {
+				return nil, err
+			}
+			q.Used = int64(f.Total - f.Free)
+		}
+
+		return &q, nil
+	}
+
+	if !system.IsBtrfs(dir) {
+		return nil, nil
+	}
+
+	// qgroupid rfer excl max_rfer, the level 0 qgroup is the subvolume's own
+	out, err := system.ExecAndCapture("btrfs", "qgroup", "show", "-r", "--raw", "-f", dir)
+	if err != nil {
+		// Quotas are not enabled on the file system
+		return nil, nil
+	}
+
+	for _, line := range strings.Split(out, "\n") {
+		f := strings.Fields(line)
+		if len(f) < 4 || !strings.HasPrefix(f[0], "0/") {
+			continue
+		}
+
+		limit, err := strconv.ParseInt(f[3], 10, 64)
+		if err != nil {
+			return nil, nil
+		}
+		used, _ := strconv.ParseInt(f[1], 10, 64)
+
+		return &Quota{Method: QuotaBtrfs, Limit: limit, Used: used}, nil
+	}
+
+	return nil, nil
+}
+
+// SetQuota limits the disk space of container c to size. On btrfs the rootfs is made a
+// subvolume when it is none and its qgroup is limited. Elsewhere the rootfs is moved
+// into an ext4 image of size mounted on it, which requires the container to be
+// stopped. Loopback quotas can only grow.
+func SetQuota(ctx context.Context, w io.Writer, base string, c string, size int64) error {
+	if err := CheckExisting(c); err != nil {
+		return err
+	}
+
+	l, err := lock.Container(ctx, c, "quota")
+	if err != nil {
+		return err
+	}
+	defer l.Release()
+
+	return setQuota(w, base, c, size)
+}
+
+func setQuota(w io.Writer, base string, c string, size int64) error {
+	dir := RootDir(base, c)
+	if !system.PathExists(dir) {
+		return fmt.Errorf("'%s': %w", c, ErrNotExist)
+	}
+
+	img := QuotaImage(dir)
+	if system.PathExists(img) {
+		return growImage(w, dir, img, size)
+	}
+
+	// Moving the rootfs into a subvolume or an image must not happen under a running
+	// container, which need not hold the lock
+	btrfs := system.IsBtrfs(dir)
+	if (!btrfs || !subvolume(dir)) && Running(c) {
+		return fmt.Errorf("'%s': %w", c, ErrRunning)
+	}
+
+	// The rootfs is copied and removed, which must not reach into a package cache an
+	// interrupted install left mounted
+	if err := rpm.UnmountCache(dir); err != nil {
+		return err
+	}
+
+	if btrfs {
+		return limitQgroup(w, dir, size)
+	}
+
+	return createImage(w, c, dir, img, size)
+}
+
+// subvolume reports whether dir is a btrfs subvolume.
+func subvolume(dir string) bool {
+	_, err := system.ExecAndCapture("btrfs", "subvolume", "show", dir)
+	return err == nil
+}
+
+func limitQgroup(w io.Writer, dir string, size int64) error {
+	if !subvolume(dir) {
+		// Reflinks make the copy into a new subvolume cheap
+		sub := path.Join(path.Dir(dir), ".#"+path.Base(dir)+".subvol")
+		if err := system.ExecAndDisplay(w, "btrfs", "subvolume", "create", sub); err != nil {
+			return err
+		}
+		if err := system.ExecAndDisplay(w, "cp", "-a", "--reflink=always", dir+"/.", sub); err != nil {
+			system.ExecAndDisplay(w, "btrfs", "subvolume", "delete", sub)
+			return err
+		}
+		if err := system.RemoveAll(dir); err != nil {
+			return err
+		}
+		if err := rename(sub, dir); err != nil {
+			return err
+		}
+	}
+
+	if err := system.ExecAndDisplay(w, "btrfs", "quota", "enable", path.Dir(dir)); err != nil {
+		return err
+	}
+
+	return system.ExecAndDisplay(w, "btrfs", "qgroup", "limit", strconv.FormatInt(size, 10), dir)
+}
+
+func growImage(w io.Writer, dir string, img string, size int64) error {
+	q, err := GetQuota(path.Dir(dir), path.Base(dir))
+	if err != nil {
+		return err
+	}
+	if q != nil && size <= q.Limit {
+		return errdefs.Errorf(errdefs.ErrInvalid, "loopback quota of '%s' can only grow beyond %s", path.Base(dir), system.FormatBytes(q.Limit))
+	}
+
+	if err := system.ExecAndDisplay(w, "truncate", "-s", strconv.FormatInt(size, 10), img); err != nil {
+		return err
+	}
+	if !mounted(dir) {
+		// resize2fs refuses to grow an unmounted file system that was not checked
+		if err := system.ExecAndDisplay(w, "e2fsck", "-f", "-p", img); err != nil {
+			return err
+		}
+		return system.ExecAndDisplay(w, "resize2fs", img)
+	}
+
+	dev, err := system.ExecAndCapture("findmnt", "-n", "-o", "SOURCE", dir)
+	if err != nil {
+		return err
+	}
+	dev = strings.TrimSpace(dev)
+
+	if err := system.ExecAndDisplay(w, "losetup", "-c", dev); err != nil {
+		return err
+	}
+
+	return system.ExecAndDisplay(w, "resize2fs", dev)
+}
+
+func createImage(w io.Writer, c string, dir string, img string, size int64) error {
+	stage := path.Join(path.Dir(dir), ".#"+c+".quota")
+
+	err := func() error {
+		if err := system.ExecAndDisplay(w, "truncate", "-s", strconv.FormatInt(size, 10), img); err != nil {
+			return err
+		}
+		if err := system.ExecAndDisplay(w, "mkfs.ext4", "-q", "-F", "-m", "0", img); err != nil {
+			return err
+		}
+
+		if err := system.MkdirAll(stage, 0755); err != nil {
+			return err
+		}
+		if err := system.ExecAndDisplay(w, "mount", "-o", "loop", img, stage); err != nil {
+			return err
+		}
+
+		err := system.ExecAndDisplay(w, "cp", "-a", dir+"/.", stage)
+		if uerr := system.Unmount(stage, 0); err == nil {
+			err = uerr
+		}
+
+		return err
+	}()
+	system.RemoveAll(stage)
+	if err != nil {
+		system.Remove(img)
+		return fmt.Errorf("failed to create quota image of '%s': %w", c, err)
+	}
+
+	// The rootfs now lives in the image, which is mounted on the emptied directory
+	if err := system.RemoveAll(dir); err != nil {
+		return err
+	}
+	if err := system.MkdirAll(dir, 0755); err != nil {
+		return err
+	}
+
+	if err := system.ExecAndDisplay(w, "mount", "-o", "loop", img, dir); err != nil {
+		return err
+	}
+
+	// The service unit requires mounts for the rootfs, which pulls in this unit on boot
+	if err := system.CreateMountFile(dir, img, fmt.Sprintf("Disk quota of Photon OS container %s", c)); err != nil {
+		return err
+	}
+
+	return systemd.Reload()
+}
+
+// removeQuota unmounts the loopback quota of the container rooted at dir and removes
+// its image and mount unit. It reports whether there was one.
+func removeQuota(dir string) (bool, error) {
+	img := QuotaImage(dir)
+	if !system.PathExists(img) {
+		return false, nil
+	}
+
+	if mounted(dir) {
+		if err := system.Unmount(dir, 0); err != nil {
+			return true, err
+		}
+	}
+
+	if err := system.Remove(path.Join(system.UnitDir, system.MountUnitName(dir))); err != nil && !os.IsNotExist(err) {
+		return true, err
+	}
+
+	return true, system.Remove(img)
+}
@@ -0,0 +1,93 @@
+// SPDX-License-Identifier: BSD-2
+// Copyright 2023 VMware, Inc.
+
+package container
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"path"
+
+	"github.com/vmware-samples/photon-os-container-builder/pkg/conf"
+	"github.com/vmware-samples/photon-os-container-builder/pkg/lock"
+	"github.com/vmware-samples/photon-os-container-builder/pkg/logging"
+	"github.com/vmware-samples/photon-os-container-builder/pkg/rpm"
+	"github.com/vmware-samples/photon-os-container-builder/pkg/system"
+	"github.com/vmware-samples/photon-os-container-builder/pkg/systemd"
+	"github.com/vmware-samples/photon-os-container-builder/pkg/txn"
+)
+
+// Remove deletes a stopped container: its service unit, lockfile, quota image and
+// root directory.
+func Remove(base string, container string) error {
+	if err := CheckExisting(container); err != nil {
+		return err
+	}
+
+	// The link of a container whose storage pool directory is gone is removed too
+	dir := RootDir(base, container)
+	if !system.PathExists(dir) && !linked(base, container) {
+		return fmt.Errorf("'%s': %w", container, ErrNotExist)
+	}
+
+	l, err := lock.Container(context.Background(), container, "remove")
+	if err != nil {
+		return err
+	}
+	defer l.Release()
+
+	if Running(container) {
+		return fmt.Errorf("'%s': %w", container, ErrRunning)
+	}
+
+	// The rootfs of a container with a loopback quota is the mounted image
+	reload, err := removeQuota(dir)
+	if err != nil {
+		return fmt.Errorf("failed to remove quota image of '%s': %w", container, err)
+	}
+
+	if err := system.RemoveUnitFile(container); err == nil {
+		reload = true
+	} else if !os.IsNotExist(err) {
+		return fmt.Errorf("failed to remove unit file of '%s': %w", container, err)
+	}
+
+	// The unit is gone already, so the rest is removed even when the manager could not
+	// be reloaded
+	var rerr error
+	if reload {
+		if err := systemd.Reload(); err != nil {
+			rerr = fmt.Errorf("failed to reload systemd after removing units of '%s': %w", container, err)
+		}
+	}
+
+	if err := system.Remove(LockfilePath(container)); err != nil && !os.IsNotExist(err) {
+		return fmt.Errorf("failed to remove lockfile of '%s': %w", container, err)
+	}
+
+	// A journal left by a spawn that was interrupted after its rootfs was complete
+	if err := system.Remove(txn.Path(conf.DefaultJournalDir, container)); err != nil && !os.IsNotExist(err) {
+		return fmt.Errorf("failed to remove journal of '%s': %w", container, err)
+	}
+
+	if err := rpm.UnmountCache(dir); err != nil {
+		return err
+	}
+	if err := system.RemoveAll(dir); err != nil {
+		return fmt.Errorf("failed to remove container root directory '%s': %w", dir, err)
+	}
+
+	if linked(base, container) {
+		if err := system.Remove(path.Join(base, container)); err != nil {
+			return fmt.Errorf("failed to remove link of '%s': %w", container, err)
+		}
+	}
+
+	if rerr != nil {
+		return rerr
+	}
+
+	logging.Operation("remove", container).Debug("Removed container")
+	return nil
+}
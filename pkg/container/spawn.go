@@ -0,0 +1,259 @@
+// SPDX-License-Identifier: BSD-2
+// Copyright 2023 VMware, Inc.
+
+package container
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"os/signal"
+	"path"
+	"syscall"
+
+	"github.com/vmware-samples/photon-os-container-builder/pkg/conf"
+	"github.com/vmware-samples/photon-os-container-builder/pkg/errdefs"
+	"github.com/vmware-samples/photon-os-container-builder/pkg/lock"
+	"github.com/vmware-samples/photon-os-container-builder/pkg/rpm"
+	"github.com/vmware-samples/photon-os-container-builder/pkg/slim"
+	"github.com/vmware-samples/photon-os-container-builder/pkg/system"
+	"github.com/vmware-samples/photon-os-container-builder/pkg/systemd"
+	"github.com/vmware-samples/photon-os-container-builder/pkg/txn"
+)
+
+// Steps of a spawn in the order they run.
+const (
+	stepRootfs    = "rootfs"
+	stepInstall   = "install"
+	stepSlim      = "slim"
+	stepMachineID = "machine-id"
+	stepLockfile  = "lockfile"
+	stepNetworkd  = "networkd"
+	stepLink      = "link"
+	stepUnit      = "unit"
+)
+
+// SpawnOptions are the parameters of a spawn. They are recorded in its journal to
+// resume it.
+type SpawnOptions struct {
+	Release   string
+	Packages  string
+	Network   string
+	Link      string
+	Machine   string
+	Ephemeral bool
+	Lock      string
+	Slim      []string
+	Offline   bool
+	Pool      string
+	// Base is the storage directory the container is linked into.
+	Base string
+	Unit *system.UnitOptions
+}
+
+// Create builds the rootfs of container c, records its lockfile and creates its
+// service unit as one transaction journaled in conf.DefaultJournalDir. When a step
+// fails or SIGINT, SIGTERM or SIGHUP is received, the completed steps are rolled
+// back. Progress is written to w.
+func Create(ctx context.Context, cfg *conf.Config, w io.Writer, base string, c string, o *SpawnOptions) error {
+	if err := CheckName(c); err != nil {
+		return err
+	}
+	if err := CheckMachine(o.Machine); err != nil {
+		return err
+	}
+
+	l, err := lock.Container(ctx, c, "spawn")
+	if err != nil {
+		return err
+	}
+	defer l.Release()
+
+	// Recorded so a resume runs with the settings of the spawn
+	o.Offline = o.Offline || cfg.Cache.Offline
+	o.Base = base
+
+	var j *txn.Journal
+	if !system.DryRun() {
+		j, err = txn.Begin(conf.DefaultJournalDir, "spawn", c, o)
+		if err != nil {
+			return err
+		}
+	}
+
+	root, err := PoolDir(cfg, base, o.Pool)
+	if err != nil {
+		j.Commit()
+		return err
+	}
+
+	if lexists(path.Join(base, c)) || lexists(path.Join(root, c)) {
+		j.Commit()
+		return fmt.Errorf("'%s': %w", c, ErrExist)
+	}
+
+	return run(ctx, cfg, w, base, c, o, j)
+}
+
+// Resume continues the interrupted spawn of c with the step it was interrupted in,
+// in the storage directory and with the offline setting of the spawn.
+func Resume(ctx context.Context, cfg *conf.Config, w io.Writer, base string, c string) error {
+	if err := CheckExisting(c); err != nil {
+		return err
+	}
+
+	l, err := lock.Container(ctx, c, "resume")
+	if err != nil {
+		return err
+	}
+	defer l.Release()
+
+	j, err := txn.Load(conf.DefaultJournalDir, c)
+	if err != nil {
+		return err
+	}
+
+	o := SpawnOptions{}
+	if err := json.Unmarshal(j.Params, &o); err != nil {
+		return errdefs.Errorf(errdefs.ErrInvalid, "failed to parse journal of '%s': %w", c, err)
+	}
+	if o.Base != "" {
+		base = o.Base
+	}
+
+	return run(ctx, cfg, w, base, c, &o, j)
+}
+
+// Rollback undoes the interrupted spawn of c.
+func Rollback(c string) error {
+	if err := CheckExisting(c); err != nil {
+		return err
+	}
+
+	l, err := lock.Container(context.Background(), c, "rollback")
+	if err != nil {
+		return err
+	}
+	defer l.Release()
+
+	j, err := txn.Load(conf.DefaultJournalDir, c)
+	if err != nil {
+		return err
+	}
+
+	return j.Rollback()
+}
+
+// Interrupted returns the journals of spawns that did not complete.
+func Interrupted() ([]*txn.Journal, error) {
+	journals, err := txn.List(conf.DefaultJournalDir)
+	if os.IsNotExist(err) {
+		return nil, nil
+	}
+
+	return journals, err
+}
+
+func run(ctx context.Context, cfg *conf.Config, w io.Writer, base string, c string, o *SpawnOptions, j *txn.Journal) error {
+	ctx, stop := signal.NotifyContext(ctx, os.Interrupt, syscall.SIGTERM, syscall.SIGHUP)
+	defer stop()
+
+	if o.Offline {
+		offline := *cfg
+		offline.Cache.Offline = true
+		cfg = &offline
+	}
+
+	// The rootfs is built in the pool and linked into base for machined
+	root, err := PoolDir(cfg, base, o.Pool)
+	if err != nil {
+		return err
+	}
+	if o.Unit == nil {
+		o.Unit = UnitOptions(cfg, base, c)
+	}
+	o.Unit.Directory = path.Join(root, c)
+
+	err = provision(ctx, j, cfg, w, root, c, o)
+	if err == nil && root != base {
+		link := path.Join(base, c)
+		err = j.Step(ctx, stepLink, txn.Undo{Remove: []string{link}}, func() error {
+			return symlink(path.Join(root, c), link)
+		})
+	}
+	if err == nil {
+		err = j.Step(ctx, stepUnit, txn.Undo{Remove: []string{system.UnitFilePath(c)}, Reload: true}, func() error {
+			if err := systemd.SetupContainerService(c, o.Network, o.Link, o.Machine, o.Ephemeral, o.Unit); err != nil {
+				return fmt.Errorf("failed to create unit file for '%s': %w", c, err)
+			}
+			return nil
+		})
+	}
+
+	if err != nil {
+		if ctx.Err() != nil {
+			fmt.Fprintf(w, "Spawn of '%s' interrupted, rolling back\n", c)
+		}
+
+		if rerr := j.Rollback(); rerr != nil {
+			return fmt.Errorf("%w, rolling back failed, retry with 'cntrctl recover rollback %s': %v", err, c, rerr)
+		}
+		return err
+	}
+
+	return j.Commit()
+}
+
+// provision builds the rootfs of container c and records its lockfile, without
+// creating the service unit.
+func provision(ctx context.Context, j *txn.Journal, cfg *conf.Config, w io.Writer, base string, c string, o *SpawnOptions) error {
+	d := path.Join(base, c)
+	release := o.Release
+
+	rules, err := slim.Resolve(cfg, o.Slim)
+	if err != nil {
+		return fmt.Errorf("failed to resolve slim profiles: %w", err)
+	}
+
+	var l *rpm.Lockfile
+	if o.Lock != "" {
+		l, err = rpm.LoadLockfile(o.Lock)
+		if err != nil {
+			return fmt.Errorf("failed to load lockfile: %w", err)
+		}
+
+		if release != "" && release != l.Release {
+			return fmt.Errorf("release '%s' does not match lockfile release '%s'", release, l.Release)
+		}
+	}
+
+	if err := build(ctx, j, cfg, w, base, c, release, o.Packages, l, rules); err != nil {
+		return err
+	}
+
+	if l != nil {
+		release = l.Release
+	}
+
+	err = j.Step(ctx, stepLockfile, txn.Undo{Remove: []string{LockfilePath(c)}}, func() error {
+		// Recording the installed packages is what update-lock does for an existing root
+		return system.Do(system.CommandLine("cntrctl", "update-lock", c), func() error {
+			return writeLockfile(w, d, c, release)
+		})
+	})
+	if err != nil {
+		return fmt.Errorf("failed to write lockfile for '%s': %w", c, err)
+	}
+
+	// Host networking
+	if o.Network == "" {
+		return j.Step(ctx, stepNetworkd, txn.Undo{}, func() error {
+			system.DisableNetworkd(d)
+			return nil
+		})
+	}
+
+	return nil
+}
@@ -0,0 +1,135 @@
+// SPDX-License-Identifier: BSD-2
+// Copyright 2023 VMware, Inc.
+
+package container
+
+import (
+	"context"
+	"errors"
+	"io"
+	"os"
+	"path"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/vmware-samples/photon-os-container-builder/pkg/conf"
+	"github.com/vmware-samples/photon-os-container-builder/pkg/system"
+)
+
+func testConfig(t *testing.T) *conf.Config {
+	t.Helper()
+
+	cfg := &conf.Config{}
+	cfg.System.Release = "5.0"
+	cfg.Security.Profile = conf.SecurityPrivileged
+	cfg.Cache.Dir = "/cache"
+	cfg.Limits.TasksMax = conf.DefaultTasksMax
+	cfg.GPG.Dir = t.TempDir()
+	cfg.GPG.Check = true
+
+	for _, k := range conf.DefaultGPGKeys["5.0"] {
+		if err := os.WriteFile(path.Join(cfg.GPG.Dir, k), nil, 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	return cfg
+}
+
+func TestCreatePlans(t *testing.T) {
+	install := []string{
+		"mkdir -p -m 755 $ROOT",
+		"/usr/bin/rpm --root $ROOT --initdb",
+		"/usr/bin/rpm --root $ROOT --import $KEYS/VMWARE-RPM-GPG-KEY",
+		"/usr/bin/rpm --root $ROOT --import $KEYS/VMWARE-RPM-GPG-KEY-4096",
+		"mkdir -p -m 755 /cache/5.0",
+		"mkdir -p -m 755 $ROOT/var/cache/tdnf",
+		"mount --bind /cache/5.0 $ROOT/var/cache/tdnf",
+		"/usr/bin/tdnf --releasever=5.0 --installroot $ROOT --setopt=gpgcheck=1 --setopt=keepcache=1 install bash -y",
+		"umount $ROOT/var/cache/tdnf",
+		"chmod -R 755 $ROOT",
+		"mkdir -p -m 755 $ROOT/var/log/journal",
+		"/usr/bin/systemd-machine-id-setup --root $ROOT",
+		"cntrctl update-lock web",
+	}
+
+	tests := []struct {
+		name  string
+		opts  SpawnOptions
+		plans []string
+	}{
+		{
+			name: "host network",
+			opts: SpawnOptions{Release: "5.0", Packages: "bash"},
+			plans: append(append([]string{}, install...),
+				"rm -f $ROOT"+system.NetworkdService,
+				"rm -f $ROOT"+system.NetworkdDBus,
+				"rm -f $ROOT"+system.NetworkdOnline,
+				"rm -f $ROOT"+system.NetworkdSocket,
+				"install -m 644 /dev/stdin $UNIT",
+				"install -m 644 /dev/stdin $ROOT/lib/systemd/network/10-web.network",
+				"chown 76:76 $ROOT/lib/systemd/network/10-web.network",
+				"systemctl daemon-reload",
+			),
+		},
+		{
+			name: "macvlan",
+			opts: SpawnOptions{Release: "5.0", Packages: "bash", Network: "macvlan", Link: "eth0"},
+			plans: append(append([]string{}, install...),
+				"install -m 644 /dev/stdin $UNIT",
+				"install -m 644 /dev/stdin $ROOT/lib/systemd/network/10-web.network",
+				"chown 76:76 $ROOT/lib/systemd/network/10-web.network",
+				"systemctl daemon-reload",
+			),
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			e := system.NewRecordingExecutor()
+			defer system.SetExecutor(system.SetExecutor(e))
+
+			cfg := testConfig(t)
+			base := t.TempDir()
+
+			if err := Create(context.Background(), cfg, io.Discard, base, "web", &tt.opts); err != nil {
+				t.Fatalf("Create() error = %v", err)
+			}
+
+			r := strings.NewReplacer("$ROOT", path.Join(base, "web"), "$KEYS", cfg.GPG.Dir, "$UNIT", system.UnitFilePath("web"))
+			var want []string
+			for _, p := range tt.plans {
+				want = append(want, r.Replace(p))
+			}
+
+			if got := e.Plans(); !reflect.DeepEqual(got, want) {
+				t.Errorf("plans:\n%s\nwant:\n%s", strings.Join(got, "\n"), strings.Join(want, "\n"))
+			}
+
+			unit := string(e.Files[system.UnitFilePath("web")])
+			if !strings.Contains(unit, "RequiresMountsFor = "+path.Join(base, "web")) ||
+				!strings.Contains(unit, "CNTRCTL_SYSTEM_STORAGEDIR="+base) {
+				t.Errorf("unit file does not refer to the root of the container:\n%s", unit)
+			}
+		})
+	}
+}
+
+func TestCreateExisting(t *testing.T) {
+	e := system.NewRecordingExecutor()
+	defer system.SetExecutor(system.SetExecutor(e))
+
+	base := t.TempDir()
+	if err := os.Mkdir(path.Join(base, "web"), 0755); err != nil {
+		t.Fatal(err)
+	}
+
+	err := Create(context.Background(), testConfig(t), io.Discard, base, "web", &SpawnOptions{Packages: "bash"})
+	if !errors.Is(err, ErrExist) {
+		t.Errorf("Create() error = %v, want %v", err, ErrExist)
+	}
+	if len(e.Plans()) != 0 {
+		t.Errorf("Create() of an existing container planned %q", e.Plans())
+	}
+}
@@ -0,0 +1,285 @@
+// SPDX-License-Identifier: BSD-2
+// Copyright 2023 VMware, Inc.
+
+package container
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"os"
+	"path"
+	"regexp"
+	"time"
+
+	"github.com/vmware-samples/photon-os-container-builder/pkg/conf"
+	"github.com/vmware-samples/photon-os-container-builder/pkg/lock"
+	"github.com/vmware-samples/photon-os-container-builder/pkg/rpm"
+	"github.com/vmware-samples/photon-os-container-builder/pkg/system"
+)
+
+// DiskUsage is the disk space taken up by a container.
+type DiskUsage struct {
+	Name   string       `json:"name"`
+	Pool   string       `json:"pool"`
+	Rootfs system.Usage `json:"rootfs"`
+	// Snapshots are the copies systemd-nspawn --ephemeral keeps next to the rootfs.
+	Snapshots system.Usage `json:"snapshots"`
+	Quota     *Quota       `json:"quota,omitempty"`
+}
+
+// PoolUsage is the disk space taken up by the containers of a storage pool and the
+// capacity of the file system holding it.
+type PoolUsage struct {
+	Name       string       `json:"name"`
+	Dir        string       `json:"dir"`
+	Containers int          `json:"containers"`
+	Used       system.Usage `json:"used"`
+	Total      uint64       `json:"total"`
+	Free       uint64       `json:"free"`
+}
+
+type CacheUsage struct {
+	Release  string       `json:"release"`
+	Path     string       `json:"path"`
+	Packages int          `json:"packages"`
+	Used     system.Usage `json:"used"`
+}
+
+type Usage struct {
+	Containers []DiskUsage  `json:"containers"`
+	Pools      []PoolUsage  `json:"pools"`
+	Caches     []CacheUsage `json:"caches"`
+}
+
+// snapshotRegexp matches the snapshots systemd-nspawn --ephemeral creates next to
+// the rootfs of a container, .#machine.<container><16 random hex digits>.
+var snapshotRegexp = regexp.MustCompile(`^\.#machine\.(.+)[0-9a-f]{16}$`)
+
+// snapshots returns the snapshots in directory dir by container.
+func snapshots(dir string) map[string][]string {
+	r := map[string][]string{}
+
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		return r
+	}
+
+	for _, e := range entries {
+		if m := snapshotRegexp.FindStringSubmatch(e.Name()); m != nil && e.IsDir() {
+			r[m[1]] = append(r[m[1]], path.Join(dir, e.Name()))
+		}
+	}
+
+	return r
+}
+
+// GetUsage returns the disk usage of all containers, storage pools and package caches.
+// Files hardlinked between trees are counted for the first tree only, extents shared
+// through reflinks or btrfs snapshots are reported separately.
+func GetUsage(cfg *conf.Config, base string) (*Usage, error) {
+	l, err := List(cfg, base)
+	if err != nil {
+		return nil, err
+	}
+
+	u := Usage{Containers: []DiskUsage{}, Pools: []PoolUsage{}, Caches: []CacheUsage{}}
+	seen := system.Inodes{}
+	byPool := map[string]*system.Usage{}
+	count := map[string]int{}
+
+	for _, s := range l {
+		if s.Error != "" {
+			continue
+		}
+
+		d := DiskUsage{Name: s.Name, Pool: s.Pool}
+
+		if d.Rootfs, err = system.DiskUsage(s.Path, seen); err != nil {
+			return nil, fmt.Errorf("failed to measure '%s': %w", s.Path, err)
+		}
+		for _, snap := range snapshots(path.Dir(s.Path))[s.Name] {
+			su, err := system.DiskUsage(snap, seen)
+			if err != nil {
+				return nil, fmt.Errorf("failed to measure '%s': %w", snap, err)
+			}
+			d.Snapshots.Add(su)
+		}
+		if d.Quota, err = GetQuota(base, s.Name); err != nil {
+			return nil, err
+		}
+
+		if byPool[s.Pool] == nil {
+			byPool[s.Pool] = &system.Usage{}
+		}
+		byPool[s.Pool].Add(d.Rootfs)
+		byPool[s.Pool].Add(d.Snapshots)
+		count[s.Pool]++
+
+		u.Containers = append(u.Containers, d)
+	}
+
+	for _, p := range cfg.StoragePools() {
+		dir := p.Dir
+		if p.Name == conf.DefaultStoragePool {
+			dir = base
+		}
+
+		pu := PoolUsage{Name: p.Name, Dir: dir, Containers: count[p.Name]}
+		if byPool[p.Name] != nil {
+			pu.Used = *byPool[p.Name]
+		}
+		if f, err := system.StatFilesystem(dir); err == nil {
+			pu.Total, pu.Free = f.Total, f.Free
+		}
+
+		u.Pools = append(u.Pools, pu)
+	}
+
+	caches, err := rpm.ListCache(cfg.Cache.Dir)
+	if err != nil {
+		return nil, fmt.Errorf("failed to list package cache '%s': %w", cfg.Cache.Dir, err)
+	}
+	for _, c := range caches {
+		cu := CacheUsage{Release: c.Release, Path: c.Path, Packages: c.Packages}
+		if cu.Used, err = system.DiskUsage(c.Path, seen); err != nil {
+			return nil, fmt.Errorf("failed to measure '%s': %w", c.Path, err)
+		}
+
+		u.Caches = append(u.Caches, cu)
+	}
+
+	return &u, nil
+}
+
+// runNameRegexp matches the names test and matrix runs give their containers.
+var runNameRegexp = regexp.MustCompile(`-[0-9a-f]{8}$`)
+
+// Pruned is what Prune removed, or would remove in dry-run mode.
+type Pruned struct {
+	Snapshots  []string `json:"snapshots"`
+	Containers []string `json:"containers"`
+	Packages   int      `json:"packages"`
+	// Size is the space freed, not counting extents still shared with other files.
+	Size int64 `json:"size"`
+}
+
+// inUse returns the root directories of running machines.
+func inUse() map[string]bool {
+	r := map[string]bool{}
+
+	entries, err := os.ReadDir(machinesDir)
+	if err != nil {
+		return r
+	}
+
+	for _, e := range entries {
+		if m, err := system.ParseMachine(path.Join(machinesDir, e.Name())); err == nil && m["ROOT"] != "" {
+			r[m["ROOT"]] = true
+		}
+	}
+
+	return r
+}
+
+// Prune removes leftovers not modified within olderThan: snapshots of ephemeral
+// containers no running machine uses, stopped containers of test and matrix runs
+// without a service unit, and cached packages.
+func Prune(cfg *conf.Config, w io.Writer, base string, olderThan time.Duration) (*Pruned, error) {
+	p := Pruned{Snapshots: []string{}, Containers: []string{}}
+	cutoff := time.Now().Add(-olderThan)
+	used := inUse()
+	seen := system.Inodes{}
+
+	old := func(dir string) bool {
+		info, err := os.Lstat(dir)
+		return err == nil && info.ModTime().Before(cutoff)
+	}
+
+	remove := func(dir string) error {
+		if err := rpm.UnmountCache(dir); err != nil {
+			return err
+		}
+
+		u, _ := system.DiskUsage(dir, seen)
+		if err := system.RemoveAll(dir); err != nil {
+			return fmt.Errorf("failed to remove '%s': %w", dir, err)
+		}
+
+		fmt.Fprintf(w, "Removed '%s' (%s)\n", dir, system.FormatBytes(u.Size-u.Shared))
+		p.Size += u.Size - u.Shared
+		return nil
+	}
+
+	l, err := List(cfg, base)
+	if err != nil {
+		return &p, err
+	}
+
+	// Files hardlinked from containers that are kept are not freed, so those are
+	// accounted for first
+	var stale []string
+	for _, s := range l {
+		if s.Error != "" {
+			continue
+		}
+		if !runNameRegexp.MatchString(s.Name) || s.Running || !old(s.Path) ||
+			system.PathExists(system.UnitFilePath(s.Name)) || system.PathExists(LockfilePath(s.Name)) {
+			system.DiskUsage(s.Path, seen)
+			continue
+		}
+		stale = append(stale, s.Name)
+	}
+
+	for _, pool := range cfg.StoragePools() {
+		dir := pool.Dir
+		if pool.Name == conf.DefaultStoragePool {
+			dir = base
+		}
+
+		for c, snaps := range snapshots(dir) {
+			if err := func() error {
+				// A boot of the container may be creating a snapshot right now
+				l, err := lock.Container(context.Background(), c, "prune")
+				if err != nil {
+					fmt.Fprintf(w, "Skipping snapshots of '%s': %v\n", c, err)
+					return nil
+				}
+				defer l.Release()
+
+				for _, snap := range snaps {
+					if used[snap] || !old(snap) {
+						continue
+					}
+					if err := remove(snap); err != nil {
+						return err
+					}
+					p.Snapshots = append(p.Snapshots, snap)
+				}
+
+				return nil
+			}(); err != nil {
+				return &p, err
+			}
+		}
+	}
+
+	for _, c := range stale {
+		u, _ := system.DiskUsage(RootDir(base, c), seen)
+		if err := Remove(base, c); err != nil {
+			return &p, err
+		}
+
+		fmt.Fprintf(w, "Removed container '%s' (%s)\n", c, system.FormatBytes(u.Size-u.Shared))
+		p.Size += u.Size - u.Shared
+		p.Containers = append(p.Containers, c)
+	}
+
+	n, size, err := rpm.PruneCache(cfg.Cache.Dir, "", olderThan)
+	if err != nil {
+		return &p, fmt.Errorf("failed to prune package cache '%s': %w", cfg.Cache.Dir, err)
+	}
+	p.Packages, p.Size = n, p.Size+size
+
+	return &p, nil
+}
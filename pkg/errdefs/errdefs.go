@@ -0,0 +1,75 @@
+// SPDX-License-Identifier: BSD-2
+// Copyright 2023 VMware, Inc.
+
+// Package errdefs defines the kinds of errors shared by all packages. Errors carry
+// their kind alongside the wrapped cause, so callers test them with errors.Is and
+// still get the full context in the message.
+package errdefs
+
+import (
+	"errors"
+	"fmt"
+	"io/fs"
+)
+
+var (
+	ErrNotFound       = errors.New("not found")
+	ErrExists         = errors.New("already exists")
+	ErrInvalid        = errors.New("invalid argument")
+	ErrConflict       = errors.New("conflicting state")
+	ErrDependency     = errors.New("dependency missing")
+	ErrPackageManager = errors.New("package manager failed")
+	ErrBus            = errors.New("bus failure")
+	ErrTimeout        = errors.New("timeout")
+	ErrPermission     = errors.New("permission denied")
+)
+
+type kindError struct {
+	kind error
+	err  error
+}
+
+func (e *kindError) Error() string {
+	return e.err.Error()
+}
+
+func (e *kindError) Unwrap() []error {
+	return []error{e.err, e.kind}
+}
+
+// New returns an error of kind with message msg.
+func New(kind error, msg string) error {
+	return &kindError{kind: kind, err: errors.New(msg)}
+}
+
+// Errorf formats an error of kind, %w verbs wrap causes as with fmt.Errorf.
+func Errorf(kind error, format string, args ...interface{}) error {
+	return &kindError{kind: kind, err: fmt.Errorf(format, args...)}
+}
+
+// Wrap marks err as being of kind, keeping its message.
+func Wrap(kind error, err error) error {
+	if err == nil {
+		return nil
+	}
+
+	return &kindError{kind: kind, err: err}
+}
+
+// Kind returns the first kind err is of, in order of specificity, or nil.
+func Kind(err error) error {
+	for _, k := range []error{ErrDependency, ErrPermission, ErrNotFound, ErrExists, ErrInvalid, ErrConflict, ErrTimeout, ErrBus, ErrPackageManager} {
+		if errors.Is(err, k) {
+			return k
+		}
+	}
+
+	switch {
+	case errors.Is(err, fs.ErrPermission):
+		return ErrPermission
+	case errors.Is(err, fs.ErrNotExist):
+		return ErrNotFound
+	}
+
+	return nil
+}
@@ -0,0 +1,184 @@
+// SPDX-License-Identifier: BSD-2
+// Copyright 2023 VMware, Inc.
+
+// Package lock serializes cntrctl processes working on the same container, and on
+// the systemd manager, with advisory file locks. A lock file holds the pid and
+// operation of its holder so a waiting process can tell who it waits for.
+package lock
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"path"
+	"strconv"
+	"strings"
+	"time"
+
+	"golang.org/x/sys/unix"
+
+	"github.com/vmware-samples/photon-os-container-builder/pkg/conf"
+	"github.com/vmware-samples/photon-os-container-builder/pkg/errdefs"
+	"github.com/vmware-samples/photon-os-container-builder/pkg/system"
+)
+
+const (
+	DefaultTimeout = 30 * time.Second
+
+	globalName   = "global"
+	cachePrefix  = "cache-"
+	pollInterval = 100 * time.Millisecond
+)
+
+var (
+	// Dir holds the lock files. It is below /run so stale files vanish on reboot.
+	Dir = conf.DefaultLockDir
+	// Timeout is how long to wait for a lock held by another process, 0 fails at once.
+	Timeout = DefaultTimeout
+)
+
+type Lock struct {
+	f *os.File
+}
+
+// Container locks container name for operation.
+func Container(ctx context.Context, name string, operation string) (*Lock, error) {
+	l, err := acquire(ctx, path.Join(Dir, name+".lock"), operation, Timeout)
+	if err != nil {
+		return nil, busy(fmt.Sprintf("container '%s'", name), err)
+	}
+
+	return l, nil
+}
+
+// Global locks operations on shared host state, such as reloading the systemd manager.
+func Global(ctx context.Context, operation string) (*Lock, error) {
+	l, err := acquire(ctx, path.Join(path.Dir(Dir), globalName+".lock"), operation, Timeout)
+	if err != nil {
+		return nil, busy("systemd manager", err)
+	}
+
+	return l, nil
+}
+
+// Cache locks the package cache of release for operation. Package installations
+// take long, so it is waited for until ctx is done instead of Timeout.
+func Cache(ctx context.Context, release string, operation string) (*Lock, error) {
+	l, err := acquire(ctx, path.Join(path.Dir(Dir), cachePrefix+release+".lock"), operation, -1)
+	if err != nil {
+		return nil, busy(fmt.Sprintf("package cache of release '%s'", release), err)
+	}
+
+	return l, nil
+}
+
+// Held reports whether another process, or another lock of this one, holds the lock
+// of container name.
+func Held(name string) bool {
+	f, err := os.Open(path.Join(Dir, name+".lock"))
+	if err != nil {
+		return false
+	}
+	defer f.Close()
+
+	if err := unix.Flock(int(f.Fd()), unix.LOCK_SH|unix.LOCK_NB); err != nil {
+		return errors.Is(err, unix.EWOULDBLOCK)
+	}
+	unix.Flock(int(f.Fd()), unix.LOCK_UN)
+
+	return false
+}
+
+// holderError is returned when the lock is still held once the timeout passed.
+type holderError struct {
+	pid       int
+	operation string
+}
+
+func (e *holderError) Error() string {
+	if e.pid == 0 {
+		return "held by another process"
+	}
+
+	return fmt.Sprintf("held by pid %d, operation %s", e.pid, e.operation)
+}
+
+func busy(what string, err error) error {
+	var h *holderError
+	if errors.As(err, &h) {
+		return errdefs.Errorf(errdefs.ErrConflict, "%s busy (%s)", what, h.Error())
+	}
+
+	return fmt.Errorf("failed to lock %s: %w", what, err)
+}
+
+// acquire locks file, waiting up to timeout for another holder. A negative timeout
+// waits until ctx is done.
+func acquire(ctx context.Context, file string, operation string, timeout time.Duration) (*Lock, error) {
+	// A dry run changes nothing that needs protecting
+	if system.DryRun() {
+		return nil, nil
+	}
+
+	if err := os.MkdirAll(path.Dir(file), 0755); err != nil {
+		return nil, err
+	}
+
+	f, err := os.OpenFile(file, os.O_RDWR|os.O_CREATE, 0644)
+	if err != nil {
+		return nil, err
+	}
+
+	deadline := time.Now().Add(timeout)
+	for {
+		err := unix.Flock(int(f.Fd()), unix.LOCK_EX|unix.LOCK_NB)
+		if err == nil {
+			break
+		}
+		if !errors.Is(err, unix.EWOULDBLOCK) {
+			f.Close()
+			return nil, err
+		}
+
+		if timeout >= 0 && time.Now().After(deadline) || ctx.Err() != nil {
+			h := holder(f)
+			f.Close()
+			return nil, h
+		}
+
+		select {
+		case <-ctx.Done():
+		case <-time.After(pollInterval):
+		}
+	}
+
+	if err := f.Truncate(0); err == nil {
+		f.WriteAt([]byte(fmt.Sprintf("%d %s\n", os.Getpid(), operation)), 0)
+	}
+
+	return &Lock{f: f}, nil
+}
+
+func holder(f *os.File) *holderError {
+	h := &holderError{}
+
+	b := make([]byte, 256)
+	n, _ := f.ReadAt(b, 0)
+	pid, operation, _ := strings.Cut(strings.TrimSpace(string(b[:n])), " ")
+	h.pid, _ = strconv.Atoi(pid)
+	h.operation = operation
+
+	return h
+}
+
+// Release unlocks l. It may be nil.
+func (l *Lock) Release() error {
+	if l == nil {
+		return nil
+	}
+
+	l.f.Truncate(0)
+	unix.Flock(int(l.f.Fd()), unix.LOCK_UN)
+	return l.f.Close()
+}
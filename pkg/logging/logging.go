@@ -0,0 +1,125 @@
+// SPDX-License-Identifier: BSD-2
+// Copyright 2023 VMware, Inc.
+
+// Package logging configures the logrus logger shared by all packages and names the
+// fields log entries carry.
+package logging
+
+import (
+	"fmt"
+	"io"
+	"os"
+	"strings"
+
+	"github.com/coreos/go-systemd/v22/journal"
+	log "github.com/sirupsen/logrus"
+
+	"github.com/vmware-samples/photon-os-container-builder/pkg/errdefs"
+)
+
+const (
+	FormatText    = "text"
+	FormatJSON    = "json"
+	FormatJournal = "journal"
+)
+
+// Fields of log entries.
+const (
+	FieldContainer = "container"
+	FieldRelease   = "release"
+	FieldOperation = "operation"
+	FieldUnit      = "unit"
+)
+
+type Options struct {
+	Level string
+	// Format is text, json or journal. Empty selects journal when stderr is connected
+	// to the journal, as for services started by systemd, and text otherwise.
+	Format string
+}
+
+// Setup configures the standard logrus logger.
+func Setup(o *Options) error {
+	level, err := log.ParseLevel(o.Level)
+	if err != nil {
+		return errdefs.Errorf(errdefs.ErrInvalid, "invalid log level '%s'", o.Level)
+	}
+	log.SetLevel(level)
+	log.SetOutput(os.Stderr)
+
+	format := o.Format
+	if format == "" {
+		format = FormatText
+		if ok, _ := journal.StderrIsJournalStream(); ok && journal.Enabled() {
+			format = FormatJournal
+		}
+	}
+
+	switch format {
+	case FormatText:
+		log.SetFormatter(&log.TextFormatter{})
+	case FormatJSON:
+		log.SetFormatter(&log.JSONFormatter{})
+	case FormatJournal:
+		if !journal.Enabled() {
+			return errdefs.New(errdefs.ErrDependency, "journald is not available")
+		}
+
+		log.SetOutput(io.Discard)
+		log.AddHook(&journalHook{})
+	default:
+		return errdefs.Errorf(errdefs.ErrInvalid, "unsupported log format '%s'", o.Format)
+	}
+
+	return nil
+}
+
+// Operation returns an entry for operation on container.
+func Operation(operation string, container string) *log.Entry {
+	f := log.Fields{FieldOperation: operation}
+	if container != "" {
+		f[FieldContainer] = container
+	}
+
+	return log.WithFields(f)
+}
+
+// journalHook sends entries to journald, fields become journal fields in upper case.
+type journalHook struct{}
+
+func (h *journalHook) Levels() []log.Level {
+	return log.AllLevels
+}
+
+func (h *journalHook) Fire(e *log.Entry) error {
+	vars := map[string]string{
+		"SYSLOG_IDENTIFIER": "cntrctl",
+	}
+
+	for k, v := range e.Data {
+		vars[journalField(k)] = fmt.Sprint(v)
+	}
+
+	return journal.Send(e.Message, priority(e.Level), vars)
+}
+
+func journalField(k string) string {
+	return strings.ToUpper(strings.NewReplacer("-", "_", ".", "_").Replace(k))
+}
+
+func priority(l log.Level) journal.Priority {
+	switch l {
+	case log.PanicLevel:
+		return journal.PriEmerg
+	case log.FatalLevel:
+		return journal.PriCrit
+	case log.ErrorLevel:
+		return journal.PriErr
+	case log.WarnLevel:
+		return journal.PriWarning
+	case log.InfoLevel:
+		return journal.PriInfo
+	}
+
+	return journal.PriDebug
+}
@@ -4,18 +4,34 @@
 package nspawn
 
 import (
+	"context"
 	"errors"
 	"fmt"
+	"io"
+	"os/exec"
+	"syscall"
+	"time"
 
 	"github.com/vmware-samples/photon-os-container-builder/pkg/conf"
 	"github.com/vmware-samples/photon-os-container-builder/pkg/system"
 )
 
 const (
-	nspawn     = "/usr/bin/systemd-nspawn"
-	capability = "--capability=all"
+	nspawn = "/usr/bin/systemd-nspawn"
 )
 
+// securityArgs returns the arguments of systemd-nspawn for a security profile.
+func securityArgs(profile string) []string {
+	switch profile {
+	case conf.SecurityDefault:
+		return nil
+	case conf.SecurityUserNS:
+		return []string{"--private-users=pick", "--private-users-ownership=auto"}
+	}
+
+	return []string{"--capability=all"}
+}
+
 func determineNetworking(network string, link string) (string, error) {
 	var netDev string
 
@@ -33,90 +49,216 @@ func determineNetworking(network string, link string) (string, error) {
 func Spawn(c string, dir bool) (err error) {
 	if dir {
 		if err = system.ExecAndRenounce(nspawn, "-D", c); err != nil {
-			fmt.Printf("Failed to execute systemd-nspawn: %+v\n", err)
-			return err
+			return fmt.Errorf("failed to execute systemd-nspawn: %w", err)
 		}
 	}
 
 	return nil
 }
 
-func ThunderBolt(c *conf.Config, container string, network string, link string, machine string, ephemeral bool) (err error) {
-	var netDev string
+func ThunderBolt(c *conf.Config, container string, network string, link string, machine string, ephemeral bool) error {
+	args := append([]string{nspawn}, securityArgs(c.Security.Profile)...)
+	if ephemeral {
+		args = append(args, "-xD", container)
+	} else {
+		args = append(args, "-D", container)
+	}
+
+	if machine != "" {
+		args = append(args, "-M", machine)
+	}
 
 	if network != "" {
-		netDev, err = determineNetworking(network, link)
+		netDev, err := determineNetworking(network, link)
 		if err != nil {
 			return err
 		}
+
+		args = append(args, netDev)
 	}
 
-	if network != "" {
-		if ephemeral {
-			if machine != "" {
-				err = system.ExecAndRenounce(nspawn, capability, "-xD", container, "-M", machine, netDev)
-			} else {
-				err = system.ExecAndRenounce(nspawn, capability, "-xD", container, netDev)
-			}
-		} else {
-			if machine != "" {
-				err = system.ExecAndRenounce(nspawn, capability, "-D", container, "-M", machine, netDev)
-			} else {
-				err = system.ExecAndRenounce(nspawn, capability, "-D", container, netDev)
-			}
-		}
+	// Waited for, so the caller holds the lock of the container until it powered off
+	if err := system.ExecAndWait(args...); err != nil {
+		return fmt.Errorf("failed to start existing container '%s': %w", container, err)
+	}
+
+	return nil
+}
+
+type BootOptions struct {
+	Directory string
+	Machine   string
+	Network   string
+	Link      string
+	Ephemeral bool
+	Binds     []string
+	ReadOnly  []string
+	// KeepUnit runs the container in the unit of the caller rather than a scope.
+	KeepUnit bool
+	// Security is the profile of conf.Security, privileged when empty.
+	Security string
+}
+
+func (o *BootOptions) args() ([]string, error) {
+	args := securityArgs(o.Security)
+
+	if o.Ephemeral {
+		args = append(args, "-xbD", o.Directory)
 	} else {
-		if ephemeral {
-			if machine != "" {
-				err = system.ExecAndRenounce(nspawn, capability, "-xD", container, "-M", machine)
-			} else {
-				err = system.ExecAndRenounce(nspawn, capability, "-xD", container)
-			}
-		} else {
-			if machine != "" {
-				err = system.ExecAndRenounce(nspawn, capability, "-D", container, "-M", machine)
-			} else {
-				err = system.ExecAndRenounce(nspawn, capability, "-D", container)
-			}
+		args = append(args, "-bD", o.Directory)
+	}
+
+	if o.Network != "" {
+		netDev, err := determineNetworking(o.Network, o.Link)
+		if err != nil {
+			return nil, err
 		}
 
+		args = append(args, netDev)
+	}
+
+	// The journal of an ephemeral snapshot is gone with it, only link it when the
+	// container persists or has its own network.
+	if o.Network != "" || !o.Ephemeral {
+		args = append(args, "--link-journal=try-guest")
 	}
+
+	if o.Machine != "" {
+		args = append(args, "-M", o.Machine)
+	}
+
+	if o.KeepUnit {
+		args = append(args, "--keep-unit")
+	}
+
+	for _, b := range o.Binds {
+		args = append(args, "--bind="+b)
+	}
+	for _, b := range o.ReadOnly {
+		args = append(args, "--bind-ro="+b)
+	}
+
+	return args, nil
+}
+
+func Boot(c *conf.Config, container string, network string, link string, machine string, ephemeral bool, keepUnit bool, binds []string, readOnly []string) (err error) {
+	o := BootOptions{
+		Directory: container,
+		Machine:   machine,
+		Network:   network,
+		Link:      link,
+		Ephemeral: ephemeral,
+		Binds:     binds,
+		ReadOnly:  readOnly,
+		KeepUnit:  keepUnit,
+		Security:  c.Security.Profile,
+	}
+
+	args, err := o.args()
 	if err != nil {
-		fmt.Printf("Failed to start existing container '%s': %+v\n", container, err)
 		return err
 	}
 
+	// Waited for, so the caller holds the lock of the container until it powered off
+	if err = system.ExecAndWait(append([]string{nspawn}, args...)...); err != nil {
+		return fmt.Errorf("failed to boot container '%s': %w", container, err)
+	}
+
 	return nil
 }
 
-func Boot(c *conf.Config, container string, network string, link string, machine string, ephemeral bool) (err error) {
-	var netDev string
+// BootContext boots a container as a child process and returns once it powered off.
+// Cancelling ctx asks the container to shut down.
+func BootContext(ctx context.Context, o *BootOptions, w io.Writer) error {
+	args, err := o.args()
+	if err != nil {
+		return err
+	}
 
-	if network != "" {
-		netDev, err = determineNetworking(network, link)
+	args = append(args, "--console=passive")
+	cmd := exec.CommandContext(ctx, nspawn, args...)
+	cmd.Stdout = w
+	cmd.Stderr = w
+	cmd.Cancel = func() error {
+		// systemd-nspawn shuts the container down cleanly on SIGTERM
+		return cmd.Process.Signal(syscall.SIGTERM)
+	}
+	cmd.WaitDelay = 90 * time.Second
+
+	return system.Do(system.CommandLine(append([]string{nspawn}, args...)...), cmd.Run)
+}
+
+type RunOptions struct {
+	Directory string
+	Machine   string
+	Ephemeral bool
+	Binds     []string
+	ReadOnly  []string
+	Overlays  []string
+	Network   string
+	Link      string
+	Chdir     string
+	Env       []string
+	Command   string
+	Output    io.Writer
+	Security  string
+}
+
+// Run executes a shell command inside the container without booting it and waits
+// for it to exit. The container is terminated when ctx is done.
+func Run(ctx context.Context, o *RunOptions) error {
+	args := append(securityArgs(o.Security), "--quiet", "--as-pid2", "--register=no", "-D", o.Directory)
+	if o.Network != "" {
+		netDev, err := determineNetworking(o.Network, o.Link)
 		if err != nil {
 			return err
 		}
+		args = append(args, netDev)
+	}
+	if o.Ephemeral {
+		args = append(args, "-x")
 	}
+	if o.Machine != "" {
+		args = append(args, "-M", o.Machine)
+	}
+	for _, b := range o.Binds {
+		args = append(args, "--bind="+b)
+	}
+	for _, b := range o.ReadOnly {
+		args = append(args, "--bind-ro="+b)
+	}
+	for _, b := range o.Overlays {
+		args = append(args, "--overlay="+b)
+	}
+	if o.Chdir != "" {
+		args = append(args, "--chdir="+o.Chdir)
+	}
+	for _, e := range o.Env {
+		args = append(args, "--setenv="+e)
+	}
+	args = append(args, "/bin/sh", "-c", o.Command)
 
-	if network != "" {
-		if ephemeral {
-			err = system.ExecAndRenounce(nspawn, capability, "-xbD", container, netDev, "--link-journal=try-guest", "-M", machine)
-		} else {
-			err = system.ExecAndRenounce(nspawn, capability, "-bD", container, netDev, "--link-journal=try-guest", "-M", machine)
-		}
-	} else {
-		if ephemeral {
-			err = system.ExecAndRenounce(nspawn, capability, "-xbD", container, "-M", machine)
-		} else {
-			err = system.ExecAndRenounce(nspawn, capability, "-bD", container, "--link-journal=try-guest", "-M", machine)
-		}
+	c := exec.CommandContext(ctx, nspawn, args...)
+	c.Stdout = o.Output
+	c.Stderr = o.Output
+	c.Cancel = func() error {
+		return c.Process.Signal(syscall.SIGTERM)
 	}
+	c.WaitDelay = 10 * time.Second
 
-	if err != nil {
-		fmt.Printf("Failed to boot container '%s': %+v\n", container, err)
-		return err
+	return system.Do(system.CommandLine(append([]string{nspawn}, args...)...), c.Run)
+}
+
+// InterfaceName returns the name of the MACVLAN/IPVLAN interface inside the container.
+func InterfaceName(network string, link string) string {
+	n := "mv-" + link
+	if network == "ipvlan" {
+		n = "iv-" + link
 	}
 
-	return nil
+	if len(n) > 15 {
+		n = n[:15]
+	}
+
+	return n
 }
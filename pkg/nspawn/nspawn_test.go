@@ -0,0 +1,148 @@
+// SPDX-License-Identifier: BSD-2
+// Copyright 2023 VMware, Inc.
+
+package nspawn
+
+import (
+	"context"
+	"io"
+	"reflect"
+	"testing"
+
+	"github.com/vmware-samples/photon-os-container-builder/pkg/conf"
+	"github.com/vmware-samples/photon-os-container-builder/pkg/system"
+)
+
+func TestBootOptionsArgs(t *testing.T) {
+	tests := []struct {
+		name    string
+		opts    BootOptions
+		want    []string
+		wantErr bool
+	}{
+		{
+			name: "privileged",
+			opts: BootOptions{Directory: "/var/lib/machines/web"},
+			want: []string{"--capability=all", "-bD", "/var/lib/machines/web", "--link-journal=try-guest"},
+		},
+		{
+			name: "default security",
+			opts: BootOptions{Directory: "/m/web", Security: conf.SecurityDefault, Machine: "www", KeepUnit: true},
+			want: []string{"-bD", "/m/web", "--link-journal=try-guest", "-M", "www", "--keep-unit"},
+		},
+		{
+			name: "user namespace",
+			opts: BootOptions{Directory: "/m/web", Security: conf.SecurityUserNS},
+			want: []string{"--private-users=pick", "--private-users-ownership=auto", "-bD", "/m/web", "--link-journal=try-guest"},
+		},
+		{
+			name: "ephemeral",
+			opts: BootOptions{Directory: "/m/web", Security: conf.SecurityDefault, Ephemeral: true},
+			want: []string{"-xbD", "/m/web"},
+		},
+		{
+			name: "ephemeral with network",
+			opts: BootOptions{Directory: "/m/web", Security: conf.SecurityDefault, Ephemeral: true, Network: "ipvlan", Link: "eth1"},
+			want: []string{"-xbD", "/m/web", "--network-ipvlan=eth1", "--link-journal=try-guest"},
+		},
+		{
+			name: "binds",
+			opts: BootOptions{Directory: "/m/web", Security: conf.SecurityDefault, Network: "macvlan", Link: "eth0",
+				Binds: []string{"/srv:/srv", "/a b:/c"}, ReadOnly: []string{"/etc/hosts"}},
+			want: []string{"-bD", "/m/web", "--network-macvlan=eth0", "--link-journal=try-guest",
+				"--bind=/srv:/srv", "--bind=/a b:/c", "--bind-ro=/etc/hosts"},
+		},
+		{
+			name:    "unsupported network",
+			opts:    BootOptions{Directory: "/m/web", Network: "bridge", Link: "br0"},
+			wantErr: true,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := tt.opts.args()
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("args() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("args() = %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestBoot(t *testing.T) {
+	e := system.NewRecordingExecutor()
+	defer system.SetExecutor(system.SetExecutor(e))
+
+	c := &conf.Config{Security: conf.Security{Profile: conf.SecurityDefault}}
+	if err := Boot(c, "/m/web", "macvlan", "eth0", "www", false, true, []string{"/srv:/srv"}, nil); err != nil {
+		t.Fatal(err)
+	}
+
+	want := []string{nspawn + " -bD /m/web --network-macvlan=eth0 --link-journal=try-guest -M www --keep-unit --bind=/srv:/srv"}
+	if got := e.Plans(); !reflect.DeepEqual(got, want) {
+		t.Errorf("plans = %q, want %q", got, want)
+	}
+}
+
+func TestRun(t *testing.T) {
+	tests := []struct {
+		name    string
+		opts    RunOptions
+		want    string
+		wantErr bool
+	}{
+		{
+			name: "plain",
+			opts: RunOptions{Directory: "/m/t", Command: "make check"},
+			want: nspawn + " --capability=all --quiet --as-pid2 --register=no -D /m/t /bin/sh -c 'make check'",
+		},
+		{
+			name: "all options",
+			opts: RunOptions{
+				Directory: "/m/t",
+				Security:  conf.SecurityDefault,
+				Network:   "macvlan",
+				Link:      "eth0",
+				Ephemeral: true,
+				Machine:   "t1",
+				Binds:     []string{"/out:/out"},
+				ReadOnly:  []string{"/suite:/suite"},
+				Overlays:  []string{"/lower:/upper:/work"},
+				Chdir:     "/suite",
+				Env:       []string{"A=1"},
+				Command:   "./run",
+			},
+			want: nspawn + " --quiet --as-pid2 --register=no -D /m/t --network-macvlan=eth0 -x -M t1 --bind=/out:/out" +
+				" --bind-ro=/suite:/suite --overlay=/lower:/upper:/work --chdir=/suite --setenv=A=1 /bin/sh -c ./run",
+		},
+		{
+			name:    "unsupported network",
+			opts:    RunOptions{Directory: "/m/t", Network: "bridge", Command: "true"},
+			wantErr: true,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			e := system.NewRecordingExecutor()
+			defer system.SetExecutor(system.SetExecutor(e))
+
+			tt.opts.Output = io.Discard
+			err := Run(context.Background(), &tt.opts)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("Run() error = %v, wantErr %v", err, tt.wantErr)
+			}
+
+			var want []string
+			if !tt.wantErr {
+				want = []string{tt.want}
+			}
+			if got := e.Plans(); !reflect.DeepEqual(got, want) {
+				t.Errorf("plans = %q, want %q", got, want)
+			}
+		})
+	}
+}
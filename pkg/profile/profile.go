@@ -0,0 +1,167 @@
+// SPDX-License-Identifier: BSD-2
+// Copyright 2023 VMware, Inc.
+
+package profile
+
+import (
+	"fmt"
+	"path"
+	"sort"
+	"strings"
+
+	"github.com/vmware-samples/photon-os-container-builder/pkg/conf"
+	"github.com/vmware-samples/photon-os-container-builder/pkg/errdefs"
+	"github.com/vmware-samples/photon-os-container-builder/pkg/set"
+	"github.com/vmware-samples/photon-os-container-builder/pkg/system"
+)
+
+type edit struct {
+	name   string
+	remove bool
+	// plain is an entry without + or -, it replaces the default profile when no
+	// profile is selected.
+	plain bool
+}
+
+// parse splits entries into edits. @FILE entries are replaced by the lines of FILE,
+// relative files are resolved against dir.
+func parse(entries []string, dir string, seen map[string]bool) ([]edit, error) {
+	var r []edit
+	for _, e := range entries {
+		e = strings.TrimSpace(e)
+
+		switch {
+		case e == "":
+		case strings.HasPrefix(e, "@"):
+			f := e[1:]
+			if !path.IsAbs(f) {
+				f = path.Join(dir, f)
+			}
+			if seen[f] {
+				return nil, errdefs.Errorf(errdefs.ErrInvalid, "package list '%s' includes itself", f)
+			}
+
+			lines, err := system.ReadLines(f)
+			if err != nil {
+				return nil, fmt.Errorf("failed to read package list '%s': %w", f, err)
+			}
+
+			seen[f] = true
+			edits, err := parse(lines, path.Dir(f), seen)
+			delete(seen, f)
+			if err != nil {
+				return nil, err
+			}
+
+			r = append(r, edits...)
+		case strings.HasPrefix(e, "+"):
+			r = append(r, edit{name: e[1:]})
+		case strings.HasPrefix(e, "-"):
+			r = append(r, edit{name: e[1:], remove: true})
+		default:
+			r = append(r, edit{name: e, plain: true})
+		}
+	}
+
+	for _, e := range r {
+		if e.name == "" || strings.ContainsAny(e.name, " \t/") {
+			return nil, errdefs.Errorf(errdefs.ErrInvalid, "invalid package name '%s'", e.name)
+		}
+	}
+
+	return r, nil
+}
+
+func apply(s *set.Set, edits []edit) {
+	for _, e := range edits {
+		if e.remove {
+			s.Remove(e.name)
+		} else {
+			s.Add(e.name)
+		}
+	}
+}
+
+func resolve(c *conf.Config, s *set.Set, name string, release string, seen map[string]bool) error {
+	if seen[name] {
+		return errdefs.Errorf(errdefs.ErrInvalid, "package profile '%s' includes itself", name)
+	}
+	seen[name] = true
+	defer delete(seen, name)
+
+	p, ok := c.PackageProfile(name)
+	if !ok {
+		return errdefs.Errorf(errdefs.ErrNotFound, "unknown package profile '%s'", name)
+	}
+
+	for _, i := range p.Include {
+		if err := resolve(c, s, i, release, seen); err != nil {
+			return err
+		}
+	}
+
+	entries := p.Packages
+	for _, r := range p.Releases {
+		if r.Release == release {
+			entries = append(append([]string{}, entries...), r.Packages...)
+		}
+	}
+
+	edits, err := parse(entries, conf.ConfPath, make(map[string]bool))
+	if err != nil {
+		return fmt.Errorf("package profile '%s': %w", name, err)
+	}
+
+	apply(s, edits)
+	return nil
+}
+
+// Resolve returns the sorted packages of profile for release with packages applied
+// on top. packages is a ',' separated list of NAME, +NAME, -NAME and @FILE entries.
+// Without a profile, plain names replace the default profile as -p always did.
+func Resolve(c *conf.Config, profile string, release string, packages string) ([]string, error) {
+	edits, err := parse(strings.Split(packages, ","), ".", make(map[string]bool))
+	if err != nil {
+		return nil, err
+	}
+
+	if profile == "" {
+		profile = conf.DefaultPackageProfile
+		for _, e := range edits {
+			if e.plain {
+				profile = ""
+				break
+			}
+		}
+	}
+
+	s := set.New()
+	if profile != "" {
+		if err := resolve(c, &s, profile, release, make(map[string]bool)); err != nil {
+			return nil, err
+		}
+	}
+	apply(&s, edits)
+
+	var r []string
+	for p := range s.M {
+		r = append(r, p)
+	}
+	sort.Strings(r)
+
+	if len(r) == 0 {
+		return nil, errdefs.New(errdefs.ErrInvalid, "no packages left to install")
+	}
+
+	return r, nil
+}
+
+// Packages is Resolve joined by ',' as expected by container.Build.
+func Packages(c *conf.Config, profile string, release string, packages string) (string, error) {
+	r, err := Resolve(c, profile, release, packages)
+	if err != nil {
+		return "", err
+	}
+
+	return strings.Join(r, ","), nil
+}
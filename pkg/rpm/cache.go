@@ -0,0 +1,261 @@
+// SPDX-License-Identifier: BSD-2
+// Copyright 2023 VMware, Inc.
+
+package rpm
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"io/fs"
+	"os"
+	"path"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"golang.org/x/sys/unix"
+
+	"github.com/vmware-samples/photon-os-container-builder/pkg/conf"
+	"github.com/vmware-samples/photon-os-container-builder/pkg/errdefs"
+	"github.com/vmware-samples/photon-os-container-builder/pkg/lock"
+	"github.com/vmware-samples/photon-os-container-builder/pkg/set"
+	"github.com/vmware-samples/photon-os-container-builder/pkg/system"
+)
+
+const (
+	tdnfCacheDir = "var/cache/tdnf"
+)
+
+type CacheEntry struct {
+	Release  string `json:"release"`
+	Path     string `json:"path"`
+	Packages int    `json:"packages"`
+	Size     int64  `json:"size"`
+}
+
+// tdnf resolves its cachedir relative to the installroot, so the shared cache is
+// bind mounted over the target's own cache for the duration of the transaction.
+// Concurrent transactions would download into the cache at the same time, so it is
+// locked until it is unmounted. The rootfs must not be removed while the cache is
+// mounted, which would remove the shared cache with it.
+func mountCache(c *conf.Config, release string, target string) (func() error, error) {
+	if c.Cache.Dir == "" {
+		return func() error { return nil }, nil
+	}
+
+	if release == "" {
+		release = conf.DefaultReleaseVersion
+	}
+
+	l, err := lock.Cache(context.Background(), release, "install")
+	if err != nil {
+		return nil, err
+	}
+
+	src := path.Join(c.Cache.Dir, release)
+	dst := path.Join(target, tdnfCacheDir)
+
+	for _, d := range []string{src, dst} {
+		if err := system.MkdirAll(d, 0755); err != nil {
+			l.Release()
+			return nil, err
+		}
+	}
+
+	if err := system.BindMount(src, dst); err != nil {
+		l.Release()
+		return nil, fmt.Errorf("failed to bind mount package cache '%s': %w", src, err)
+	}
+
+	return func() error {
+		defer l.Release()
+		if err := system.Unmount(dst, unix.MNT_DETACH); err != nil {
+			return fmt.Errorf("failed to unmount package cache from '%s': %w", dst, err)
+		}
+		return nil
+	}, nil
+}
+
+// UnmountCache unmounts the shared cache from root, where an install that was killed
+// left it mounted. It is called before root is removed.
+func UnmountCache(root string) error {
+	dst := path.Join(root, tdnfCacheDir)
+	if !system.MountPoint(dst) {
+		return nil
+	}
+
+	if err := system.Unmount(dst, unix.MNT_DETACH); err != nil {
+		return fmt.Errorf("failed to unmount package cache from '%s': %w", dst, err)
+	}
+
+	return nil
+}
+
+func tdnfArgs(c *conf.Config, release string, target string) []string {
+	if release == "" {
+		release = conf.DefaultReleaseVersion
+	}
+
+	args := []string{"--releasever=" + release, "--installroot", target}
+	if c.GPG.Check {
+		args = append(args, "--setopt=gpgcheck=1")
+	}
+
+	if c.Repos.Dir != "" {
+		args = append(args, "--setopt=reposdir="+c.Repos.Dir)
+	}
+	for _, r := range c.Repos.Disable {
+		args = append(args, "--disablerepo="+r)
+	}
+	for _, r := range c.Repos.Enable {
+		args = append(args, "--enablerepo="+r)
+	}
+
+	if c.Cache.Dir != "" {
+		args = append(args, "--setopt=keepcache=1")
+		if c.Cache.Offline {
+			args = append(args, "--cacheonly")
+		}
+	}
+
+	return args
+}
+
+func ListCache(dir string) ([]CacheEntry, error) {
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil, nil
+		}
+		return nil, err
+	}
+
+	var r []CacheEntry
+	for _, e := range entries {
+		if !e.IsDir() || strings.HasPrefix(e.Name(), ".") {
+			continue
+		}
+
+		c := CacheEntry{
+			Release: e.Name(),
+			Path:    path.Join(dir, e.Name()),
+		}
+
+		filepath.WalkDir(c.Path, func(f string, d fs.DirEntry, err error) error {
+			if err != nil || d.IsDir() {
+				return nil
+			}
+
+			if info, err := d.Info(); err == nil {
+				c.Size += info.Size()
+			}
+			if strings.HasSuffix(f, ".rpm") {
+				c.Packages++
+			}
+			return nil
+		})
+
+		r = append(r, c)
+	}
+
+	sort.Slice(r, func(i, j int) bool { return r[i].Release < r[j].Release })
+	return r, nil
+}
+
+// PruneCache removes cached RPMs not modified within olderThan. An empty release
+// prunes all releases, a zero duration removes every cached package.
+func PruneCache(dir string, release string, olderThan time.Duration) (int, int64, error) {
+	root := dir
+	if release != "" {
+		root = path.Join(dir, release)
+	}
+
+	if !system.PathExists(root) {
+		return 0, 0, nil
+	}
+
+	n, size := 0, int64(0)
+	cutoff := time.Now().Add(-olderThan)
+	err := filepath.WalkDir(root, func(f string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+
+		if d.IsDir() || !strings.HasSuffix(f, ".rpm") {
+			return nil
+		}
+
+		info, err := d.Info()
+		if err != nil {
+			return err
+		}
+
+		if olderThan > 0 && info.ModTime().After(cutoff) {
+			return nil
+		}
+
+		if err := system.Remove(f); err != nil {
+			return err
+		}
+
+		n++
+		size += info.Size()
+		return nil
+	})
+
+	return n, size, err
+}
+
+// WarmCache downloads packages and their dependencies into the shared cache of
+// release by resolving them against a scratch root.
+func WarmCache(c *conf.Config, w io.Writer, release string, packages set.Set) error {
+	if c.Cache.Dir == "" {
+		return fmt.Errorf("package cache directory is not configured")
+	}
+
+	// The scratch root only exists for the transaction
+	target := path.Join(c.Cache.Dir, ".warm-"+strconv.Itoa(os.Getpid()))
+	if err := system.MkdirAll(target, 0755); err != nil {
+		return err
+	}
+	defer func() {
+		if UnmountCache(target) == nil {
+			system.RemoveAll(target)
+		}
+	}()
+
+	if err := prepareOSTree(c, w, release, target); err != nil {
+		return err
+	}
+
+	umount, err := mountCache(c, release, target)
+	if err != nil {
+		return err
+	}
+
+	online := *c
+	online.Cache.Offline = false
+
+	args := append(tdnfArgs(&online, release, target), "install", "--downloadonly", "-y")
+	for pkg := range packages.M {
+		if pkg == "" {
+			continue
+		}
+
+		name, version := ParsePin(pkg)
+		if version != "" {
+			name += "-" + version
+		}
+		args = append(args, name)
+	}
+
+	if err := system.ExecAndStream(w, TDNFCli, args...); err != nil {
+		umount()
+		return errdefs.Errorf(errdefs.ErrPackageManager, "failed to download packages into cache: %w", err)
+	}
+
+	return umount()
+}
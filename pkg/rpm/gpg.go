@@ -0,0 +1,153 @@
+// SPDX-License-Identifier: BSD-2
+// Copyright 2023 VMware, Inc.
+
+package rpm
+
+import (
+	"fmt"
+	"io"
+	"os"
+	"strings"
+
+	"github.com/vmware-samples/photon-os-container-builder/pkg/conf"
+	"github.com/vmware-samples/photon-os-container-builder/pkg/errdefs"
+	"github.com/vmware-samples/photon-os-container-builder/pkg/system"
+)
+
+const (
+	signatureFormat = "%{NAME}-%{EPOCH}:%{VERSION}-%{RELEASE}.%{ARCH}\\t%{RSAHEADER:pgpsig}\\t%{DSAHEADER:pgpsig}\\t%{SIGPGP:pgpsig}\\t%{SIGGPG:pgpsig}\\n"
+)
+
+type SignatureIssue struct {
+	Package string `json:"package"`
+	KeyID   string `json:"key_id,omitempty"`
+	Reason  string `json:"reason"`
+}
+
+func releaseKeys(c *conf.Config, release string) ([]string, error) {
+	if release == "" {
+		release = conf.DefaultReleaseVersion
+	}
+
+	keys := c.ReleaseGPGKeys(release)
+	if len(keys) == 0 {
+		return nil, errdefs.Errorf(errdefs.ErrInvalid, "no GPG keys configured for release '%s', add a [[GPG.Releases]] entry with Release=\"%s\" and its Keys", release, release)
+	}
+
+	for _, k := range keys {
+		if !system.PathExists(k) {
+			return nil, fmt.Errorf("GPG key '%s' for release '%s' not found", k, release)
+		}
+	}
+
+	return keys, nil
+}
+
+func importGPGKey(c *conf.Config, w io.Writer, release string, target string) error {
+	keys, err := releaseKeys(c, release)
+	if err != nil {
+		return fmt.Errorf("failed to find RPM GPG keys: %w", err)
+	}
+
+	for _, key := range keys {
+		if err := system.ExecAndDisplay(w, RPMCli, "--root", target, "--import", key); err != nil {
+			return errdefs.Errorf(errdefs.ErrPackageManager, "failed to import GPG key '%s': %w", key, err)
+		}
+	}
+
+	return nil
+}
+
+func importedKeyIDs(root string) ([]string, error) {
+	out, err := system.ExecAndCapture(RPMCli, "--root", root, "-q", "gpg-pubkey", "--queryformat", "%{VERSION}\\n")
+	if err != nil {
+		return nil, errdefs.Wrap(errdefs.ErrPackageManager, err)
+	}
+
+	var ids []string
+	for _, l := range strings.Fields(out) {
+		ids = append(ids, strings.ToLower(l))
+	}
+
+	return ids, nil
+}
+
+// ReleaseKeyIDs returns the short IDs of the keys trusted for release. The keys are
+// imported into a scratch RPM database to let rpm compute the IDs.
+func ReleaseKeyIDs(c *conf.Config, release string) ([]string, error) {
+	root, err := os.MkdirTemp("", "cntrctl-gpg-")
+	if err != nil {
+		return nil, err
+	}
+	defer os.RemoveAll(root)
+
+	if err := prepareOSTree(c, io.Discard, release, root); err != nil {
+		return nil, err
+	}
+
+	return importedKeyIDs(root)
+}
+
+// Release returns the Photon OS release installed in root.
+func Release(root string) (string, error) {
+	out, err := system.ExecAndCapture(RPMCli, "--root", root, "-q", "photon-release", "--queryformat", "%{VERSION}")
+	if err != nil {
+		return "", errdefs.Errorf(errdefs.ErrPackageManager, "photon-release is not installed: %w", err)
+	}
+
+	return strings.TrimSpace(out), nil
+}
+
+func signatureKeyID(sig string) string {
+	_, id, ok := strings.Cut(sig, "Key ID ")
+	if !ok {
+		return ""
+	}
+
+	return strings.ToLower(strings.TrimSpace(id))
+}
+
+// VerifySignatures reports every package in root that is unsigned or signed by a
+// key outside of keyIDs.
+func VerifySignatures(root string, keyIDs []string) ([]SignatureIssue, error) {
+	out, err := system.ExecAndCapture(RPMCli, "--root", root, "-qa", "--queryformat", signatureFormat)
+	if err != nil {
+		return nil, errdefs.Wrap(errdefs.ErrPackageManager, err)
+	}
+
+	var issues []SignatureIssue
+	for _, line := range strings.Split(out, "\n") {
+		f := strings.Split(line, "\t")
+		if len(f) != 5 || strings.HasPrefix(f[0], "gpg-pubkey-") {
+			continue
+		}
+
+		name := strings.Replace(f[0], "-(none):", "-", 1)
+
+		id := ""
+		for _, sig := range f[1:] {
+			if id = signatureKeyID(sig); id != "" {
+				break
+			}
+		}
+
+		if id == "" {
+			issues = append(issues, SignatureIssue{Package: name, Reason: "unsigned"})
+			continue
+		}
+
+		trusted := false
+		for _, k := range keyIDs {
+			if strings.HasSuffix(id, k) {
+				trusted = true
+				break
+			}
+		}
+
+		if !trusted {
+			issues = append(issues, SignatureIssue{Package: name, KeyID: id, Reason: "unexpected key"})
+		}
+	}
+
+	return issues, nil
+}
@@ -0,0 +1,155 @@
+// SPDX-License-Identifier: BSD-2
+// Copyright 2023 VMware, Inc.
+
+package rpm
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"path"
+	"strings"
+	"time"
+
+	"github.com/vmware-samples/photon-os-container-builder/pkg/system"
+)
+
+type LockedPackage struct {
+	Name    string `json:"name"`
+	Epoch   string `json:"epoch,omitempty"`
+	Version string `json:"version"`
+	Release string `json:"release"`
+	Arch    string `json:"arch"`
+}
+
+type Lockfile struct {
+	Release   string          `json:"release"`
+	Generated string          `json:"generated"`
+	Packages  []LockedPackage `json:"packages"`
+}
+
+func (l *LockedPackage) toPackage() Package {
+	return Package{
+		Name:    l.Name,
+		Epoch:   l.Epoch,
+		Version: l.Version,
+		Release: l.Release,
+		Arch:    l.Arch,
+	}
+}
+
+// Spec returns the name-[epoch:]version-release.arch form understood by tdnf.
+func (l *LockedPackage) Spec() string {
+	evr := l.Version + "-" + l.Release
+	if l.Epoch != "" && l.Epoch != "0" {
+		evr = l.Epoch + ":" + evr
+	}
+
+	return l.Name + "-" + evr + "." + l.Arch
+}
+
+func NewLockfile(release string, pkgs []Package) *Lockfile {
+	l := Lockfile{
+		Release:   release,
+		Generated: time.Now().UTC().Format(time.RFC3339),
+	}
+
+	for _, p := range pkgs {
+		e := p.Epoch
+		if e == "0" {
+			e = ""
+		}
+
+		l.Packages = append(l.Packages, LockedPackage{
+			Name:    p.Name,
+			Epoch:   e,
+			Version: p.Version,
+			Release: p.Release,
+			Arch:    p.Arch,
+		})
+	}
+
+	return &l
+}
+
+func LoadLockfile(file string) (*Lockfile, error) {
+	b, err := os.ReadFile(file)
+	if err != nil {
+		return nil, err
+	}
+
+	l := Lockfile{}
+	if err := json.Unmarshal(b, &l); err != nil {
+		return nil, fmt.Errorf("invalid lockfile '%s': %w", file, err)
+	}
+
+	if len(l.Packages) == 0 {
+		return nil, fmt.Errorf("lockfile '%s' has no packages", file)
+	}
+
+	return &l, nil
+}
+
+func (l *Lockfile) Save(file string) error {
+	if err := system.MkdirAll(path.Dir(file), 0755); err != nil {
+		return err
+	}
+
+	b, err := json.MarshalIndent(l, "", "  ")
+	if err != nil {
+		return err
+	}
+
+	return system.WriteFile(file, append(b, '\n'), 0644)
+}
+
+func (l *Lockfile) Specs() []string {
+	var s []string
+	for i := range l.Packages {
+		s = append(s, l.Packages[i].Spec())
+	}
+
+	return s
+}
+
+// Verify checks that the RPM database of root holds exactly the locked set.
+func (l *Lockfile) Verify(root string) error {
+	installed, err := QueryPackages(root)
+	if err != nil {
+		return err
+	}
+
+	var locked []Package
+	for i := range l.Packages {
+		locked = append(locked, l.Packages[i].toPackage())
+	}
+
+	d := DiffPackages(locked, installed)
+	if len(d.Added) == 0 && len(d.Removed) == 0 && len(d.Changed) == 0 {
+		return nil
+	}
+
+	var m []string
+	for _, p := range d.Added {
+		m = append(m, "unexpected "+p.NEVRA())
+	}
+	for _, p := range d.Removed {
+		m = append(m, "missing "+p.NEVRA())
+	}
+	for _, c := range d.Changed {
+		m = append(m, "expected "+c.From.NEVRA()+" got "+c.To.NEVRA())
+	}
+
+	return fmt.Errorf("installed packages do not match lockfile: %s", strings.Join(m, ", "))
+}
+
+// ParsePin splits a 'name=version' package pin. Packages without a pin are
+// returned with an empty version.
+func ParsePin(pkg string) (string, string) {
+	name, version, ok := strings.Cut(pkg, "=")
+	if !ok {
+		return pkg, ""
+	}
+
+	return strings.TrimSpace(name), strings.TrimSpace(version)
+}
@@ -0,0 +1,129 @@
+// SPDX-License-Identifier: BSD-2
+// Copyright 2023 VMware, Inc.
+
+package rpm
+
+import (
+	"path"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/vmware-samples/photon-os-container-builder/pkg/system"
+)
+
+func TestParsePin(t *testing.T) {
+	tests := []struct {
+		in      string
+		name    string
+		version string
+	}{
+		{"bash", "bash", ""},
+		{"bash=5.2.15", "bash", "5.2.15"},
+		{"bash = 5.2.15-1.ph5", "bash", "5.2.15-1.ph5"},
+		{"openssl=1:3.0.9", "openssl", "1:3.0.9"},
+		{"bash=", "bash", ""},
+	}
+
+	for _, tt := range tests {
+		name, version := ParsePin(tt.in)
+		if name != tt.name || version != tt.version {
+			t.Errorf("ParsePin(%q) = %q, %q, want %q, %q", tt.in, name, version, tt.name, tt.version)
+		}
+	}
+}
+
+func TestLockedPackageSpec(t *testing.T) {
+	tests := []struct {
+		p    LockedPackage
+		want string
+	}{
+		{LockedPackage{Name: "bash", Version: "5.2.15", Release: "1.ph5", Arch: "x86_64"}, "bash-5.2.15-1.ph5.x86_64"},
+		{LockedPackage{Name: "bash", Epoch: "0", Version: "5.2.15", Release: "1.ph5", Arch: "x86_64"}, "bash-5.2.15-1.ph5.x86_64"},
+		{LockedPackage{Name: "openssl", Epoch: "1", Version: "3.0.9", Release: "2.ph5", Arch: "x86_64"}, "openssl-1:3.0.9-2.ph5.x86_64"},
+	}
+
+	for _, tt := range tests {
+		if got := tt.p.Spec(); got != tt.want {
+			t.Errorf("Spec() = %q, want %q", got, tt.want)
+		}
+	}
+}
+
+func TestLockfileSaveLoad(t *testing.T) {
+	l := NewLockfile("5.0", []Package{
+		{Name: "bash", Epoch: "0", Version: "5.2.15", Release: "1.ph5", Arch: "x86_64"},
+		{Name: "openssl", Epoch: "1", Version: "3.0.9", Release: "2.ph5", Arch: "x86_64"},
+	})
+
+	f := path.Join(t.TempDir(), "lockfiles", "web.json")
+	if err := l.Save(f); err != nil {
+		t.Fatal(err)
+	}
+
+	got, err := LoadLockfile(f)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(got, l) {
+		t.Errorf("LoadLockfile() = %+v, want %+v", got, l)
+	}
+	if got.Packages[0].Epoch != "" {
+		t.Errorf("epoch 0 is recorded as %q", got.Packages[0].Epoch)
+	}
+}
+
+// queryOutput answers the package query of root from the recording executor.
+func queryOutput(e *system.RecordingExecutor, root string, pkgs ...string) {
+	key := system.CommandLine(RPMCli, "--root", root, "-qa", "--queryformat", queryFormat)
+	e.Output[key] = strings.Join(pkgs, "\n") + "\n"
+}
+
+func TestLockfileVerify(t *testing.T) {
+	l := &Lockfile{Release: "5.0", Packages: []LockedPackage{
+		{Name: "bash", Version: "5.2.15", Release: "1.ph5", Arch: "x86_64"},
+		{Name: "openssl", Epoch: "1", Version: "3.0.9", Release: "2.ph5", Arch: "x86_64"},
+	}}
+
+	bash := "bash\t0\t5.2.15\t1.ph5\tx86_64\tGPLv3\tbash-5.2.15-1.ph5.src.rpm"
+	openssl := "openssl\t1\t3.0.9\t2.ph5\tx86_64\tASL 2.0\topenssl-3.0.9-2.ph5.src.rpm"
+
+	tests := []struct {
+		name      string
+		installed []string
+		errs      []string
+	}{
+		{"exact", []string{bash, openssl}, nil},
+		{"missing", []string{bash}, []string{"missing openssl-1:3.0.9-2.ph5.x86_64"}},
+		{"unexpected", []string{bash, openssl, "vim\t0\t9.0\t1.ph5\tx86_64\tVim\tvim.src.rpm"}, []string{"unexpected vim-9.0-1.ph5.x86_64"}},
+		{"changed", []string{"bash\t0\t5.2.21\t1.ph5\tx86_64\tGPLv3\tbash.src.rpm", openssl},
+			[]string{"expected bash-5.2.15-1.ph5.x86_64 got bash-5.2.21-1.ph5.x86_64"}},
+		{"epoch", []string{bash, "openssl\t0\t3.0.9\t2.ph5\tx86_64\tASL 2.0\topenssl.src.rpm"},
+			[]string{"expected openssl-1:3.0.9-2.ph5.x86_64 got openssl-3.0.9-2.ph5.x86_64"}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			e := system.NewRecordingExecutor()
+			defer system.SetExecutor(system.SetExecutor(e))
+
+			queryOutput(e, "/root", tt.installed...)
+			err := l.Verify("/root")
+			if len(tt.errs) == 0 {
+				if err != nil {
+					t.Fatalf("Verify() = %v", err)
+				}
+				return
+			}
+
+			if err == nil {
+				t.Fatal("Verify() succeeded")
+			}
+			for _, m := range tt.errs {
+				if !strings.Contains(err.Error(), m) {
+					t.Errorf("Verify() = %v, want %q", err, m)
+				}
+			}
+		})
+	}
+}
@@ -0,0 +1,145 @@
+// SPDX-License-Identifier: BSD-2
+// Copyright 2023 VMware, Inc.
+
+package rpm
+
+import (
+	"sort"
+	"strings"
+
+	"github.com/vmware-samples/photon-os-container-builder/pkg/errdefs"
+	"github.com/vmware-samples/photon-os-container-builder/pkg/system"
+)
+
+const (
+	queryFormat = "%{NAME}\\t%{EPOCHNUM}\\t%{VERSION}\\t%{RELEASE}\\t%{ARCH}\\t%{LICENSE}\\t%{SOURCERPM}\\n"
+)
+
+type Package struct {
+	Name      string `json:"name"`
+	Epoch     string `json:"epoch"`
+	Version   string `json:"version"`
+	Release   string `json:"release"`
+	Arch      string `json:"arch"`
+	License   string `json:"license"`
+	SourceRPM string `json:"sourcerpm"`
+}
+
+type Change struct {
+	Name string  `json:"name"`
+	From Package `json:"from"`
+	To   Package `json:"to"`
+}
+
+type Diff struct {
+	Added   []Package `json:"added"`
+	Removed []Package `json:"removed"`
+	Changed []Change  `json:"changed"`
+}
+
+func (p *Package) EVR() string {
+	if p.Epoch != "" && p.Epoch != "0" {
+		return p.Epoch + ":" + p.Version + "-" + p.Release
+	}
+
+	return p.Version + "-" + p.Release
+}
+
+func (p *Package) NEVRA() string {
+	return p.Name + "-" + p.EVR() + "." + p.Arch
+}
+
+func QueryPackages(root string) ([]Package, error) {
+	out, err := system.ExecAndCapture(RPMCli, "--root", root, "-qa", "--queryformat", queryFormat)
+	if err != nil {
+		return nil, errdefs.Wrap(errdefs.ErrPackageManager, err)
+	}
+
+	var pkgs []Package
+	for _, line := range strings.Split(out, "\n") {
+		f := strings.Split(line, "\t")
+		if len(f) != 7 || f[0] == "gpg-pubkey" {
+			continue
+		}
+
+		pkgs = append(pkgs, Package{
+			Name:      f[0],
+			Epoch:     f[1],
+			Version:   f[2],
+			Release:   f[3],
+			Arch:      f[4],
+			License:   f[5],
+			SourceRPM: f[6],
+		})
+	}
+
+	if len(pkgs) == 0 {
+		return nil, errdefs.Errorf(errdefs.ErrNotFound, "no packages found in '%s'", root)
+	}
+
+	sort.Slice(pkgs, func(i, j int) bool {
+		if pkgs[i].Name == pkgs[j].Name {
+			return pkgs[i].Arch < pkgs[j].Arch
+		}
+		return pkgs[i].Name < pkgs[j].Name
+	})
+
+	return pkgs, nil
+}
+
+// DiffPackages compares two package sets. Packages are matched by name, arch and
+// EVR, so every installed version of a package such as kernel counts. A name and
+// arch with exactly one version gone and one new is reported as changed.
+func DiffPackages(old []Package, new []Package) *Diff {
+	name := func(p *Package) string {
+		return p.Name + "." + p.Arch
+	}
+	key := func(p *Package) string {
+		return name(p) + "-" + p.EVR()
+	}
+
+	o := make(map[string]bool)
+	for _, p := range old {
+		o[key(&p)] = true
+	}
+	n := make(map[string]bool)
+	for _, p := range new {
+		n[key(&p)] = true
+	}
+
+	added := make(map[string][]Package)
+	var names []string
+	for _, p := range new {
+		if !o[key(&p)] {
+			if added[name(&p)] == nil {
+				names = append(names, name(&p))
+			}
+			added[name(&p)] = append(added[name(&p)], p)
+		}
+	}
+	removed := make(map[string][]Package)
+	for _, p := range old {
+		if !n[key(&p)] {
+			removed[name(&p)] = append(removed[name(&p)], p)
+		}
+	}
+
+	d := Diff{}
+	for _, k := range names {
+		if a, r := added[k], removed[k]; len(a) == 1 && len(r) == 1 {
+			d.Changed = append(d.Changed, Change{Name: a[0].Name, From: r[0], To: a[0]})
+			continue
+		}
+		d.Added = append(d.Added, added[k]...)
+	}
+
+	for _, p := range old {
+		k := name(&p)
+		if n[key(&p)] || len(added[k]) == 1 && len(removed[k]) == 1 {
+			continue
+		}
+		d.Removed = append(d.Removed, p)
+	}
+
+	return &d
+}
@@ -0,0 +1,129 @@
+// SPDX-License-Identifier: BSD-2
+// Copyright 2023 VMware, Inc.
+
+package rpm
+
+import (
+	"errors"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/vmware-samples/photon-os-container-builder/pkg/errdefs"
+	"github.com/vmware-samples/photon-os-container-builder/pkg/system"
+)
+
+// pkg returns package name of arch with release 1.ph5, ev is [epoch:]version.
+func pkg(name string, ev string, arch string) Package {
+	p := Package{Name: name, Version: ev, Release: "1.ph5", Arch: arch}
+	if e, v, ok := strings.Cut(ev, ":"); ok {
+		p.Epoch, p.Version = e, v
+	}
+	return p
+}
+
+func nevras(pkgs []Package) []string {
+	var r []string
+	for i := range pkgs {
+		r = append(r, pkgs[i].NEVRA())
+	}
+	return r
+}
+
+func changes(c []Change) []string {
+	var r []string
+	for _, x := range c {
+		r = append(r, x.From.NEVRA()+" -> "+x.To.NEVRA())
+	}
+	return r
+}
+
+func TestDiffPackages(t *testing.T) {
+	tests := []struct {
+		name     string
+		old, new []Package
+		added    []string
+		removed  []string
+		changed  []string
+	}{
+		{
+			name: "equal",
+			old:  []Package{pkg("bash", "5.2", "x86_64")},
+			new:  []Package{pkg("bash", "5.2", "x86_64")},
+		},
+		{
+			name:    "added and removed",
+			old:     []Package{pkg("bash", "5.2", "x86_64"), pkg("vim", "9.0", "x86_64")},
+			new:     []Package{pkg("bash", "5.2", "x86_64"), pkg("curl", "8.1", "x86_64")},
+			added:   []string{"curl-8.1-1.ph5.x86_64"},
+			removed: []string{"vim-9.0-1.ph5.x86_64"},
+		},
+		{
+			name:    "changed",
+			old:     []Package{pkg("bash", "5.2", "x86_64")},
+			new:     []Package{pkg("bash", "5.3", "x86_64")},
+			changed: []string{"bash-5.2-1.ph5.x86_64 -> bash-5.3-1.ph5.x86_64"},
+		},
+		{
+			name:    "epoch only",
+			old:     []Package{pkg("openssl", "3.0", "x86_64")},
+			new:     []Package{pkg("openssl", "1:3.0", "x86_64")},
+			changed: []string{"openssl-3.0-1.ph5.x86_64 -> openssl-1:3.0-1.ph5.x86_64"},
+		},
+		{
+			name:  "other arch",
+			old:   []Package{pkg("glibc", "2.38", "x86_64")},
+			new:   []Package{pkg("glibc", "2.38", "x86_64"), pkg("glibc", "2.38", "i686")},
+			added: []string{"glibc-2.38-1.ph5.i686"},
+		},
+		{
+			// Kernels are installed side by side, every version counts
+			name:    "several versions",
+			old:     []Package{pkg("linux", "6.1.10", "x86_64"), pkg("linux", "6.1.20", "x86_64")},
+			new:     []Package{pkg("linux", "6.1.20", "x86_64"), pkg("linux", "6.1.30", "x86_64"), pkg("linux", "6.1.40", "x86_64")},
+			added:   []string{"linux-6.1.30-1.ph5.x86_64", "linux-6.1.40-1.ph5.x86_64"},
+			removed: []string{"linux-6.1.10-1.ph5.x86_64"},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			d := DiffPackages(tt.old, tt.new)
+			if got := nevras(d.Added); !reflect.DeepEqual(got, tt.added) {
+				t.Errorf("Added = %q, want %q", got, tt.added)
+			}
+			if got := nevras(d.Removed); !reflect.DeepEqual(got, tt.removed) {
+				t.Errorf("Removed = %q, want %q", got, tt.removed)
+			}
+			if got := changes(d.Changed); !reflect.DeepEqual(got, tt.changed) {
+				t.Errorf("Changed = %q, want %q", got, tt.changed)
+			}
+		})
+	}
+}
+
+func TestQueryPackages(t *testing.T) {
+	e := system.NewRecordingExecutor()
+	defer system.SetExecutor(system.SetExecutor(e))
+
+	queryOutput(e, "/root",
+		"zlib\t0\t1.3\t1.ph5\tx86_64\tzlib\tzlib-1.3-1.ph5.src.rpm",
+		"gpg-pubkey\t0\t8a6a826d\t4c5b1ab0\t(none)\tpubkey\t(none)",
+		"bash\t0\t5.2\t1.ph5\tx86_64\tGPLv3+\tbash-5.2-1.ph5.src.rpm")
+
+	pkgs, err := QueryPackages("/root")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got, want := nevras(pkgs), []string{"bash-5.2-1.ph5.x86_64", "zlib-1.3-1.ph5.x86_64"}; !reflect.DeepEqual(got, want) {
+		t.Errorf("QueryPackages() = %q, want %q", got, want)
+	}
+	if pkgs[0].License != "GPLv3+" || pkgs[0].SourceRPM != "bash-5.2-1.ph5.src.rpm" {
+		t.Errorf("unexpected package %+v", pkgs[0])
+	}
+
+	queryOutput(e, "/empty")
+	if _, err := QueryPackages("/empty"); !errors.Is(err, errdefs.ErrNotFound) {
+		t.Errorf("QueryPackages() of an empty root = %v, want kind not-found", err)
+	}
+}
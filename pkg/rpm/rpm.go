@@ -4,10 +4,10 @@
 package rpm
 
 import (
-	"fmt"
-	"os"
+	"io"
 
 	"github.com/vmware-samples/photon-os-container-builder/pkg/conf"
+	"github.com/vmware-samples/photon-os-container-builder/pkg/errdefs"
 	"github.com/vmware-samples/photon-os-container-builder/pkg/set"
 	"github.com/vmware-samples/photon-os-container-builder/pkg/system"
 )
@@ -17,61 +17,123 @@ const (
 	TDNFCli = "/usr/bin/tdnf"
 )
 
-func ConstructOSTree(release string, target string, packages set.Set) error {
-	if err := initRPMDB(target); err != nil {
+// ConstructOSTree installs packages of release into target, passing opts on to tdnf.
+// Output of rpm and tdnf is written to w.
+func ConstructOSTree(c *conf.Config, w io.Writer, release string, target string, packages set.Set, opts []string) error {
+	if err := prepareOSTree(c, w, release, target); err != nil {
 		return err
 	}
 
-	if err := importGPGKey(target); err != nil {
+	umount, err := mountCache(c, release, target)
+	if err != nil {
 		return err
 	}
 
-	if err := installPackages(release, target, packages); err != nil {
+	if err := installPackages(c, w, release, target, packages, opts); err != nil {
+		umount()
 		return err
 	}
 
-	if err := system.RecursiveChmod(target, 0755); err != nil {
+	// The cache is unmounted first, the modes of the shared cache are left alone
+	if err := umount(); err != nil {
 		return err
 	}
 
-	return nil
+	return system.RecursiveChmod(target, 0755)
 }
 
-func installPackages(release string, target string, packages set.Set) error {
-	if release == "" {
-		release = "--releasever=" + conf.DefaultReleaseVersion
-	} else {
-		release = "--releasever=" + release
+func ConstructOSTreeFromLock(c *conf.Config, w io.Writer, target string, l *Lockfile, opts []string) error {
+	if err := prepareOSTree(c, w, l.Release, target); err != nil {
+		return err
 	}
-	for pkg := range packages.M {
-		system.ExecAndShowProgress(TDNFCli, release, "--installroot", target, "install", pkg, "-y")
+
+	umount, err := mountCache(c, l.Release, target)
+	if err != nil {
+		return err
 	}
 
-	return nil
-}
+	args := append(append(tdnfArgs(c, l.Release, target), opts...), "install", "-y")
+	if err := system.ExecAndStream(w, TDNFCli, append(args, l.Specs()...)...); err != nil {
+		umount()
+		return errdefs.Errorf(errdefs.ErrPackageManager, "failed to install locked packages: %w", err)
+	}
 
-func importGPGKey(target string) error {
-	keys, err := system.FilePathWalkDir(conf.DefaultGPGDir)
-	if err != nil {
-		fmt.Printf("Failed to find any RPM GPG keys in '%s': %+v\n", conf.DefaultGPGDir, err)
+	if err := umount(); err != nil {
 		return err
 	}
 
-	for key := range keys {
-		if err := system.ExecAndDisplay(os.Stdout, RPMCli, "--root", target, "--import", key); err != nil {
-			fmt.Printf("Failed to import GPG key '%s'': %+v\n", key, err)
+	// Nothing was installed to verify in dry-run mode
+	if !system.DryRun() {
+		if err := l.Verify(target); err != nil {
 			return err
 		}
 	}
 
-	return nil
+	return system.RecursiveChmod(target, 0755)
 }
 
-func initRPMDB(target string) error {
-	if err := system.ExecAndDisplay(os.Stdout, RPMCli, "--root", target, "--initdb"); err != nil {
-		fmt.Printf("Failed to init RPM db': %+v\n", err)
+func prepareOSTree(c *conf.Config, w io.Writer, release string, target string) error {
+	if err := initRPMDB(w, target); err != nil {
 		return err
 	}
 
+	return importGPGKey(c, w, release, target)
+}
+
+func installPackages(c *conf.Config, w io.Writer, release string, target string, packages set.Set, opts []string) error {
+	args := append(tdnfArgs(c, release, target), opts...)
+	for pkg := range packages.M {
+		if pkg == "" {
+			continue
+		}
+
+		name, version := ParsePin(pkg)
+		if version == "" {
+			if err := system.ExecAndStream(w, TDNFCli, append(args, "install", name, "-y")...); err != nil {
+				return errdefs.Errorf(errdefs.ErrPackageManager, "failed to install package '%s': %w", name, err)
+			}
+			continue
+		}
+
+		if err := system.ExecAndStream(w, TDNFCli, append(args, "install", name+"-"+version, "-y")...); err != nil {
+			return errdefs.Errorf(errdefs.ErrPackageManager, "failed to install pinned package '%s=%s': %w", name, version, err)
+		}
+	}
+
+	return nil
+}
+
+// UpdateLockfile updates the packages of an existing root and returns a lockfile of
+// the resulting set.
+func UpdateLockfile(c *conf.Config, w io.Writer, release string, target string, update bool) (*Lockfile, error) {
+	if update {
+		umount, err := mountCache(c, release, target)
+		if err != nil {
+			return nil, err
+		}
+
+		if err := system.ExecAndStream(w, TDNFCli, append(tdnfArgs(c, release, target), "update", "-y")...); err != nil {
+			umount()
+			return nil, errdefs.Errorf(errdefs.ErrPackageManager, "failed to update packages: %w", err)
+		}
+
+		if err := umount(); err != nil {
+			return nil, err
+		}
+	}
+
+	pkgs, err := QueryPackages(target)
+	if err != nil {
+		return nil, err
+	}
+
+	return NewLockfile(release, pkgs), nil
+}
+
+func initRPMDB(w io.Writer, target string) error {
+	if err := system.ExecAndDisplay(w, RPMCli, "--root", target, "--initdb"); err != nil {
+		return errdefs.Errorf(errdefs.ErrPackageManager, "failed to init RPM db: %w", err)
+	}
+
 	return nil
 }
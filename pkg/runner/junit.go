@@ -0,0 +1,134 @@
+// SPDX-License-Identifier: BSD-2
+// Copyright 2023 VMware, Inc.
+
+package runner
+
+import (
+	"encoding/json"
+	"encoding/xml"
+	"fmt"
+	"io"
+	"os"
+	"time"
+)
+
+const (
+	maxOutput = 64 * 1024
+)
+
+type junitProperty struct {
+	Name  string `xml:"name,attr"`
+	Value string `xml:"value,attr"`
+}
+
+type junitFailure struct {
+	Message string `xml:"message,attr"`
+	Type    string `xml:"type,attr"`
+	Text    string `xml:",chardata"`
+}
+
+type junitTestCase struct {
+	Name      string          `xml:"name,attr"`
+	ClassName string          `xml:"classname,attr"`
+	Time      string          `xml:"time,attr"`
+	Failure   *junitFailure   `xml:"failure,omitempty"`
+	Error     *junitFailure   `xml:"error,omitempty"`
+	Props     []junitProperty `xml:"properties>property,omitempty"`
+	SystemOut string          `xml:"system-out,omitempty"`
+}
+
+type junitTestSuite struct {
+	Name      string          `xml:"name,attr"`
+	Tests     int             `xml:"tests,attr"`
+	Failures  int             `xml:"failures,attr"`
+	Errors    int             `xml:"errors,attr"`
+	Time      string          `xml:"time,attr"`
+	Timestamp string          `xml:"timestamp,attr"`
+	TestCases []junitTestCase `xml:"testcase"`
+}
+
+type junitTestSuites struct {
+	XMLName xml.Name         `xml:"testsuites"`
+	Suites  []junitTestSuite `xml:"testsuite"`
+}
+
+func seconds(d time.Duration) string {
+	return fmt.Sprintf("%.3f", d.Seconds())
+}
+
+// tail returns at most maxOutput bytes from the end of the log file.
+func tail(file string) string {
+	f, err := os.Open(file)
+	if err != nil {
+		return ""
+	}
+	defer f.Close()
+
+	if info, err := f.Stat(); err == nil && info.Size() > maxOutput {
+		f.Seek(-maxOutput, io.SeekEnd)
+	}
+
+	b, _ := io.ReadAll(f)
+	return string(b)
+}
+
+func WriteJUnit(w io.Writer, suite string, results []*Result) error {
+	s := junitTestSuite{
+		Name:      suite,
+		Timestamp: time.Now().UTC().Format(time.RFC3339),
+	}
+
+	var total time.Duration
+	for _, r := range results {
+		c := junitTestCase{
+			Name:      r.Case,
+			ClassName: r.Class,
+			Time:      seconds(r.Duration),
+			SystemOut: tail(r.Log),
+			Props: []junitProperty{
+				{Name: "container", Value: r.Name},
+				{Name: "release", Value: r.Release},
+			},
+		}
+
+		switch {
+		case r.Error != "":
+			c.Error = &junitFailure{Message: r.Error, Type: "error"}
+			s.Errors++
+		case r.TimedOut:
+			c.Failure = &junitFailure{Message: "timed out after " + r.Duration.Round(time.Second).String(), Type: "timeout"}
+			s.Failures++
+		case !r.Passed:
+			c.Failure = &junitFailure{Message: fmt.Sprintf("exit status %d", r.ExitCode), Type: "failure"}
+			s.Failures++
+		}
+
+		total += r.Duration
+		s.TestCases = append(s.TestCases, c)
+	}
+
+	s.Tests = len(results)
+	s.Time = seconds(total)
+
+	if _, err := io.WriteString(w, xml.Header); err != nil {
+		return err
+	}
+
+	e := xml.NewEncoder(w)
+	e.Indent("", "  ")
+	if err := e.Encode(junitTestSuites{Suites: []junitTestSuite{s}}); err != nil {
+		return err
+	}
+
+	_, err := io.WriteString(w, "\n")
+	return err
+}
+
+func WriteReport(file string, results []*Result) error {
+	b, err := json.MarshalIndent(results, "", "  ")
+	if err != nil {
+		return err
+	}
+
+	return os.WriteFile(file, append(b, '\n'), 0644)
+}
@@ -0,0 +1,236 @@
+// SPDX-License-Identifier: BSD-2
+// Copyright 2023 VMware, Inc.
+
+package runner
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"path"
+	"regexp"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/spf13/viper"
+
+	"github.com/vmware-samples/photon-os-container-builder/pkg/conf"
+	"github.com/vmware-samples/photon-os-container-builder/pkg/container"
+)
+
+const (
+	DefaultConcurrency = 2
+)
+
+var nameInvalid = regexp.MustCompile(`[^a-z0-9-]+`)
+
+// MatrixProfile installs the package profile Profile, or the default profile, with
+// Packages applied on top.
+type MatrixProfile struct {
+	Name     string `mapstructure:"Name"`
+	Profile  string `mapstructure:"Profile"`
+	Packages string `mapstructure:"Packages"`
+}
+
+type MatrixCommand struct {
+	Name    string `mapstructure:"Name"`
+	Command string `mapstructure:"Cmd"`
+}
+
+type Matrix struct {
+	Name        string          `mapstructure:"Name"`
+	Concurrency int             `mapstructure:"Concurrency"`
+	Suite       string          `mapstructure:"Suite"`
+	Timeout     time.Duration   `mapstructure:"Timeout"`
+	Network     string          `mapstructure:"Network"`
+	Link        string          `mapstructure:"Link"`
+	Subnet      string          `mapstructure:"Subnet"`
+	Env         []string        `mapstructure:"Env"`
+	Artifacts   []string        `mapstructure:"Artifacts"`
+	Releases    []string        `mapstructure:"Releases"`
+	Profiles    []MatrixProfile `mapstructure:"Profiles"`
+	Commands    []MatrixCommand `mapstructure:"Commands"`
+}
+
+type Cell struct {
+	Release string
+	Profile MatrixProfile
+	Command MatrixCommand
+	Options Options
+}
+
+func LoadMatrix(file string) (*Matrix, error) {
+	v := viper.New()
+	v.SetConfigFile(file)
+
+	if err := v.ReadInConfig(); err != nil {
+		return nil, err
+	}
+
+	m := Matrix{}
+	if err := v.Unmarshal(&m); err != nil {
+		return nil, err
+	}
+
+	if m.Name == "" {
+		m.Name = strings.TrimSuffix(path.Base(file), path.Ext(file))
+	}
+	if m.Concurrency <= 0 {
+		m.Concurrency = DefaultConcurrency
+	}
+	if len(m.Releases) == 0 {
+		m.Releases = []string{conf.DefaultReleaseVersion}
+	}
+	if len(m.Profiles) == 0 {
+		m.Profiles = []MatrixProfile{{Name: conf.DefaultPackageProfile}}
+	}
+	if len(m.Commands) == 0 {
+		return nil, errors.New("matrix defines no commands")
+	}
+	// Like the paths of a compose file, the suite is relative to the matrix file
+	if m.Suite != "" && !path.IsAbs(m.Suite) {
+		m.Suite = path.Join(path.Dir(file), m.Suite)
+	}
+	if m.Network != "" && (m.Link == "" || m.Subnet == "") {
+		return nil, errors.New("matrix network requires Link and Subnet")
+	}
+
+	for i := range m.Commands {
+		if m.Commands[i].Name == "" {
+			m.Commands[i].Name = "cmd" + strconv.Itoa(i)
+		}
+	}
+
+	return &m, nil
+}
+
+func sanitize(s string) string {
+	return strings.Trim(nameInvalid.ReplaceAllString(strings.ToLower(s), "-"), "-")
+}
+
+// cellName returns a unique machine name for a cell, keeping the random suffix when
+// the descriptive part has to be shortened.
+func cellName(release string, profile string, command string) string {
+	suffix := NewName("")
+	n := sanitize("mx-" + release + "-" + profile + "-" + command)
+	if len(n)+len(suffix) > container.MaxNameLength {
+		n = strings.TrimRight(n[:container.MaxNameLength-len(suffix)], "-")
+	}
+
+	return n + suffix
+}
+
+// AllocateAddresses hands out n host addresses of subnet, skipping the network
+// address and the first host which is usually the gateway.
+func AllocateAddresses(subnet string, n int) ([]string, error) {
+	ip, ipnet, err := net.ParseCIDR(subnet)
+	if err != nil {
+		return nil, err
+	}
+
+	if ip.To4() == nil {
+		return nil, errors.New("only IPv4 subnets are supported")
+	}
+
+	ones, bits := ipnet.Mask.Size()
+	if (1<<(bits-ones))-3 < n {
+		return nil, fmt.Errorf("subnet '%s' is too small for %d cells", subnet, n)
+	}
+
+	start := binary.BigEndian.Uint32(ipnet.IP.To4()) + 2
+
+	var r []string
+	for i := 0; i < n; i++ {
+		a := make(net.IP, 4)
+		binary.BigEndian.PutUint32(a, start+uint32(i))
+		r = append(r, a.String()+"/"+strconv.Itoa(ones))
+	}
+
+	return r, nil
+}
+
+// Expand builds the cells of the matrix: releases × profiles × commands.
+func (m *Matrix) Expand(outputDir string) ([]*Cell, error) {
+	var cells []*Cell
+	for _, r := range m.Releases {
+		for _, p := range m.Profiles {
+			for _, c := range m.Commands {
+				cells = append(cells, &Cell{
+					Release: r,
+					Profile: p,
+					Command: c,
+					Options: Options{
+						Name:      cellName(r, p.Name, c.Name),
+						Release:   r,
+						Profile:   p.Profile,
+						Packages:  p.Packages,
+						Suite:     m.Suite,
+						Command:   c.Command,
+						Timeout:   m.Timeout,
+						Artifacts: m.Artifacts,
+						Env:       m.Env,
+						OutputDir: path.Join(outputDir, m.Name),
+						Network:   m.Network,
+						Link:      m.Link,
+					},
+				})
+			}
+		}
+	}
+
+	if m.Network != "" {
+		addrs, err := AllocateAddresses(m.Subnet, len(cells))
+		if err != nil {
+			return nil, err
+		}
+
+		for i := range cells {
+			cells[i].Options.Address = addrs[i]
+		}
+	}
+
+	return cells, nil
+}
+
+// RunMatrix executes the cells with at most concurrency containers at a time. progress
+// is written to w as cells finish. Results are returned in cell order.
+func RunMatrix(cfg *conf.Config, base string, cells []*Cell, concurrency int, w io.Writer) []*Result {
+	results := make([]*Result, len(cells))
+	sem := make(chan struct{}, concurrency)
+
+	var mu sync.Mutex
+	var wg sync.WaitGroup
+	done := 0
+
+	for i := range cells {
+		wg.Add(1)
+		sem <- struct{}{}
+
+		go func(i int) {
+			defer wg.Done()
+			defer func() { <-sem }()
+
+			c := cells[i]
+			r, err := Run(cfg, base, &c.Options)
+			if err != nil {
+				r = &Result{Name: c.Options.Name, Release: c.Release, Error: err.Error()}
+			}
+			r.Case = c.Command.Name
+			r.Class = c.Release + "." + c.Profile.Name
+			results[i] = r
+
+			mu.Lock()
+			done++
+			fmt.Fprintf(w, "[%d/%d] %-7s %s/%s/%s (%s) %s\n", done, len(cells), r.Status(), c.Release, c.Profile.Name,
+				c.Command.Name, r.Duration.Round(time.Second), r.Log)
+			mu.Unlock()
+		}(i)
+	}
+
+	wg.Wait()
+	return results
+}
@@ -0,0 +1,264 @@
+// SPDX-License-Identifier: BSD-2
+// Copyright 2023 VMware, Inc.
+
+package runner
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"os/exec"
+	"path"
+	"path/filepath"
+	"time"
+
+	"github.com/vmware-samples/photon-os-container-builder/pkg/conf"
+	"github.com/vmware-samples/photon-os-container-builder/pkg/container"
+	"github.com/vmware-samples/photon-os-container-builder/pkg/errdefs"
+	"github.com/vmware-samples/photon-os-container-builder/pkg/lock"
+	"github.com/vmware-samples/photon-os-container-builder/pkg/nspawn"
+	"github.com/vmware-samples/photon-os-container-builder/pkg/profile"
+	"github.com/vmware-samples/photon-os-container-builder/pkg/system"
+)
+
+const (
+	SuiteDir     = "/suite"
+	ArtifactsDir = "/artifacts"
+
+	DefaultTimeout   = 30 * time.Minute
+	DefaultOutputDir = "cntrctl-results"
+)
+
+type Options struct {
+	Name          string
+	Release       string
+	Profile       string
+	Packages      string
+	Clone         string
+	Suite         string
+	Command       string
+	Timeout       time.Duration
+	Artifacts     []string
+	Env           []string
+	OutputDir     string
+	KeepOnFailure bool
+
+	// Network and Link attach the container to a MACVLAN/IPVLAN, Address is assigned
+	// to the interface before the command runs.
+	Network string
+	Link    string
+	Address string
+
+	// Output receives the command output in addition to the log file.
+	Output io.Writer
+}
+
+type Result struct {
+	Name      string        `json:"name"`
+	Case      string        `json:"case"`
+	Class     string        `json:"class"`
+	Release   string        `json:"release"`
+	Passed    bool          `json:"passed"`
+	ExitCode  int           `json:"exit_code"`
+	TimedOut  bool          `json:"timed_out"`
+	Duration  time.Duration `json:"duration"`
+	Log       string        `json:"log"`
+	Artifacts string        `json:"artifacts"`
+	Error     string        `json:"error,omitempty"`
+	Kept      string        `json:"kept,omitempty"`
+}
+
+func (r *Result) Status() string {
+	switch {
+	case r.Error != "":
+		return "ERROR"
+	case r.TimedOut:
+		return "TIMEOUT"
+	case !r.Passed:
+		return "FAIL"
+	}
+
+	return "PASS"
+}
+
+func NewName(prefix string) string {
+	b := make([]byte, 4)
+	rand.Read(b)
+
+	return prefix + "-" + hex.EncodeToString(b)
+}
+
+func collectArtifacts(root string, artifacts []string, dst string) error {
+	for _, a := range artifacts {
+		src, err := system.SecureJoin(root, a)
+		if err != nil {
+			return err
+		}
+
+		if !system.PathExists(src) {
+			continue
+		}
+
+		if err := system.CopyTree(src, "/", path.Join(dst, filepath.Base(src))); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// Run executes the command of a test case in a fresh container. Either a new rootfs
+// is built from packages or an ephemeral snapshot of an existing container is used.
+// Errors are only returned for problems with the runner itself, test failures are
+// reported in the result.
+func Run(cfg *conf.Config, base string, o *Options) (*Result, error) {
+	// The snapshot of a clone is gone when systemd-nspawn exits, files to keep have
+	// to be written to $ARTIFACTS
+	if o.Clone != "" && (len(o.Artifacts) > 0 || o.KeepOnFailure) {
+		return nil, errdefs.Errorf(errdefs.ErrInvalid, "artifacts and keep on failure are not supported with a clone, write files to $ARTIFACTS instead")
+	}
+
+	var suite string
+	if o.Suite != "" {
+		var err error
+		if suite, err = filepath.Abs(o.Suite); err != nil {
+			return nil, err
+		}
+	}
+
+	if o.Name == "" {
+		o.Name = NewName("test")
+	}
+	if o.Timeout == 0 {
+		o.Timeout = DefaultTimeout
+	}
+	if o.OutputDir == "" {
+		o.OutputDir = DefaultOutputDir
+	}
+
+	out, err := filepath.Abs(path.Join(o.OutputDir, o.Name))
+	if err != nil {
+		return nil, err
+	}
+
+	r := Result{
+		Name:      o.Name,
+		Case:      o.Command,
+		Class:     "photon-" + o.Release,
+		Release:   o.Release,
+		Log:       path.Join(out, "output.log"),
+		Artifacts: path.Join(out, "artifacts"),
+	}
+
+	if err := os.MkdirAll(r.Artifacts, 0755); err != nil {
+		return nil, err
+	}
+
+	log, err := os.Create(r.Log)
+	if err != nil {
+		return nil, err
+	}
+	defer log.Close()
+
+	w := io.Writer(log)
+	if o.Output != nil {
+		w = io.MultiWriter(log, o.Output)
+	}
+
+	dir := path.Join(base, o.Name)
+	if o.Clone != "" {
+		if err := container.CheckExisting(o.Clone); err != nil {
+			r.Error = err.Error()
+			return &r, nil
+		}
+
+		// The snapshot must not be taken while another cntrctl changes the rootfs
+		l, err := lock.Container(context.Background(), o.Clone, "test")
+		if err != nil {
+			r.Error = err.Error()
+			return &r, nil
+		}
+		defer l.Release()
+
+		dir = container.RootDir(base, o.Clone)
+		if !system.PathExists(dir) {
+			r.Error = fmt.Sprintf("container '%s' does not exist", o.Clone)
+			return &r, nil
+		}
+	} else {
+		packages, err := profile.Packages(cfg, o.Profile, o.Release, o.Packages)
+		if err != nil {
+			r.Error = err.Error()
+			return &r, nil
+		}
+
+		if err := container.Build(cfg, w, base, o.Name, o.Release, packages, nil, nil); err != nil {
+			r.Error = fmt.Sprintf("failed to build container: %v", err)
+			return &r, nil
+		}
+	}
+
+	n := nspawn.RunOptions{
+		Directory: dir,
+		Machine:   o.Name,
+		Ephemeral: o.Clone != "",
+		Binds:     []string{r.Artifacts + ":" + ArtifactsDir},
+		Env:       append([]string{"ARTIFACTS=" + ArtifactsDir}, o.Env...),
+		Command:   o.Command,
+		Network:   o.Network,
+		Link:      o.Link,
+		Output:    w,
+		Security:  cfg.Security.Profile,
+	}
+
+	if o.Address != "" {
+		i := nspawn.InterfaceName(o.Network, o.Link)
+		n.Env = append(n.Env, "ADDRESS="+o.Address)
+		n.Command = fmt.Sprintf("ip link set dev %s up && ip addr add %s dev %s && {\n%s\n}", i, o.Address, i, o.Command)
+	}
+
+	if suite != "" {
+		// The suite is overlaid with a temporary upper directory, so build output of
+		// the test does not end up in the host copy.
+		n.Overlays = []string{suite + "::" + SuiteDir}
+		n.Chdir = SuiteDir
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), o.Timeout)
+	defer cancel()
+
+	start := time.Now()
+	err = nspawn.Run(ctx, &n)
+	r.Duration = time.Since(start)
+
+	var exitErr *exec.ExitError
+	switch {
+	case err == nil:
+		r.Passed = true
+	case errors.Is(ctx.Err(), context.DeadlineExceeded):
+		r.TimedOut = true
+		r.ExitCode = -1
+	case errors.As(err, &exitErr):
+		r.ExitCode = exitErr.ExitCode()
+	default:
+		r.Error = fmt.Sprintf("failed to run systemd-nspawn: %v", err)
+	}
+
+	if o.Clone == "" {
+		if err := collectArtifacts(dir, o.Artifacts, r.Artifacts); err != nil {
+			fmt.Fprintf(w, "Failed to collect artifacts: %v\n", err)
+		}
+
+		if !r.Passed && o.KeepOnFailure {
+			r.Kept = dir
+		} else {
+			system.RemoveDir(dir)
+		}
+	}
+
+	return &r, nil
+}
@@ -0,0 +1,244 @@
+// SPDX-License-Identifier: BSD-2
+// Copyright 2023 VMware, Inc.
+
+package sbom
+
+import (
+	"crypto/rand"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/url"
+	"regexp"
+	"time"
+
+	"github.com/vmware-samples/photon-os-container-builder/pkg/conf"
+	"github.com/vmware-samples/photon-os-container-builder/pkg/rpm"
+)
+
+const (
+	FormatSPDX      = "spdx"
+	FormatCycloneDX = "cyclonedx"
+
+	namespace = "https://github.com/vmware-samples/photon-os-container-builder/spdx/"
+)
+
+var spdxIDInvalid = regexp.MustCompile(`[^a-zA-Z0-9.-]`)
+
+type spdxCreationInfo struct {
+	Created  string   `json:"created"`
+	Creators []string `json:"creators"`
+}
+
+type spdxExternalRef struct {
+	Category string `json:"referenceCategory"`
+	Type     string `json:"referenceType"`
+	Locator  string `json:"referenceLocator"`
+}
+
+type spdxPackage struct {
+	Name             string            `json:"name"`
+	SPDXID           string            `json:"SPDXID"`
+	VersionInfo      string            `json:"versionInfo"`
+	Supplier         string            `json:"supplier"`
+	DownloadLocation string            `json:"downloadLocation"`
+	FilesAnalyzed    bool              `json:"filesAnalyzed"`
+	LicenseConcluded string            `json:"licenseConcluded"`
+	LicenseDeclared  string            `json:"licenseDeclared"`
+	LicenseComments  string            `json:"licenseComments,omitempty"`
+	SourceInfo       string            `json:"sourceInfo,omitempty"`
+	ExternalRefs     []spdxExternalRef `json:"externalRefs"`
+}
+
+type spdxRelationship struct {
+	Element string `json:"spdxElementId"`
+	Type    string `json:"relationshipType"`
+	Related string `json:"relatedSpdxElement"`
+}
+
+type spdxDocument struct {
+	SPDXVersion       string             `json:"spdxVersion"`
+	DataLicense       string             `json:"dataLicense"`
+	SPDXID            string             `json:"SPDXID"`
+	Name              string             `json:"name"`
+	DocumentNamespace string             `json:"documentNamespace"`
+	CreationInfo      spdxCreationInfo   `json:"creationInfo"`
+	Packages          []spdxPackage      `json:"packages"`
+	Relationships     []spdxRelationship `json:"relationships"`
+}
+
+type cdxTool struct {
+	Vendor  string `json:"vendor"`
+	Name    string `json:"name"`
+	Version string `json:"version"`
+}
+
+type cdxLicense struct {
+	License struct {
+		Name string `json:"name"`
+	} `json:"license"`
+}
+
+type cdxProperty struct {
+	Name  string `json:"name"`
+	Value string `json:"value"`
+}
+
+type cdxComponent struct {
+	Type       string        `json:"type"`
+	BOMRef     string        `json:"bom-ref,omitempty"`
+	Name       string        `json:"name"`
+	Version    string        `json:"version,omitempty"`
+	PURL       string        `json:"purl,omitempty"`
+	Licenses   []cdxLicense  `json:"licenses,omitempty"`
+	Properties []cdxProperty `json:"properties,omitempty"`
+}
+
+type cdxMetadata struct {
+	Timestamp string       `json:"timestamp"`
+	Tools     []cdxTool    `json:"tools"`
+	Component cdxComponent `json:"component"`
+}
+
+type cdxDocument struct {
+	BOMFormat    string         `json:"bomFormat"`
+	SpecVersion  string         `json:"specVersion"`
+	SerialNumber string         `json:"serialNumber"`
+	Version      int            `json:"version"`
+	Metadata     cdxMetadata    `json:"metadata"`
+	Components   []cdxComponent `json:"components"`
+}
+
+func uuid() string {
+	b := make([]byte, 16)
+	rand.Read(b)
+
+	b[6] = (b[6] & 0x0f) | 0x40
+	b[8] = (b[8] & 0x3f) | 0x80
+
+	return fmt.Sprintf("%x-%x-%x-%x-%x", b[0:4], b[4:6], b[6:8], b[8:10], b[10:])
+}
+
+func purl(p *rpm.Package) string {
+	q := url.Values{}
+	q.Set("arch", p.Arch)
+	if p.Epoch != "" && p.Epoch != "0" {
+		q.Set("epoch", p.Epoch)
+	}
+
+	return "pkg:rpm/photon/" + url.PathEscape(p.Name) + "@" + url.PathEscape(p.Version+"-"+p.Release) + "?" + q.Encode()
+}
+
+func writeJSON(w io.Writer, v interface{}) error {
+	e := json.NewEncoder(w)
+	e.SetIndent("", "  ")
+
+	return e.Encode(v)
+}
+
+func WriteSPDX(w io.Writer, name string, pkgs []rpm.Package) error {
+	d := spdxDocument{
+		SPDXVersion:       "SPDX-2.3",
+		DataLicense:       "CC0-1.0",
+		SPDXID:            "SPDXRef-DOCUMENT",
+		Name:              name,
+		DocumentNamespace: namespace + url.PathEscape(name) + "-" + uuid(),
+		CreationInfo: spdxCreationInfo{
+			Created:  time.Now().UTC().Format(time.RFC3339),
+			Creators: []string{"Tool: cntrctl-" + conf.Version},
+		},
+	}
+
+	for i := range pkgs {
+		p := &pkgs[i]
+		id := "SPDXRef-Package-" + spdxIDInvalid.ReplaceAllString(p.Name+"-"+p.EVR()+"."+p.Arch, "-")
+
+		d.Packages = append(d.Packages, spdxPackage{
+			Name:             p.Name,
+			SPDXID:           id,
+			VersionInfo:      p.EVR(),
+			Supplier:         "Organization: VMware, Inc.",
+			DownloadLocation: "NOASSERTION",
+			FilesAnalyzed:    false,
+			LicenseConcluded: "NOASSERTION",
+			LicenseDeclared:  "NOASSERTION",
+			LicenseComments:  p.License,
+			SourceInfo:       "built package from: " + p.SourceRPM,
+			ExternalRefs: []spdxExternalRef{
+				{
+					Category: "PACKAGE-MANAGER",
+					Type:     "purl",
+					Locator:  purl(p),
+				},
+			},
+		})
+
+		d.Relationships = append(d.Relationships, spdxRelationship{
+			Element: "SPDXRef-DOCUMENT",
+			Type:    "DESCRIBES",
+			Related: id,
+		})
+	}
+
+	return writeJSON(w, d)
+}
+
+func WriteCycloneDX(w io.Writer, name string, pkgs []rpm.Package) error {
+	d := cdxDocument{
+		BOMFormat:    "CycloneDX",
+		SpecVersion:  "1.5",
+		SerialNumber: "urn:uuid:" + uuid(),
+		Version:      1,
+		Metadata: cdxMetadata{
+			Timestamp: time.Now().UTC().Format(time.RFC3339),
+			Tools: []cdxTool{
+				{
+					Vendor:  "VMware",
+					Name:    "cntrctl",
+					Version: conf.Version,
+				},
+			},
+			Component: cdxComponent{
+				Type: "container",
+				Name: name,
+			},
+		},
+	}
+
+	for i := range pkgs {
+		p := &pkgs[i]
+
+		c := cdxComponent{
+			Type:    "library",
+			BOMRef:  purl(p),
+			Name:    p.Name,
+			Version: p.EVR(),
+			PURL:    purl(p),
+			Properties: []cdxProperty{
+				{Name: "rpm:arch", Value: p.Arch},
+				{Name: "rpm:sourcerpm", Value: p.SourceRPM},
+			},
+		}
+
+		if p.License != "" {
+			l := cdxLicense{}
+			l.License.Name = p.License
+			c.Licenses = []cdxLicense{l}
+		}
+
+		d.Components = append(d.Components, c)
+	}
+
+	return writeJSON(w, d)
+}
+
+func Write(w io.Writer, format string, name string, pkgs []rpm.Package) error {
+	switch format {
+	case FormatSPDX:
+		return WriteSPDX(w, name, pkgs)
+	case FormatCycloneDX:
+		return WriteCycloneDX(w, name, pkgs)
+	}
+
+	return fmt.Errorf("unsupported SBOM format '%s'", format)
+}
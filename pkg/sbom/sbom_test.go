@@ -0,0 +1,118 @@
+// SPDX-License-Identifier: BSD-2
+// Copyright 2023 VMware, Inc.
+
+package sbom
+
+import (
+	"bytes"
+	"encoding/json"
+	"regexp"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/vmware-samples/photon-os-container-builder/pkg/rpm"
+)
+
+var testPackages = []rpm.Package{
+	{Name: "bash", Epoch: "0", Version: "5.2.15", Release: "1.ph5", Arch: "x86_64", License: "GPLv3+", SourceRPM: "bash-5.2.15-1.ph5.src.rpm"},
+	{Name: "openssl", Epoch: "1", Version: "3.0.9", Release: "2.ph5", Arch: "x86_64", License: "ASL 2.0", SourceRPM: "openssl-3.0.9-2.ph5.src.rpm"},
+	{Name: "linux", Epoch: "0", Version: "6.1.10", Release: "1.ph5", Arch: "x86_64", License: "GPLv2"},
+	{Name: "linux", Epoch: "0", Version: "6.1.20", Release: "1.ph5", Arch: "x86_64", License: "GPLv2"},
+}
+
+var uuidRegexp = regexp.MustCompile(`^[0-9a-f]{8}-[0-9a-f]{4}-4[0-9a-f]{3}-[89ab][0-9a-f]{3}-[0-9a-f]{12}$`)
+
+func TestWriteSPDX(t *testing.T) {
+	var b bytes.Buffer
+	if err := Write(&b, FormatSPDX, "web", testPackages); err != nil {
+		t.Fatal(err)
+	}
+
+	var d spdxDocument
+	if err := json.Unmarshal(b.Bytes(), &d); err != nil {
+		t.Fatal(err)
+	}
+
+	if d.SPDXVersion != "SPDX-2.3" || d.DataLicense != "CC0-1.0" || d.SPDXID != "SPDXRef-DOCUMENT" || d.Name != "web" {
+		t.Errorf("unexpected document header %+v", d)
+	}
+	if !strings.HasPrefix(d.DocumentNamespace, namespace+"web-") || !uuidRegexp.MatchString(strings.TrimPrefix(d.DocumentNamespace, namespace+"web-")) {
+		t.Errorf("documentNamespace = %q", d.DocumentNamespace)
+	}
+	if _, err := time.Parse(time.RFC3339, d.CreationInfo.Created); err != nil {
+		t.Errorf("created = %q: %v", d.CreationInfo.Created, err)
+	}
+	if len(d.CreationInfo.Creators) != 1 || !strings.HasPrefix(d.CreationInfo.Creators[0], "Tool: cntrctl-") {
+		t.Errorf("creators = %q", d.CreationInfo.Creators)
+	}
+
+	if len(d.Packages) != len(testPackages) || len(d.Relationships) != len(testPackages) {
+		t.Fatalf("%d packages and %d relationships, want %d", len(d.Packages), len(d.Relationships), len(testPackages))
+	}
+
+	ids := map[string]bool{}
+	id := regexp.MustCompile(`^SPDXRef-[a-zA-Z0-9.-]+$`)
+	for i, p := range d.Packages {
+		if !id.MatchString(p.SPDXID) || ids[p.SPDXID] {
+			t.Errorf("SPDXID %q is invalid or not unique", p.SPDXID)
+		}
+		ids[p.SPDXID] = true
+
+		r := d.Relationships[i]
+		if r.Element != "SPDXRef-DOCUMENT" || r.Type != "DESCRIBES" || r.Related != p.SPDXID {
+			t.Errorf("relationship %+v does not describe %s", r, p.SPDXID)
+		}
+		if len(p.ExternalRefs) != 1 || p.ExternalRefs[0].Type != "purl" || !strings.HasPrefix(p.ExternalRefs[0].Locator, "pkg:rpm/photon/"+p.Name+"@") {
+			t.Errorf("externalRefs of %s = %+v", p.Name, p.ExternalRefs)
+		}
+	}
+
+	if p := d.Packages[1]; p.VersionInfo != "1:3.0.9-2.ph5" || p.LicenseComments != "ASL 2.0" || p.ExternalRefs[0].Locator != "pkg:rpm/photon/openssl@3.0.9-2.ph5?arch=x86_64&epoch=1" {
+		t.Errorf("unexpected package %+v", p)
+	}
+}
+
+func TestWriteCycloneDX(t *testing.T) {
+	var b bytes.Buffer
+	if err := Write(&b, FormatCycloneDX, "web", testPackages); err != nil {
+		t.Fatal(err)
+	}
+
+	var d cdxDocument
+	if err := json.Unmarshal(b.Bytes(), &d); err != nil {
+		t.Fatal(err)
+	}
+
+	if d.BOMFormat != "CycloneDX" || d.SpecVersion != "1.5" || d.Version != 1 {
+		t.Errorf("unexpected document header %+v", d)
+	}
+	if !strings.HasPrefix(d.SerialNumber, "urn:uuid:") || !uuidRegexp.MatchString(strings.TrimPrefix(d.SerialNumber, "urn:uuid:")) {
+		t.Errorf("serialNumber = %q", d.SerialNumber)
+	}
+	if d.Metadata.Component.Type != "container" || d.Metadata.Component.Name != "web" || len(d.Metadata.Tools) != 1 {
+		t.Errorf("unexpected metadata %+v", d.Metadata)
+	}
+
+	if len(d.Components) != len(testPackages) {
+		t.Fatalf("%d components, want %d", len(d.Components), len(testPackages))
+	}
+
+	refs := map[string]bool{}
+	for _, c := range d.Components {
+		if c.Type != "library" || c.PURL == "" || c.BOMRef != c.PURL || refs[c.BOMRef] {
+			t.Errorf("component %+v has no unique bom-ref", c)
+		}
+		refs[c.BOMRef] = true
+	}
+
+	if c := d.Components[0]; c.Version != "5.2.15-1.ph5" || len(c.Licenses) != 1 || c.Licenses[0].License.Name != "GPLv3+" {
+		t.Errorf("unexpected component %+v", c)
+	}
+}
+
+func TestWriteUnknownFormat(t *testing.T) {
+	if err := Write(&bytes.Buffer{}, "yaml", "web", testPackages); err == nil {
+		t.Error("Write() of an unknown format succeeded")
+	}
+}
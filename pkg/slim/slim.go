@@ -0,0 +1,244 @@
+// SPDX-License-Identifier: BSD-2
+// Copyright 2023 VMware, Inc.
+
+package slim
+
+import (
+	"fmt"
+	"io"
+	"path"
+	"path/filepath"
+	"strings"
+
+	"github.com/vmware-samples/photon-os-container-builder/pkg/conf"
+	"github.com/vmware-samples/photon-os-container-builder/pkg/system"
+)
+
+const (
+	DefaultLanguage = "en_US"
+
+	cacheDir      = "var/cache/tdnf"
+	macrosFile    = "etc/rpm/macros.slim"
+	localeArchive = "usr/lib/locale/locale-archive"
+	localeSources = "usr/share/i18n/locales"
+	localedef     = "/usr/bin/localedef"
+)
+
+type Rules struct {
+	Profiles    []string
+	ExcludeDocs bool
+	Languages   []string
+	Paths       []string
+	LocalePaths []string
+}
+
+func (r *Rules) resolve(c *conf.Config, name string, args []string, seen map[string]bool) error {
+	if seen[name] {
+		return fmt.Errorf("slim profile '%s' includes itself", name)
+	}
+	seen[name] = true
+	defer delete(seen, name)
+
+	p, ok := c.SlimProfile(name)
+	if !ok {
+		return fmt.Errorf("unknown slim profile '%s'", name)
+	}
+
+	for _, i := range p.Include {
+		if err := r.resolve(c, i, args, seen); err != nil {
+			return err
+		}
+	}
+
+	for _, f := range p.Paths {
+		if path.IsAbs(f) || strings.Contains(f, "..") {
+			return fmt.Errorf("slim profile '%s' path '%s' must be relative to the rootfs", name, f)
+		}
+	}
+
+	r.ExcludeDocs = r.ExcludeDocs || p.ExcludeDocs
+	if p.Languages {
+		if len(args) == 0 {
+			args = []string{DefaultLanguage}
+		}
+
+		r.Languages = append(r.Languages, args...)
+		r.LocalePaths = append(r.LocalePaths, p.Paths...)
+	} else {
+		r.Paths = append(r.Paths, p.Paths...)
+	}
+
+	return nil
+}
+
+// Resolve expands profile specs of the form NAME[:LANG[:LANG...]] into pruning rules.
+// Languages given to a profile are passed on to the profiles it includes.
+func Resolve(c *conf.Config, specs []string) (*Rules, error) {
+	r := Rules{}
+	for _, s := range specs {
+		if s == "" {
+			continue
+		}
+
+		f := strings.Split(s, ":")
+		if err := r.resolve(c, f[0], f[1:], make(map[string]bool)); err != nil {
+			return nil, err
+		}
+
+		r.Profiles = append(r.Profiles, s)
+	}
+
+	if len(r.Profiles) == 0 {
+		return nil, nil
+	}
+
+	return &r, nil
+}
+
+func keepLocale(name string, languages []string) bool {
+	if name == "C" || strings.HasPrefix(name, "C.") || name == "locale.alias" || name == "locale-archive" {
+		return true
+	}
+
+	for _, l := range languages {
+		short, _, _ := strings.Cut(l, "_")
+		if name == l || name == short || strings.HasPrefix(name, l+".") || strings.HasPrefix(name, l+"@") {
+			return true
+		}
+	}
+
+	return false
+}
+
+func remove(root string, pattern string, keep func(string) bool) error {
+	matches, err := filepath.Glob(path.Join(root, pattern))
+	if err != nil {
+		return err
+	}
+
+	for _, m := range matches {
+		if !strings.HasPrefix(m, root+"/") {
+			continue
+		}
+
+		if keep != nil && keep(path.Base(m)) {
+			continue
+		}
+
+		if err := system.RemoveAll(m); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// TDNFOptions returns the tdnf options that keep files pruned by r from being
+// installed in the first place.
+func (r *Rules) TDNFOptions() []string {
+	if r == nil || !r.ExcludeDocs {
+		return nil
+	}
+
+	return []string{"--setopt=tsflags=nodocs"}
+}
+
+// WriteMacros writes the rpm macros of r to root, so packages installed from within
+// the container honor the same rules. The tdnf installing the rootfs runs on the host
+// and reads the macros of the host, so the initial install is not affected by them:
+// it gets TDNFOptions and its locales are pruned by Apply.
+func WriteMacros(root string, r *Rules) error {
+	var macros string
+	if r.ExcludeDocs {
+		macros += "%_excludedocs 1\n"
+	}
+	if len(r.Languages) > 0 {
+		macros += "%_install_langs " + strings.Join(r.Languages, ":") + "\n"
+	}
+
+	if macros == "" {
+		return nil
+	}
+
+	f := path.Join(root, macrosFile)
+	if err := system.MkdirAll(path.Dir(f), 0755); err != nil {
+		return err
+	}
+
+	return system.WriteFile(f, []byte(macros), 0644)
+}
+
+// shrinkArchive drops the locales not kept for languages from the locale archive of
+// root. The archive is rebuilt from the locale sources in the rootfs when they cover
+// all languages, since deleting entries leaves the file at its size.
+func shrinkArchive(root string, languages []string) error {
+	if !system.PathExists(path.Join(root, localeArchive)) {
+		return nil
+	}
+
+	out, err := system.ExecAndCapture(localedef, "--prefix", root, "--list-archive")
+	if err != nil {
+		return err
+	}
+
+	var drop []string
+	for _, l := range strings.Fields(out) {
+		if !keepLocale(l, languages) {
+			drop = append(drop, l)
+		}
+	}
+	if len(drop) == 0 {
+		return nil
+	}
+
+	rebuild := system.PathExists(path.Join(root, localedef))
+	for _, l := range languages {
+		rebuild = rebuild && system.PathExists(path.Join(root, localeSources, l))
+	}
+
+	if !rebuild {
+		return system.ExecAndDisplay(io.Discard, localedef, append([]string{"--prefix", root, "--delete-from-archive"}, drop...)...)
+	}
+
+	if err := system.Remove(path.Join(root, localeArchive)); err != nil {
+		return err
+	}
+	for _, l := range languages {
+		if err := system.ExecAndDisplay(io.Discard, "chroot", root, localedef, "-i", l, "-f", "UTF-8", l+".UTF-8"); err != nil {
+			return fmt.Errorf("failed to compile locale '%s': %w", l, err)
+		}
+	}
+
+	return nil
+}
+
+// Apply prunes root according to r.
+func Apply(root string, r *Rules) error {
+	root = path.Clean(root)
+
+	// Pruning the cache would empty the shared cache mounted over it
+	if system.MountPoint(path.Join(root, cacheDir)) {
+		return fmt.Errorf("package cache is still mounted on '%s'", path.Join(root, cacheDir))
+	}
+
+	for _, p := range r.Paths {
+		if err := remove(root, p, nil); err != nil {
+			return err
+		}
+	}
+
+	if len(r.LocalePaths) > 0 {
+		// The sources the archive is rebuilt from are pruned below
+		if err := shrinkArchive(root, r.Languages); err != nil {
+			return fmt.Errorf("failed to shrink locale archive: %w", err)
+		}
+	}
+
+	for _, p := range r.LocalePaths {
+		if err := remove(root, p, func(name string) bool { return keepLocale(name, r.Languages) }); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
@@ -0,0 +1,264 @@
+// SPDX-License-Identifier: BSD-2
+// Copyright 2023 VMware, Inc.
+
+package system
+
+import (
+	"bufio"
+	"encoding/json"
+	"os"
+	"path"
+	"strconv"
+	"strings"
+)
+
+const (
+	CgroupRoot = "/sys/fs/cgroup"
+)
+
+type CgroupStats struct {
+	MemoryCurrent uint64 `json:"memory_current"`
+	MemoryPeak    uint64 `json:"memory_peak,omitempty"`
+	CPUUsageUsec  uint64 `json:"cpu_usage_usec"`
+	IOReadBytes   uint64 `json:"io_read_bytes"`
+	IOWriteBytes  uint64 `json:"io_write_bytes"`
+	Tasks         uint64 `json:"tasks"`
+}
+
+type Interface struct {
+	Name      string   `json:"name"`
+	State     string   `json:"state"`
+	MAC       string   `json:"mac,omitempty"`
+	Addresses []string `json:"addresses,omitempty"`
+}
+
+type Mount struct {
+	Source string `json:"source"`
+	Target string `json:"target"`
+	FSType string `json:"fstype"`
+	Device string `json:"device"`
+}
+
+func readUint(file string) uint64 {
+	b, err := os.ReadFile(file)
+	if err != nil {
+		return 0
+	}
+
+	v, _ := strconv.ParseUint(strings.TrimSpace(string(b)), 10, 64)
+	return v
+}
+
+func readKeyed(file string, fn func(fields []string)) {
+	f, err := os.Open(file)
+	if err != nil {
+		return
+	}
+	defer f.Close()
+
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		fn(strings.Fields(scanner.Text()))
+	}
+}
+
+// UnitCgroup returns the control group of unit that process pid is in, as found in the
+// unified hierarchy line of /proc/PID/cgroup. Processes of a machine scope live in
+// sub-groups such as payload, so the path is cut after unit.
+func UnitCgroup(pid int, unit string) (string, error) {
+	lines, err := ReadLines(path.Join("/proc", strconv.Itoa(pid), "cgroup"))
+	if err != nil {
+		return "", err
+	}
+
+	for _, l := range lines {
+		cg, ok := strings.CutPrefix(l, "0::")
+		if !ok {
+			continue
+		}
+
+		parts := strings.Split(cg, "/")
+		for i, p := range parts {
+			if p == unit {
+				return strings.Join(parts[:i+1], "/"), nil
+			}
+		}
+
+		return cg, nil
+	}
+
+	return "", os.ErrNotExist
+}
+
+// ReadCgroupStats reads resource usage of a unified (v2) control group.
+func ReadCgroupStats(cgroup string) (*CgroupStats, error) {
+	d := path.Join(CgroupRoot, cgroup)
+	if !PathExists(d) {
+		return nil, os.ErrNotExist
+	}
+
+	s := CgroupStats{
+		MemoryCurrent: readUint(path.Join(d, "memory.current")),
+		MemoryPeak:    readUint(path.Join(d, "memory.peak")),
+		Tasks:         readUint(path.Join(d, "pids.current")),
+	}
+
+	readKeyed(path.Join(d, "cpu.stat"), func(f []string) {
+		if len(f) == 2 && f[0] == "usage_usec" {
+			s.CPUUsageUsec, _ = strconv.ParseUint(f[1], 10, 64)
+		}
+	})
+
+	readKeyed(path.Join(d, "io.stat"), func(f []string) {
+		for _, kv := range f[1:] {
+			k, v, ok := strings.Cut(kv, "=")
+			if !ok {
+				continue
+			}
+
+			n, _ := strconv.ParseUint(v, 10, 64)
+			switch k {
+			case "rbytes":
+				s.IOReadBytes += n
+			case "wbytes":
+				s.IOWriteBytes += n
+			}
+		}
+	})
+
+	return &s, nil
+}
+
+// NetworkInterfaces lists the interfaces in the network namespace of pid.
+func NetworkInterfaces(pid int) ([]Interface, error) {
+	out, err := ExecAndCapture("/usr/bin/nsenter", "-t", strconv.Itoa(pid), "-n", "ip", "-j", "addr", "show")
+	if err != nil {
+		return nil, err
+	}
+
+	var links []struct {
+		Name     string `json:"ifname"`
+		State    string `json:"operstate"`
+		Address  string `json:"address"`
+		AddrInfo []struct {
+			Local     string `json:"local"`
+			PrefixLen int    `json:"prefixlen"`
+		} `json:"addr_info"`
+	}
+
+	if err := json.Unmarshal([]byte(out), &links); err != nil {
+		return nil, err
+	}
+
+	var r []Interface
+	for _, l := range links {
+		i := Interface{
+			Name:  l.Name,
+			State: l.State,
+			MAC:   l.Address,
+		}
+
+		for _, a := range l.AddrInfo {
+			i.Addresses = append(i.Addresses, a.Local+"/"+strconv.Itoa(a.PrefixLen))
+		}
+
+		r = append(r, i)
+	}
+
+	return r, nil
+}
+
+// nspawnMounts are the API file systems and the host communication directories
+// systemd-nspawn sets up in every container.
+var nspawnMounts = []string{"/proc", "/sys", "/dev", "/run/host", "/run/systemd/nspawn"}
+
+// internalMount reports whether target is the root or one of nspawnMounts.
+func internalMount(target string) bool {
+	if target == "/" {
+		return true
+	}
+
+	for _, m := range nspawnMounts {
+		if target == m || strings.HasPrefix(target, m+"/") {
+			return true
+		}
+	}
+
+	return false
+}
+
+// BindMounts lists the bind mounts visible in the mount namespace of pid, without
+// those systemd-nspawn creates itself.
+func BindMounts(pid int) ([]Mount, error) {
+	f, err := os.Open(path.Join("/proc", strconv.Itoa(pid), "mountinfo"))
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	var r []Mount
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		pre, post, ok := strings.Cut(scanner.Text(), " - ")
+		if !ok {
+			continue
+		}
+
+		a, b := strings.Fields(pre), strings.Fields(post)
+		if len(a) < 5 || len(b) < 2 || a[3] == "/" || internalMount(a[4]) {
+			continue
+		}
+
+		r = append(r, Mount{
+			Source: a[3],
+			Target: a[4],
+			FSType: b[0],
+			Device: b[1],
+		})
+	}
+
+	return r, scanner.Err()
+}
+
+// MountPoint reports whether p is the target of a mount in the mount namespace of
+// the calling process.
+func MountPoint(p string) bool {
+	f, err := os.Open("/proc/self/mountinfo")
+	if err != nil {
+		return false
+	}
+	defer f.Close()
+
+	p = path.Clean(p)
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		a := strings.Fields(scanner.Text())
+		if len(a) >= 5 && unescapeMountinfo(a[4]) == p {
+			return true
+		}
+	}
+
+	return false
+}
+
+// unescapeMountinfo undoes the octal escapes of white space and backslashes in the
+// fields of mountinfo.
+func unescapeMountinfo(s string) string {
+	if !strings.Contains(s, `\`) {
+		return s
+	}
+
+	var b strings.Builder
+	for i := 0; i < len(s); i++ {
+		if s[i] == '\\' && i+4 <= len(s) {
+			if n, err := strconv.ParseUint(s[i+1:i+4], 8, 8); err == nil {
+				b.WriteByte(byte(n))
+				i += 3
+				continue
+			}
+		}
+		b.WriteByte(s[i])
+	}
+
+	return b.String()
+}
@@ -0,0 +1,221 @@
+// SPDX-License-Identifier: BSD-2
+// Copyright 2023 VMware, Inc.
+
+package system
+
+import (
+	"errors"
+	"io"
+	"io/fs"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+	"syscall"
+
+	"golang.org/x/sys/unix"
+)
+
+const (
+	maxSymlinks = 255
+)
+
+// SecureJoin resolves p as if root was the file system root. Symlinks are followed
+// but can never lead outside of root, absolute targets are taken relative to root
+// and '..' stops at root. The final path does not need to exist.
+func SecureJoin(root string, p string) (string, error) {
+	root = filepath.Clean(root)
+
+	var resolved string
+	links := 0
+	rest := p
+
+	for rest != "" {
+		var c string
+		c, rest, _ = strings.Cut(strings.TrimLeft(rest, "/"), "/")
+
+		switch c {
+		case "", ".":
+			continue
+		case "..":
+			resolved = path.Dir("/" + resolved)
+			resolved = strings.TrimPrefix(resolved, "/")
+			continue
+		}
+
+		next := path.Join(resolved, c)
+		info, err := os.Lstat(path.Join(root, next))
+		if err != nil {
+			if os.IsNotExist(err) {
+				resolved = next
+				continue
+			}
+			return "", err
+		}
+
+		if info.Mode()&os.ModeSymlink == 0 {
+			resolved = next
+			continue
+		}
+
+		links++
+		if links > maxSymlinks {
+			return "", errors.New("too many levels of symbolic links")
+		}
+
+		target, err := os.Readlink(path.Join(root, next))
+		if err != nil {
+			return "", err
+		}
+
+		if path.IsAbs(target) {
+			resolved = ""
+		}
+		rest = target + "/" + rest
+	}
+
+	return path.Join(root, resolved), nil
+}
+
+func copyFile(src string, dst string, info fs.FileInfo) error {
+	in, err := os.Open(src)
+	if err != nil {
+		return err
+	}
+	defer in.Close()
+
+	out, err := os.OpenFile(dst, os.O_WRONLY|os.O_CREATE|os.O_TRUNC|unix.O_NOFOLLOW, info.Mode().Perm())
+	if err != nil {
+		return err
+	}
+
+	if _, err := io.Copy(out, in); err != nil {
+		out.Close()
+		return err
+	}
+
+	return out.Close()
+}
+
+func copyAttributes(dst string, info fs.FileInfo) error {
+	st, ok := info.Sys().(*syscall.Stat_t)
+	if ok {
+		if err := os.Lchown(dst, int(st.Uid), int(st.Gid)); err != nil {
+			return err
+		}
+	}
+
+	if info.Mode()&os.ModeSymlink != 0 {
+		return nil
+	}
+
+	if err := os.Chmod(dst, info.Mode()&(os.ModePerm|os.ModeSetuid|os.ModeSetgid|os.ModeSticky)); err != nil {
+		return err
+	}
+
+	return os.Chtimes(dst, info.ModTime(), info.ModTime())
+}
+
+// secureCreatePath resolves the parent of p inside root but never follows the last
+// component, which is about to be replaced.
+func secureCreatePath(root string, p string) (string, error) {
+	p = path.Clean("/" + p)
+	if p == "/" {
+		return filepath.Clean(root), nil
+	}
+
+	parent, err := SecureJoin(root, path.Dir(p))
+	if err != nil {
+		return "", err
+	}
+
+	return path.Join(parent, path.Base(p)), nil
+}
+
+func copyEntry(src string, dst string, info fs.FileInfo) error {
+	if l, err := os.Lstat(dst); err == nil && l.Mode()&os.ModeSymlink != 0 {
+		if err := os.Remove(dst); err != nil {
+			return err
+		}
+	}
+
+	switch m := info.Mode(); {
+	case m.IsDir():
+		if err := os.Mkdir(dst, 0700); err != nil && !os.IsExist(err) {
+			return err
+		}
+	case m&os.ModeSymlink != 0:
+		target, err := os.Readlink(src)
+		if err != nil {
+			return err
+		}
+
+		if err := os.Symlink(target, dst); err != nil {
+			return err
+		}
+	case m.IsRegular():
+		if err := copyFile(src, dst, info); err != nil {
+			return err
+		}
+	default:
+		st, ok := info.Sys().(*syscall.Stat_t)
+		if !ok {
+			return errors.New("unsupported file type")
+		}
+
+		os.Remove(dst)
+		if err := unix.Mknod(dst, st.Mode, int(st.Rdev)); err != nil {
+			return err
+		}
+	}
+
+	return copyAttributes(dst, info)
+}
+
+// CopyTree recursively copies src to dst preserving ownership, modes and timestamps.
+// Every destination path is resolved with SecureJoin against dstRoot so symlinks
+// present in the destination cannot redirect writes outside of it. Symlinks in the
+// source are copied as links and never followed.
+func CopyTree(src string, dstRoot string, dst string) error {
+	var dirs []string
+	var infos []fs.FileInfo
+
+	err := filepath.Walk(src, func(f string, info fs.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+
+		rel, err := filepath.Rel(src, f)
+		if err != nil {
+			return err
+		}
+
+		d, err := secureCreatePath(dstRoot, path.Join(dst, rel))
+		if err != nil {
+			return err
+		}
+
+		if err := copyEntry(f, d, info); err != nil {
+			return err
+		}
+
+		// Directory modes are restored last so read-only directories can be filled
+		if info.IsDir() {
+			dirs = append(dirs, d)
+			infos = append(infos, info)
+		}
+
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+
+	for i := len(dirs) - 1; i >= 0; i-- {
+		if err := copyAttributes(dirs[i], infos[i]); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
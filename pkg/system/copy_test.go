@@ -0,0 +1,98 @@
+// SPDX-License-Identifier: BSD-2
+// Copyright 2023 VMware, Inc.
+
+package system
+
+import (
+	"os"
+	"path"
+	"testing"
+)
+
+func TestSecureJoin(t *testing.T) {
+	root := t.TempDir()
+	for _, d := range []string{"etc", "usr/lib", "var"} {
+		if err := os.MkdirAll(path.Join(root, d), 0755); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	links := map[string]string{
+		"abs":          "/etc",
+		"rel":          "usr/lib",
+		"escape":       "../../../../tmp",
+		"escape-abs":   "/../../tmp",
+		"var/up":       "../etc",
+		"var/passwd":   "/etc/passwd",
+		"chain":        "abs",
+		"loop":         "loop",
+		"dangling":     "/nonexistent/file",
+		"usr/lib/root": "/",
+	}
+	for l, target := range links {
+		if err := os.Symlink(target, path.Join(root, l)); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	tests := []struct {
+		p    string
+		want string
+	}{
+		{"/", ""},
+		{"etc/hosts", "etc/hosts"},
+		{"/etc/../../..", ""},
+		{"../../etc/shadow", "etc/shadow"},
+		{"abs/hosts", "etc/hosts"},
+		{"rel/x", "usr/lib/x"},
+		{"escape/x", "tmp/x"},
+		{"escape-abs", "tmp"},
+		{"var/up/hosts", "etc/hosts"},
+		{"var/passwd", "etc/passwd"},
+		{"chain/hosts", "etc/hosts"},
+		{"dangling", "nonexistent/file"},
+		{"usr/lib/root/etc", "etc"},
+		{"new/dir/file", "new/dir/file"},
+	}
+
+	for _, tt := range tests {
+		got, err := SecureJoin(root, tt.p)
+		if err != nil {
+			t.Errorf("SecureJoin(%q) failed: %v", tt.p, err)
+			continue
+		}
+		if want := path.Join(root, tt.want); got != want {
+			t.Errorf("SecureJoin(%q) = %q, want %q", tt.p, got, want)
+		}
+	}
+
+	if _, err := SecureJoin(root, "loop/x"); err == nil {
+		t.Error("SecureJoin of a symlink loop succeeded")
+	}
+}
+
+func TestCopyTreeSymlinkInDestination(t *testing.T) {
+	src, root, outside := t.TempDir(), t.TempDir(), t.TempDir()
+
+	if err := os.WriteFile(path.Join(src, "file"), []byte("data"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	// A link planted in the container must not redirect the copy to the host
+	if err := os.Symlink(outside, path.Join(root, "dst")); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.MkdirAll(path.Join(root, outside), 0755); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := CopyTree(src, root, "dst/sub"); err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := os.Stat(path.Join(outside, "sub/file")); err == nil {
+		t.Fatal("copy followed a symlink out of the destination root")
+	}
+	if b, err := os.ReadFile(path.Join(root, outside, "sub/file")); err != nil || string(b) != "data" {
+		t.Errorf("copied file not below the destination root: %v", err)
+	}
+}
@@ -5,60 +5,118 @@ package system
 
 import (
 	"bytes"
+	"context"
+	"errors"
 	"fmt"
 	"io"
+	"io/fs"
 	"os"
 	"os/exec"
+	"os/signal"
+	"path"
+	"strings"
+	"syscall"
 
-	"golang.org/x/sys/unix"
+	"github.com/vmware-samples/photon-os-container-builder/pkg/errdefs"
 )
 
+// execError adds the command to err and marks a missing binary as missing dependency.
+// out is the output of a failed command, its last line usually names the problem.
+func execError(cmd string, err error, out []byte) error {
+	if errors.Is(err, exec.ErrNotFound) || errors.Is(err, fs.ErrNotExist) {
+		return errdefs.Errorf(errdefs.ErrDependency, "'%s' is not installed: %w", cmd, err)
+	}
+
+	if l := strings.TrimSpace(string(out)); l != "" {
+		if i := strings.LastIndexByte(l, '\n'); i >= 0 {
+			l = l[i+1:]
+		}
+		return fmt.Errorf("'%s' failed: %w: %s", path.Base(cmd), err, l)
+	}
+
+	return fmt.Errorf("'%s' failed: %w", path.Base(cmd), err)
+}
+
 func ExecAndDisplay(w io.Writer, cmd string, args ...string) error {
-	c := exec.Command(cmd, args...)
-	if out, err := c.CombinedOutput(); err != nil {
-		return err
-	} else {
-		fmt.Fprintf(w, "%s\n", out)
-		return nil
+	var out bytes.Buffer
+	if err := CurrentExecutor().Run(context.Background(), nil, &out, &out, cmd, args...); err != nil {
+		return execError(cmd, err, out.Bytes())
 	}
+
+	if out.Len() > 0 {
+		fmt.Fprintf(w, "%s\n", out.Bytes())
+	}
+	return nil
 }
 
+// ExecAndCapture runs cmd, which only reads the state of the host, and returns its
+// combined output. It runs in dry-run mode too.
 func ExecAndCapture(cmd string, args ...string) (string, error) {
-	c := exec.Command(cmd, args...)
-	if out, err := c.CombinedOutput(); err != nil {
-		return "", err
-	} else {
-		return string(out), nil
+	var out bytes.Buffer
+	if err := CurrentExecutor().Query(context.Background(), &out, &out, cmd, args...); err != nil {
+		return "", execError(cmd, err, out.Bytes())
 	}
+
+	return out.String(), nil
 }
 
 func ExecAndRenounce(cmds ...string) error {
-	binary, err := exec.LookPath(cmds[0])
-	if err != nil {
-		return nil
+	if err := CurrentExecutor().Replace(cmds...); err != nil {
+		return execError(cmds[0], err, nil)
 	}
 
-	return unix.Exec(binary, cmds, unix.Environ())
+	return nil
+}
 
+// ExecAndWait runs argv attached to the terminal and waits for it, where the caller
+// has to outlive the command, for example to hold a lock. SIGTERM and SIGHUP, which
+// systemd sends to the main process of a service, are passed on to the command, an
+// interrupt from the terminal reaches it directly. A command that fails with an exit
+// status returns *exec.ExitError.
+func ExecAndWait(argv ...string) error {
+	return Do(CommandLine(argv...), func() error {
+		signals := make(chan os.Signal, 1)
+		signal.Notify(signals, syscall.SIGTERM, syscall.SIGHUP, syscall.SIGINT)
+		defer signal.Stop(signals)
+
+		c := exec.Command(argv[0], argv[1:]...)
+		c.Stdin = os.Stdin
+		c.Stdout = os.Stdout
+		c.Stderr = os.Stderr
+		if err := c.Start(); err != nil {
+			return execError(argv[0], err, nil)
+		}
+
+		done := make(chan error, 1)
+		go func() { done <- c.Wait() }()
+
+		for {
+			select {
+			case s := <-signals:
+				if s != syscall.SIGINT {
+					c.Process.Signal(s)
+				}
+			case err := <-done:
+				return err
+			}
+		}
+	})
 }
 
 func ExecRun(cmd string, args ...string) error {
-	c := exec.Command(cmd, args...)
-	if err := c.Start(); err != nil {
-		return nil
+	if err := CurrentExecutor().Start(cmd, args...); err != nil {
+		return execError(cmd, err, nil)
 	}
 	return nil
 }
 
 func ExecAndShowProgress(cmd string, args ...string) error {
-	c := exec.Command(cmd, args...)
-
 	var stdoutBuf, stderrBuf bytes.Buffer
-	c.Stdout = io.MultiWriter(os.Stdout, &stdoutBuf)
-	c.Stderr = io.MultiWriter(os.Stderr, &stderrBuf)
+	stdout := io.MultiWriter(os.Stdout, &stdoutBuf)
+	stderr := io.MultiWriter(os.Stderr, &stderrBuf)
 
-	if err := c.Run(); err != nil {
-		return err
+	if err := CurrentExecutor().Run(context.Background(), nil, stdout, stderr, cmd, args...); err != nil {
+		return execError(cmd, err, stderrBuf.Bytes())
 	}
 
 	out, err := string(stdoutBuf.String()), string(stderrBuf.String())
@@ -67,21 +125,30 @@ func ExecAndShowProgress(cmd string, args ...string) error {
 	return nil
 }
 
-func ExecInteractive(cmd string, args ...string) error {
-	c := exec.Command(cmd, args...)
+// ExecAndStream runs cmd and writes its combined output to w while it runs.
+func ExecAndStream(w io.Writer, cmd string, args ...string) error {
+	return ExecContextAndStream(context.Background(), w, cmd, args...)
+}
+
+func ExecContextAndStream(ctx context.Context, w io.Writer, cmd string, args ...string) error {
+	if err := CurrentExecutor().Run(ctx, nil, w, w, cmd, args...); err != nil {
+		return execError(cmd, err, nil)
+	}
 
-	var stdoutBuf, stderrBuf, stdinBuf bytes.Buffer
-	c.Stdout = io.MultiWriter(os.Stdout, &stdoutBuf)
-	c.Stderr = io.MultiWriter(os.Stderr, &stderrBuf)
-	c.Stdin = io.MultiReader(os.Stdin, &stdinBuf)
+	return nil
+}
+
+func ExecInteractive(cmd string, args ...string) error {
+	var stdoutBuf, stderrBuf bytes.Buffer
+	stdout := io.MultiWriter(os.Stdout, &stdoutBuf)
+	stderr := io.MultiWriter(os.Stderr, &stderrBuf)
 
-	if err := c.Run(); err != nil {
-		return err
+	if err := CurrentExecutor().Run(context.Background(), os.Stdin, stdout, stderr, cmd, args...); err != nil {
+		return execError(cmd, err, stderrBuf.Bytes())
 	}
 
 	out, err := string(stdoutBuf.String()), string(stderrBuf.String())
 	fmt.Printf("\n%s\n%s\n", out, err)
-	c.Wait()
 
 	return nil
 }
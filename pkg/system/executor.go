@@ -0,0 +1,409 @@
+// SPDX-License-Identifier: BSD-2
+// Copyright 2023 VMware, Inc.
+
+package system
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"io/fs"
+	"os"
+	"os/exec"
+	"strings"
+	"sync"
+
+	"golang.org/x/sys/unix"
+)
+
+// Executor performs everything that changes the host: commands run by the Exec*
+// helpers, file writes, mounts and other actions such as D-Bus calls. Replacing it
+// previews those changes with DryRunExecutor or records them with RecordingExecutor.
+type Executor interface {
+	// Run runs cmd to completion. Any of stdin, stdout and stderr may be nil.
+	Run(ctx context.Context, stdin io.Reader, stdout io.Writer, stderr io.Writer, cmd string, args ...string) error
+	// Query runs cmd, which only reads the state of the host, to completion.
+	Query(ctx context.Context, stdout io.Writer, stderr io.Writer, cmd string, args ...string) error
+	// Start starts cmd without waiting for it to finish.
+	Start(cmd string, args ...string) error
+	// Replace replaces the current process with argv, argv[0] is looked up in $PATH.
+	Replace(argv ...string) error
+
+	WriteFile(name string, data []byte, perm fs.FileMode) error
+	MkdirAll(name string, perm fs.FileMode) error
+	Remove(name string) error
+	RemoveAll(name string) error
+	Chmod(name string, mode fs.FileMode) error
+	Chown(name string, uid int, gid int) error
+	Mount(source string, target string, fstype string, flags uintptr, data string) error
+	Unmount(target string, flags int) error
+
+	// Do performs action, described by what in the syntax of an equivalent command.
+	Do(what string, action func() error) error
+}
+
+var (
+	executorMu sync.RWMutex
+	executor   Executor = HostExecutor{}
+)
+
+// SetExecutor replaces the executor used by the helpers of this package and returns
+// the previous one.
+func SetExecutor(e Executor) Executor {
+	executorMu.Lock()
+	defer executorMu.Unlock()
+
+	old := executor
+	executor = e
+	return old
+}
+
+// CurrentExecutor returns the executor used by the helpers of this package.
+func CurrentExecutor() Executor {
+	executorMu.RLock()
+	defer executorMu.RUnlock()
+
+	return executor
+}
+
+// DryRun reports whether changes are only printed or recorded, so there is nothing
+// on the host to journal, lock or verify.
+func DryRun() bool {
+	switch CurrentExecutor().(type) {
+	case *DryRunExecutor, *RecordingExecutor:
+		return true
+	}
+
+	return false
+}
+
+func WriteFile(name string, data []byte, perm fs.FileMode) error {
+	return CurrentExecutor().WriteFile(name, data, perm)
+}
+
+func MkdirAll(name string, perm fs.FileMode) error {
+	return CurrentExecutor().MkdirAll(name, perm)
+}
+
+func Remove(name string) error {
+	return CurrentExecutor().Remove(name)
+}
+
+func RemoveAll(name string) error {
+	return CurrentExecutor().RemoveAll(name)
+}
+
+func Chmod(name string, mode fs.FileMode) error {
+	return CurrentExecutor().Chmod(name, mode)
+}
+
+func Chown(name string, uid int, gid int) error {
+	return CurrentExecutor().Chown(name, uid, gid)
+}
+
+func BindMount(source string, target string) error {
+	return CurrentExecutor().Mount(source, target, "", unix.MS_BIND, "")
+}
+
+func Unmount(target string, flags int) error {
+	return CurrentExecutor().Unmount(target, flags)
+}
+
+// Do performs action through the current executor, see Executor.Do.
+func Do(what string, action func() error) error {
+	return CurrentExecutor().Do(what, action)
+}
+
+// HostExecutor changes the host.
+type HostExecutor struct{}
+
+func (HostExecutor) Run(ctx context.Context, stdin io.Reader, stdout io.Writer, stderr io.Writer, cmd string, args ...string) error {
+	c := exec.CommandContext(ctx, cmd, args...)
+	c.Stdin = stdin
+	c.Stdout = stdout
+	c.Stderr = stderr
+
+	return c.Run()
+}
+
+func (h HostExecutor) Query(ctx context.Context, stdout io.Writer, stderr io.Writer, cmd string, args ...string) error {
+	return h.Run(ctx, nil, stdout, stderr, cmd, args...)
+}
+
+func (HostExecutor) Start(cmd string, args ...string) error {
+	return exec.Command(cmd, args...).Start()
+}
+
+func (HostExecutor) Replace(argv ...string) error {
+	binary, err := exec.LookPath(argv[0])
+	if err != nil {
+		return err
+	}
+
+	return unix.Exec(binary, argv, unix.Environ())
+}
+
+func (HostExecutor) WriteFile(name string, data []byte, perm fs.FileMode) error {
+	return os.WriteFile(name, data, perm)
+}
+
+func (HostExecutor) MkdirAll(name string, perm fs.FileMode) error {
+	return os.MkdirAll(name, perm)
+}
+
+func (HostExecutor) Remove(name string) error {
+	return os.Remove(name)
+}
+
+func (HostExecutor) RemoveAll(name string) error {
+	return os.RemoveAll(name)
+}
+
+func (HostExecutor) Chmod(name string, mode fs.FileMode) error {
+	return os.Chmod(name, mode)
+}
+
+func (HostExecutor) Chown(name string, uid int, gid int) error {
+	return os.Chown(name, uid, gid)
+}
+
+func (HostExecutor) Mount(source string, target string, fstype string, flags uintptr, data string) error {
+	return unix.Mount(source, target, fstype, flags, data)
+}
+
+func (HostExecutor) Unmount(target string, flags int) error {
+	return unix.Unmount(target, flags)
+}
+
+func (HostExecutor) Do(what string, action func() error) error {
+	return action()
+}
+
+// DryRunExecutor prints the plan as shell commands to W and changes nothing. Commands
+// succeed without output, file contents are printed as here documents. Queries run on
+// the host.
+type DryRunExecutor struct {
+	W  io.Writer
+	mu sync.Mutex
+}
+
+func NewDryRunExecutor(w io.Writer) *DryRunExecutor {
+	return &DryRunExecutor{W: w}
+}
+
+// quote returns s quoted for the shell when it needs to be.
+func quote(s string) string {
+	if s != "" && strings.IndexFunc(s, func(r rune) bool {
+		return !(r >= 'a' && r <= 'z' || r >= 'A' && r <= 'Z' || r >= '0' && r <= '9' || strings.ContainsRune("-_=+,./:@%", r))
+	}) < 0 {
+		return s
+	}
+
+	return "'" + strings.ReplaceAll(s, "'", `'\''`) + "'"
+}
+
+// CommandLine joins argv quoted for the shell.
+func CommandLine(argv ...string) string {
+	q := make([]string, len(argv))
+	for i, a := range argv {
+		q[i] = quote(a)
+	}
+
+	return strings.Join(q, " ")
+}
+
+func (e *DryRunExecutor) plan(argv ...string) error {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+
+	fmt.Fprintf(e.W, "+ %s\n", CommandLine(argv...))
+	return nil
+}
+
+func (e *DryRunExecutor) Run(ctx context.Context, stdin io.Reader, stdout io.Writer, stderr io.Writer, cmd string, args ...string) error {
+	return e.plan(append([]string{cmd}, args...)...)
+}
+
+func (e *DryRunExecutor) Query(ctx context.Context, stdout io.Writer, stderr io.Writer, cmd string, args ...string) error {
+	return HostExecutor{}.Query(ctx, stdout, stderr, cmd, args...)
+}
+
+func (e *DryRunExecutor) Start(cmd string, args ...string) error {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+
+	fmt.Fprintf(e.W, "+ %s &\n", CommandLine(append([]string{cmd}, args...)...))
+	return nil
+}
+
+func (e *DryRunExecutor) Replace(argv ...string) error {
+	return e.plan(append([]string{"exec"}, argv...)...)
+}
+
+func (e *DryRunExecutor) WriteFile(name string, data []byte, perm fs.FileMode) error {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+
+	s := string(data)
+	if s != "" && !strings.HasSuffix(s, "\n") {
+		s += "\n"
+	}
+
+	fmt.Fprintf(e.W, "+ install -m %o /dev/stdin %s <<'EOF'\n%sEOF\n", perm, quote(name), s)
+	return nil
+}
+
+func (e *DryRunExecutor) MkdirAll(name string, perm fs.FileMode) error {
+	return e.plan("mkdir", "-p", "-m", fmt.Sprintf("%o", perm), name)
+}
+
+func (e *DryRunExecutor) Remove(name string) error {
+	return e.plan("rm", "-f", name)
+}
+
+func (e *DryRunExecutor) RemoveAll(name string) error {
+	return e.plan("rm", "-rf", name)
+}
+
+func (e *DryRunExecutor) Chmod(name string, mode fs.FileMode) error {
+	return e.plan("chmod", fmt.Sprintf("%o", mode), name)
+}
+
+func (e *DryRunExecutor) Chown(name string, uid int, gid int) error {
+	return e.plan("chown", fmt.Sprintf("%d:%d", uid, gid), name)
+}
+
+func (e *DryRunExecutor) Mount(source string, target string, fstype string, flags uintptr, data string) error {
+	if flags&unix.MS_BIND != 0 {
+		return e.plan("mount", "--bind", source, target)
+	}
+
+	args := []string{"mount"}
+	if fstype != "" {
+		args = append(args, "-t", fstype)
+	}
+	if data != "" {
+		args = append(args, "-o", data)
+	}
+
+	return e.plan(append(args, source, target)...)
+}
+
+func (e *DryRunExecutor) Unmount(target string, flags int) error {
+	if flags&unix.MNT_DETACH != 0 {
+		return e.plan("umount", "-l", target)
+	}
+
+	return e.plan("umount", target)
+}
+
+func (e *DryRunExecutor) Do(what string, action func() error) error {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+
+	fmt.Fprintf(e.W, "+ %s\n", what)
+	return nil
+}
+
+// RecordingExecutor records the changes it is asked to make as command lines and
+// makes none, for tests. Queries are answered from Output by command line, queries
+// without an entry fail with fs.ErrNotExist. Files holds the content of the files
+// written, by name.
+type RecordingExecutor struct {
+	Output map[string]string
+	Files  map[string][]byte
+
+	mu    sync.Mutex
+	plans []string
+}
+
+func NewRecordingExecutor() *RecordingExecutor {
+	return &RecordingExecutor{Output: map[string]string{}, Files: map[string][]byte{}}
+}
+
+// Plans returns the command lines recorded so far.
+func (e *RecordingExecutor) Plans() []string {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+
+	return append([]string(nil), e.plans...)
+}
+
+func (e *RecordingExecutor) plan(argv ...string) error {
+	return e.Do(CommandLine(argv...), nil)
+}
+
+func (e *RecordingExecutor) Run(ctx context.Context, stdin io.Reader, stdout io.Writer, stderr io.Writer, cmd string, args ...string) error {
+	return e.plan(append([]string{cmd}, args...)...)
+}
+
+func (e *RecordingExecutor) Query(ctx context.Context, stdout io.Writer, stderr io.Writer, cmd string, args ...string) error {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+
+	out, ok := e.Output[CommandLine(append([]string{cmd}, args...)...)]
+	if !ok {
+		return fs.ErrNotExist
+	}
+
+	if stdout != nil {
+		io.WriteString(stdout, out)
+	}
+	return nil
+}
+
+func (e *RecordingExecutor) Start(cmd string, args ...string) error {
+	return e.plan(append([]string{cmd}, args...)...)
+}
+
+func (e *RecordingExecutor) Replace(argv ...string) error {
+	return e.plan(append([]string{"exec"}, argv...)...)
+}
+
+func (e *RecordingExecutor) WriteFile(name string, data []byte, perm fs.FileMode) error {
+	e.mu.Lock()
+	e.Files[name] = append([]byte(nil), data...)
+	e.mu.Unlock()
+
+	return e.plan("install", "-m", fmt.Sprintf("%o", perm), "/dev/stdin", name)
+}
+
+func (e *RecordingExecutor) MkdirAll(name string, perm fs.FileMode) error {
+	return e.plan("mkdir", "-p", "-m", fmt.Sprintf("%o", perm), name)
+}
+
+func (e *RecordingExecutor) Remove(name string) error {
+	return e.plan("rm", "-f", name)
+}
+
+func (e *RecordingExecutor) RemoveAll(name string) error {
+	return e.plan("rm", "-rf", name)
+}
+
+func (e *RecordingExecutor) Chmod(name string, mode fs.FileMode) error {
+	return e.plan("chmod", fmt.Sprintf("%o", mode), name)
+}
+
+func (e *RecordingExecutor) Chown(name string, uid int, gid int) error {
+	return e.plan("chown", fmt.Sprintf("%d:%d", uid, gid), name)
+}
+
+func (e *RecordingExecutor) Mount(source string, target string, fstype string, flags uintptr, data string) error {
+	if flags&unix.MS_BIND != 0 {
+		return e.plan("mount", "--bind", source, target)
+	}
+
+	return e.plan("mount", "-t", fstype, source, target)
+}
+
+func (e *RecordingExecutor) Unmount(target string, flags int) error {
+	return e.plan("umount", target)
+}
+
+func (e *RecordingExecutor) Do(what string, action func() error) error {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+
+	e.plans = append(e.plans, what)
+	return nil
+}
@@ -6,10 +6,14 @@ package system
 import (
 	"bufio"
 	"errors"
+	"fmt"
+	"io/fs"
 	"os"
 	"path"
 	"path/filepath"
+	"strconv"
 	"strings"
+	"syscall"
 )
 
 const (
@@ -20,26 +24,23 @@ const (
 )
 
 func DisableNetworkd(c string) {
-	os.Remove(path.Join(c, NetworkdService))
-	os.Remove(path.Join(c, NetworkdDBus))
-	os.Remove(path.Join(c, NetworkdOnline))
-	os.Remove(path.Join(c, NetworkdSocket))
+	Remove(path.Join(c, NetworkdService))
+	Remove(path.Join(c, NetworkdDBus))
+	Remove(path.Join(c, NetworkdOnline))
+	Remove(path.Join(c, NetworkdSocket))
 }
 
 func RecursiveChmod(path string, mode os.FileMode) error {
-	err := filepath.Walk(path, func(path string, info os.FileInfo, err error) error {
-		if err != nil {
-			return err
-		}
-
-		os.Chmod(path, mode)
-		return nil
+	return Do(CommandLine("chmod", "-R", fmt.Sprintf("%o", mode), path), func() error {
+		return filepath.Walk(path, func(path string, info os.FileInfo, err error) error {
+			if err != nil {
+				return err
+			}
+
+			os.Chmod(path, mode)
+			return nil
+		})
 	})
-	if err != nil {
-		return err
-	}
-
-	return nil
 }
 
 func PathExists(path string) bool {
@@ -53,11 +54,11 @@ func CreateDirectory(parent string, dir string) (err error) {
 		return errors.New("dir exists")
 	}
 
-	return os.MkdirAll(d, 0755)
+	return MkdirAll(d, 0755)
 }
 
 func RemoveDir(d string) {
-	os.RemoveAll(d)
+	RemoveAll(d)
 }
 
 func FilePathWalkDir(root string) (map[string]bool, error) {
@@ -138,3 +139,74 @@ func ReadLines(path string) ([]string, error) {
 
 	return lines, scanner.Err()
 }
+
+// DirSize returns the disk usage of a tree, counting hard linked files once.
+func DirSize(root string) (int64, error) {
+	var size int64
+
+	seen := make(map[uint64]bool)
+	err := filepath.WalkDir(root, func(f string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return nil
+		}
+
+		info, err := d.Info()
+		if err != nil {
+			return nil
+		}
+
+		st, ok := info.Sys().(*syscall.Stat_t)
+		if !ok {
+			size += info.Size()
+			return nil
+		}
+
+		if st.Nlink > 1 && !d.IsDir() {
+			if seen[st.Ino] {
+				return nil
+			}
+			seen[st.Ino] = true
+		}
+
+		size += st.Blocks * 512
+		return nil
+	})
+
+	return size, err
+}
+
+func FormatBytes(b int64) string {
+	const unit = 1024
+	if b < unit {
+		return fmt.Sprintf("%dB", b)
+	}
+
+	div, exp := int64(unit), 0
+	for n := b / unit; n >= unit; n /= unit {
+		div *= unit
+		exp++
+	}
+
+	return fmt.Sprintf("%.1f%c", float64(b)/float64(div), "KMGTPE"[exp])
+}
+
+// ParseBytes parses a size as printed by FormatBytes, with an optional K, M, G or T
+// suffix of powers of 1024.
+func ParseBytes(s string) (int64, error) {
+	n := strings.TrimSuffix(strings.ToUpper(strings.TrimSpace(s)), "B")
+
+	mult := int64(1)
+	if n != "" {
+		if i := strings.IndexByte("KMGT", n[len(n)-1]); i >= 0 {
+			mult <<= 10 * (i + 1)
+			n = n[:len(n)-1]
+		}
+	}
+
+	v, err := strconv.ParseFloat(n, 64)
+	if err != nil || v <= 0 {
+		return 0, fmt.Errorf("invalid size '%s', expected bytes with optional K, M, G or T suffix", s)
+	}
+
+	return int64(v * float64(mult)), nil
+}
@@ -4,26 +4,94 @@
 package system
 
 import (
-	"os"
+	"bytes"
+	"fmt"
 	"path"
+	"strings"
 
+	"github.com/vmware-samples/photon-os-container-builder/pkg/conf"
 	"github.com/vmware-samples/photon-os-container-builder/pkg/keyfile"
 )
 
-func CreateUnitFile(container string, network string, link string, machine string, ephemeral bool) error {
-	m, err := keyfile.Create(path.Join("/lib/systemd/system", container+".service"))
+// UnitDir is the directory service and target units of containers are written to. It
+// is set from System.UnitDir of the configuration.
+var UnitDir = conf.DefaultUnitFilePath
+
+// UnitOptions places a container's service unit into a group of containers: the group
+// target pulls it in and stops it, Requires and After order it against other
+// containers and Binds/ReadOnlyBinds are passed on to systemd-nspawn. Directory is
+// the root of the container and the limits are set on the service. StorageDir and
+// Security are the effective settings at spawn time, passed to cntrctl boot through
+// the environment, so the container boots as configured when it was spawned.
+type UnitOptions struct {
+	Description   string
+	Target        string
+	Slice         string
+	Requires      []string
+	After         []string
+	Binds         []string
+	ReadOnlyBinds []string
+
+	Directory  string
+	StorageDir string
+	Security   string
+	CPUQuota   string
+	MemoryMax  string
+	TasksMax   string
+}
+
+func CreateUnitFile(container string, network string, link string, machine string, ephemeral bool, o *UnitOptions) error {
+	m, err := keyfile.Create(UnitFilePath(container))
 	if err != nil {
 		return err
 	}
 
-	m.SetKeySectionString("Unit", "Description", "Photon OS container")
+	if o == nil {
+		o = &UnitOptions{}
+	}
+
+	description := "Photon OS container"
+	if o.Description != "" {
+		description = o.Description
+	}
+
+	partOf := "machines.target"
+	wantedBy := "machines.target"
+	if o.Target != "" {
+		partOf += " " + o.Target
+		wantedBy = o.Target
+	}
+
+	after := "network.target systemd-resolved.service modprobe@tun.service modprobe@loop.service modprobe@dm-mod.service"
+	for _, a := range o.After {
+		after += " " + a
+	}
+
+	slice := "machine.slice"
+	if o.Slice != "" {
+		slice = o.Slice
+	}
+
+	tasksMax := conf.DefaultTasksMax
+	if o.TasksMax != "" {
+		tasksMax = o.TasksMax
+	}
+
+	m.SetKeySectionString("Unit", "Description", description)
 	m.SetKeySectionString("Unit", "Documentation", "man:cntrctl(1)")
 	m.SetKeySectionString("Unit", "Wants", "modprobe@tun.service modprobe@loop.service modprobe@dm-mod.service")
-	m.SetKeySectionString("Unit", "PartOf", "machines.target")
+	if len(o.Requires) > 0 {
+		m.SetKeySectionString("Unit", "Requires", strings.Join(o.Requires, " "))
+	}
+	m.SetKeySectionString("Unit", "PartOf", partOf)
 	m.SetKeySectionString("Unit", "Before", "machines.target")
-	m.SetKeySectionString("Unit", "After", "network.target systemd-resolved.service modprobe@tun.service modprobe@loop.service modprobe@dm-mod.service")
+	m.SetKeySectionString("Unit", "After", after)
+	if o.Directory != "" {
+		m.SetKeySectionString("Unit", "RequiresMountsFor", o.Directory)
+	}
 
-	execStart := "/usr/bin/cntrctl boot "
+	// The payload stays in the service, so its slice and limits apply
+	execStart := "/usr/bin/cntrctl boot --keep-unit "
 	if machine != "" {
 		execStart += "-m " + machine + " "
 	}
@@ -36,15 +104,39 @@ func CreateUnitFile(container string, network string, link string, machine strin
 	if link != "" {
 		execStart += "-l " + link + " "
 	}
+	for _, b := range o.Binds {
+		execStart += "--bind " + quoteUnitArg(b) + " "
+	}
+	for _, b := range o.ReadOnlyBinds {
+		execStart += "--bind-ro " + quoteUnitArg(b) + " "
+	}
 
 	m.SetKeySectionString("Service", "ExecStart", execStart+container)
+	var env []string
+	if o.StorageDir != "" {
+		env = append(env, quoteUnitArg("CNTRCTL_SYSTEM_STORAGEDIR="+o.StorageDir))
+	}
+	if o.Security != "" {
+		env = append(env, quoteUnitArg("CNTRCTL_SECURITY_PROFILE="+o.Security))
+	}
+	if len(env) > 0 {
+		m.SetKeySectionString("Service", "Environment", strings.Join(env, " "))
+	}
 	m.SetKeySectionString("Service", "KillMode", "mixed")
 	m.SetKeySectionString("Service", "Type", "notify")
+	// systemd-nspawn runs as a child of cntrctl boot and reports readiness itself
+	m.SetKeySectionString("Service", "NotifyAccess", "all")
 	m.SetKeySectionString("Service", "RestartForceExitStatus", "133")
 	m.SetKeySectionString("Service", "SuccessExitStatus", "133")
-	m.SetKeySectionString("Service", "Slice", "machine.slice")
+	m.SetKeySectionString("Service", "Slice", slice)
 	m.SetKeySectionString("Service", "Delegate", "yes")
-	m.SetKeySectionString("Service", "TasksMax", "16384")
+	m.SetKeySectionString("Service", "TasksMax", tasksMax)
+	if o.CPUQuota != "" {
+		m.SetKeySectionString("Service", "CPUQuota", o.CPUQuota)
+	}
+	if o.MemoryMax != "" {
+		m.SetKeySectionString("Service", "MemoryMax", o.MemoryMax)
+	}
 	m.SetKeySectionString("Service", "DevicePolicy", "closed")
 	m.SetKeySectionString("Service", "DeviceAllow", "/dev/net/tun rwm")
 	m.SetKeySectionString("Service", "DeviceAllow", "char-pts rw")
@@ -54,22 +146,161 @@ func CreateUnitFile(container string, network string, link string, machine strin
 	m.SetKeySectionString("Service", "DeviceAllow", "block-device-mapper rw")
 	m.SetKeySectionString("Service", "DeviceAllow", "/dev/mapper/control rw")
 
+	m.SetKeySectionString("Install", "WantedBy", wantedBy)
+	return saveKeyFile(m)
+}
+
+// quoteUnitArg quotes s for a unit file setting split at whitespace, such as ExecStart
+// or Environment, when it contains characters the split would act on.
+func quoteUnitArg(s string) string {
+	if !strings.ContainsAny(s, " \t\"'\\") {
+		return s
+	}
+
+	return `"` + strings.NewReplacer(`\`, `\\`, `"`, `\"`).Replace(s) + `"`
+}
+
+// splitUnitArgs splits a unit file setting such as ExecStart into words as systemd
+// does, undoing quoteUnitArg: words are separated by whitespace, double and single
+// quotes group them and a backslash escapes the character that follows.
+func splitUnitArgs(s string) []string {
+	var r []string
+	var b strings.Builder
+	word, quote := false, byte(0)
+	for i := 0; i < len(s); i++ {
+		ch := s[i]
+		switch {
+		case ch == '\\' && i+1 < len(s) && quote != '\'':
+			i++
+			switch s[i] {
+			case 'n':
+				b.WriteByte('\n')
+			case 't':
+				b.WriteByte('\t')
+			default:
+				b.WriteByte(s[i])
+			}
+			word = true
+		case quote != 0:
+			if ch == quote {
+				quote = 0
+			} else {
+				b.WriteByte(ch)
+			}
+		case ch == '"' || ch == '\'':
+			quote, word = ch, true
+		case ch == ' ' || ch == '\t' || ch == '\n':
+			if word {
+				r = append(r, b.String())
+				b.Reset()
+				word = false
+			}
+		default:
+			b.WriteByte(ch)
+			word = true
+		}
+	}
+	if word {
+		r = append(r, b.String())
+	}
+
+	return r
+}
+
+// SetUnitDirectory points the service unit of container at its root directory dir
+// after it moved to another storage pool.
+func SetUnitDirectory(container string, dir string) error {
+	m, err := keyfile.Load(UnitFilePath(container))
+	if err != nil {
+		return err
+	}
+
+	m.SetKeySectionString("Unit", "RequiresMountsFor", dir)
+	return saveKeyFile(m)
+}
+
+func TargetFilePath(target string) string {
+	return path.Join(UnitDir, target)
+}
+
+// CreateTargetFile writes a target unit pulling in the service units of a group of
+// containers.
+func CreateTargetFile(target string, description string, wants []string) error {
+	m, err := keyfile.Create(TargetFilePath(target))
+	if err != nil {
+		return err
+	}
+
+	m.SetKeySectionString("Unit", "Description", description)
+	m.SetKeySectionString("Unit", "Documentation", "man:cntrctl(1)")
+	m.SetKeySectionString("Unit", "Wants", strings.Join(wants, " "))
+	m.SetKeySectionString("Unit", "PartOf", "machines.target")
+	m.SetKeySectionString("Unit", "Before", "machines.target")
+
+	m.SetKeySectionString("Install", "WantedBy", "machines.target")
+	return saveKeyFile(m)
+}
+
+// MountUnitName returns the name of the mount unit of where, as systemd-escape --path
+// derives it.
+func MountUnitName(where string) string {
+	p := strings.Trim(path.Clean(where), "/")
+	if p == "" {
+		return "-.mount"
+	}
+
+	var b strings.Builder
+	for i := 0; i < len(p); i++ {
+		c := p[i]
+		switch {
+		case c == '/':
+			b.WriteByte('-')
+		case c >= 'a' && c <= 'z' || c >= 'A' && c <= 'Z' || c >= '0' && c <= '9' || c == ':' || c == '_' || c == '.' && i > 0:
+			b.WriteByte(c)
+		default:
+			fmt.Fprintf(&b, "\\x%02x", c)
+		}
+	}
+
+	return b.String() + ".mount"
+}
+
+// CreateMountFile writes a mount unit mounting the file system image what with a loop
+// device on where. Service units requiring mounts for where pull it in.
+func CreateMountFile(where string, what string, description string) error {
+	m, err := keyfile.Create(path.Join(UnitDir, MountUnitName(where)))
+	if err != nil {
+		return err
+	}
+
+	m.SetKeySectionString("Unit", "Description", description)
+	m.SetKeySectionString("Unit", "Documentation", "man:cntrctl(1)")
+
+	m.SetKeySectionString("Mount", "What", what)
+	m.SetKeySectionString("Mount", "Where", where)
+	m.SetKeySectionString("Mount", "Type", "ext4")
+	m.SetKeySectionString("Mount", "Options", "loop")
+
 	m.SetKeySectionString("Install", "WantedBy", "machines.target")
-	return m.Save()
+	return saveKeyFile(m)
 }
 
 func RemoveUnitFile(container string) error {
-	unit := path.Join("/lib/systemd/system", container+".service")
-	if err := os.Remove(unit); err != nil {
+	if err := Remove(UnitFilePath(container)); err != nil {
 		return err
 	}
 
 	return nil
 }
 
-func CreateNetworkUnitFile(container string, network string, link string) error {
-	u := "10-" + container + ".network"
-	m, err := keyfile.Create(path.Join("/var/lib/machines/"+container+"/lib/systemd/network", u))
+func NetworkUnitFilePath(root string, container string) string {
+	return path.Join(root, "lib/systemd/network", "10-"+container+".network")
+}
+
+// CreateNetworkUnitFile writes the networkd configuration of the MACVLAN/IPVLAN
+// interface into the container rooted at root.
+func CreateNetworkUnitFile(root string, container string, network string, link string) error {
+	m, err := keyfile.Create(NetworkUnitFilePath(root, container))
 	if err != nil {
 		return err
 	}
@@ -82,11 +313,83 @@ func CreateNetworkUnitFile(container string, network string, link string) error
 	}
 
 	m.SetKeySectionString("Network", "DHCP", "yes")
-	m.Save()
+	if err := saveKeyFile(m); err != nil {
+		return err
+	}
+
+	return Chown(m.Path, 76, 76)
+}
 
-	if err := os.Chmod(m.Path, 0644); err != nil {
+// saveKeyFile writes m through the executor.
+func saveKeyFile(m *keyfile.Meta) error {
+	var b bytes.Buffer
+	if _, err := m.Cfg.WriteTo(&b); err != nil {
 		return err
 	}
 
-	return os.Chown(m.Path, 76, 76)
+	return WriteFile(m.Path, b.Bytes(), 0644)
+}
+
+type UnitConfig struct {
+	Path      string `json:"path"`
+	ExecStart string `json:"exec_start"`
+	Machine   string `json:"machine,omitempty"`
+	Network   string `json:"network,omitempty"`
+	Link      string `json:"link,omitempty"`
+	Ephemeral bool   `json:"ephemeral"`
+
+	Binds         []string `json:"binds,omitempty"`
+	ReadOnlyBinds []string `json:"read_only_binds,omitempty"`
+}
+
+func UnitFilePath(container string) string {
+	return path.Join(UnitDir, container+".service")
+}
+
+// LoadUnitFile parses the cntrctl boot options back out of the container's service unit.
+func LoadUnitFile(container string) (*UnitConfig, error) {
+	u := UnitConfig{
+		Path: UnitFilePath(container),
+	}
+
+	execStart, err := keyfile.ParseKeyFromSectionString(u.Path, "Service", "ExecStart")
+	if err != nil {
+		return nil, err
+	}
+	u.ExecStart = execStart
+
+	f := splitUnitArgs(execStart)
+	for i := range f {
+		next := ""
+		if i+1 < len(f) {
+			next = f[i+1]
+		}
+
+		switch f[i] {
+		case "-m":
+			u.Machine = next
+		case "-n":
+			u.Network = next
+		case "-l":
+			u.Link = next
+		case "-x":
+			u.Ephemeral = true
+		case "--bind":
+			u.Binds = append(u.Binds, next)
+		case "--bind-ro":
+			u.ReadOnlyBinds = append(u.ReadOnlyBinds, next)
+		}
+	}
+
+	return &u, nil
+}
+
+// MachineName returns the machine name the container's service unit boots with.
+func MachineName(container string) string {
+	u, err := LoadUnitFile(container)
+	if err != nil || u.Machine == "" {
+		return container
+	}
+
+	return u.Machine
 }
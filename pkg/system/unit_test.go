@@ -0,0 +1,181 @@
+// SPDX-License-Identifier: BSD-2
+// Copyright 2023 VMware, Inc.
+
+package system
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestSplitUnitArgs(t *testing.T) {
+	tests := []struct {
+		in   string
+		want []string
+	}{
+		{"", nil},
+		{"  a  b\tc ", []string{"a", "b", "c"}},
+		{`--bind "/a b:/c"`, []string{"--bind", "/a b:/c"}},
+		{`"say \"hi\"" 'x y' a\ b`, []string{`say "hi"`, "x y", "a b"}},
+		{`"C:\\dir"`, []string{`C:\dir`}},
+		{`""`, []string{""}},
+	}
+
+	for _, tt := range tests {
+		if got := splitUnitArgs(tt.in); !reflect.DeepEqual(got, tt.want) {
+			t.Errorf("splitUnitArgs(%q) = %q, want %q", tt.in, got, tt.want)
+		}
+	}
+}
+
+func TestQuoteUnitArgRoundTrip(t *testing.T) {
+	for _, s := range []string{"/srv", "/a b", `/it's`, `/"q"`, `/back\slash`, "/tab\there"} {
+		got := splitUnitArgs("--bind " + quoteUnitArg(s))
+		if len(got) != 2 || got[1] != s {
+			t.Errorf("round trip of %q = %q", s, got)
+		}
+	}
+}
+
+func TestLoadUnitFileBinds(t *testing.T) {
+	dir := UnitDir
+	UnitDir = t.TempDir()
+	defer func() { UnitDir = dir }()
+
+	o := UnitOptions{
+		Directory:     "/var/lib/machines/web",
+		Binds:         []string{"/srv/my data:/data", `/srv/"quoted"`},
+		ReadOnlyBinds: []string{"/etc/it's:/etc/x"},
+	}
+	if err := CreateUnitFile("web", "macvlan", "eth0", "web", false, &o); err != nil {
+		t.Fatal(err)
+	}
+
+	u, err := LoadUnitFile("web")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if !reflect.DeepEqual(u.Binds, o.Binds) {
+		t.Errorf("Binds = %q, want %q", u.Binds, o.Binds)
+	}
+	if !reflect.DeepEqual(u.ReadOnlyBinds, o.ReadOnlyBinds) {
+		t.Errorf("ReadOnlyBinds = %q, want %q", u.ReadOnlyBinds, o.ReadOnlyBinds)
+	}
+	if u.Machine != "web" || u.Network != "macvlan" || u.Link != "eth0" || u.Ephemeral {
+		t.Errorf("unexpected boot options %+v", u)
+	}
+}
+
+// unitKeys returns the values of a unit file by SECTION.KEY.
+func unitKeys(b []byte) map[string][]string {
+	keys := map[string][]string{}
+
+	section := ""
+	for _, l := range strings.Split(string(b), "\n") {
+		l = strings.TrimSpace(l)
+		if strings.HasPrefix(l, "[") {
+			section = strings.Trim(l, "[]")
+			continue
+		}
+
+		if k, v, ok := strings.Cut(l, "="); ok {
+			k = section + "." + strings.TrimSpace(k)
+			keys[k] = append(keys[k], strings.TrimSpace(v))
+		}
+	}
+
+	return keys
+}
+
+func TestCreateUnitFile(t *testing.T) {
+	tests := []struct {
+		name      string
+		network   string
+		link      string
+		machine   string
+		ephemeral bool
+		opts      *UnitOptions
+		want      map[string]string
+	}{
+		{
+			name: "defaults",
+			opts: &UnitOptions{Directory: "/var/lib/machines/web"},
+			want: map[string]string{
+				"Unit.Description":       "Photon OS container",
+				"Unit.PartOf":            "machines.target",
+				"Unit.RequiresMountsFor": "/var/lib/machines/web",
+				"Service.ExecStart":      "/usr/bin/cntrctl boot --keep-unit web",
+				"Service.Type":           "notify",
+				"Service.NotifyAccess":   "all",
+				"Service.Slice":          "machine.slice",
+				"Service.TasksMax":       "16384",
+				"Install.WantedBy":       "machines.target",
+			},
+		},
+		{
+			name:      "network and binds",
+			network:   "macvlan",
+			link:      "eth0",
+			machine:   "www",
+			ephemeral: true,
+			opts: &UnitOptions{
+				Binds:         []string{"/srv/my data:/data"},
+				ReadOnlyBinds: []string{"/etc/hosts"},
+				StorageDir:    "/srv/machines",
+				Security:      "userns",
+			},
+			want: map[string]string{
+				"Service.ExecStart":   `/usr/bin/cntrctl boot --keep-unit -m www -x -n macvlan -l eth0 --bind "/srv/my data:/data" --bind-ro /etc/hosts web`,
+				"Service.Environment": "CNTRCTL_SYSTEM_STORAGEDIR=/srv/machines CNTRCTL_SECURITY_PROFILE=userns",
+			},
+		},
+		{
+			name: "group and limits",
+			opts: &UnitOptions{
+				Description: "Photon OS container db of shop",
+				Target:      "cntrctl-shop.target",
+				Slice:       "machine-shop.slice",
+				Requires:    []string{"shop-cache.service"},
+				After:       []string{"shop-cache.service"},
+				CPUQuota:    "50%",
+				MemoryMax:   "1G",
+				TasksMax:    "512",
+			},
+			want: map[string]string{
+				"Unit.Description":  "Photon OS container db of shop",
+				"Unit.Requires":     "shop-cache.service",
+				"Unit.PartOf":       "machines.target cntrctl-shop.target",
+				"Unit.After":        "network.target systemd-resolved.service modprobe@tun.service modprobe@loop.service modprobe@dm-mod.service shop-cache.service",
+				"Service.Slice":     "machine-shop.slice",
+				"Service.CPUQuota":  "50%",
+				"Service.MemoryMax": "1G",
+				"Service.TasksMax":  "512",
+				"Install.WantedBy":  "cntrctl-shop.target",
+			},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			e := NewRecordingExecutor()
+			defer SetExecutor(SetExecutor(e))
+
+			if err := CreateUnitFile("web", tt.network, tt.link, tt.machine, tt.ephemeral, tt.opts); err != nil {
+				t.Fatal(err)
+			}
+
+			if got, want := e.Plans(), []string{"install -m 644 /dev/stdin " + UnitFilePath("web")}; !reflect.DeepEqual(got, want) {
+				t.Errorf("plans = %q, want %q", got, want)
+			}
+
+			keys := unitKeys(e.Files[UnitFilePath("web")])
+			for k, v := range tt.want {
+				if len(keys[k]) != 1 || keys[k][0] != v {
+					t.Errorf("%s = %q, want %q", k, keys[k], v)
+				}
+			}
+		})
+	}
+}
@@ -0,0 +1,163 @@
+// SPDX-License-Identifier: BSD-2
+// Copyright 2023 VMware, Inc.
+
+package system
+
+import (
+	"io/fs"
+	"os"
+	"path/filepath"
+	"syscall"
+	"unsafe"
+
+	"golang.org/x/sys/unix"
+)
+
+// Usage is the disk space a tree takes up.
+type Usage struct {
+	// Size is the space allocated to the files, counting hardlinked files once.
+	Size int64 `json:"size"`
+	// Shared is the part of Size in extents other files share, through reflinks or
+	// snapshots. Removing the tree frees only Size - Shared.
+	Shared int64 `json:"shared"`
+	Files  int64 `json:"files"`
+}
+
+func (u *Usage) Add(o Usage) {
+	u.Size += o.Size
+	u.Shared += o.Shared
+	u.Files += o.Files
+}
+
+// Inodes records the files already accounted for, so files hardlinked between trees
+// are only counted for the first tree.
+type Inodes map[[2]uint64]bool
+
+// DiskUsage returns the usage of the tree at root. Files in seen are skipped and the
+// files of root are added to it.
+func DiskUsage(root string, seen Inodes) (Usage, error) {
+	var u Usage
+
+	err := filepath.WalkDir(root, func(f string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return nil
+		}
+
+		info, err := d.Info()
+		if err != nil {
+			return nil
+		}
+
+		st, ok := info.Sys().(*syscall.Stat_t)
+		if !ok {
+			return nil
+		}
+
+		key := [2]uint64{uint64(st.Dev), st.Ino}
+		if seen[key] {
+			return nil
+		}
+		seen[key] = true
+
+		u.Files++
+		u.Size += st.Blocks * 512
+		if d.Type().IsRegular() && st.Blocks > 0 {
+			u.Shared += sharedBytes(f)
+		}
+
+		return nil
+	})
+
+	return u, err
+}
+
+const (
+	fsIocFiemap        = 0xc020660b
+	fiemapFlagSync     = 0x1
+	fiemapExtentLast   = 0x1
+	fiemapExtentShared = 0x2000
+	fiemapExtents      = 32
+)
+
+type fiemapExtent struct {
+	Logical  uint64
+	Physical uint64
+	Length   uint64
+	_        [2]uint64
+	Flags    uint32
+	_        [3]uint32
+}
+
+type fiemap struct {
+	Start         uint64
+	Length        uint64
+	Flags         uint32
+	MappedExtents uint32
+	ExtentCount   uint32
+	_             uint32
+	Extents       [fiemapExtents]fiemapExtent
+}
+
+// sharedBytes returns the bytes of file f in extents flagged as shared by FIEMAP.
+// File systems without reflinks never flag extents, failures count as not shared.
+func sharedBytes(f string) int64 {
+	file, err := os.Open(f)
+	if err != nil {
+		return 0
+	}
+	defer file.Close()
+
+	var shared int64
+	m := fiemap{}
+	for {
+		m.Length = ^uint64(0)
+		m.Flags = fiemapFlagSync
+		m.ExtentCount = fiemapExtents
+		m.MappedExtents = 0
+
+		if _, _, errno := unix.Syscall(unix.SYS_IOCTL, file.Fd(), fsIocFiemap, uintptr(unsafe.Pointer(&m))); errno != 0 {
+			return shared
+		}
+		if m.MappedExtents == 0 {
+			return shared
+		}
+
+		for _, e := range m.Extents[:m.MappedExtents] {
+			if e.Flags&fiemapExtentShared != 0 {
+				shared += int64(e.Length)
+			}
+			if e.Flags&fiemapExtentLast != 0 {
+				return shared
+			}
+		}
+
+		last := m.Extents[m.MappedExtents-1]
+		m.Start = last.Logical + last.Length
+	}
+}
+
+// Filesystem is the capacity of the file system holding a directory.
+type Filesystem struct {
+	Type  int64  `json:"-"`
+	Total uint64 `json:"total"`
+	Free  uint64 `json:"free"`
+}
+
+func StatFilesystem(dir string) (*Filesystem, error) {
+	var s unix.Statfs_t
+	if err := unix.Statfs(dir, &s); err != nil {
+		return nil, err
+	}
+
+	return &Filesystem{
+		Type:  int64(s.Type),
+		Total: s.Blocks * uint64(s.Bsize),
+		Free:  s.Bavail * uint64(s.Bsize),
+	}, nil
+}
+
+// IsBtrfs reports whether dir is on btrfs.
+func IsBtrfs(dir string) bool {
+	f, err := StatFilesystem(dir)
+	return err == nil && f.Type == unix.BTRFS_SUPER_MAGIC
+}
@@ -6,165 +6,340 @@ package systemd
 import (
 	"context"
 	"errors"
+	"fmt"
 	"strings"
 	"time"
 
 	sd "github.com/coreos/go-systemd/v22/dbus"
+	"github.com/godbus/dbus/v5"
 	log "github.com/sirupsen/logrus"
+	"github.com/vmware-samples/photon-os-container-builder/pkg/errdefs"
+	"github.com/vmware-samples/photon-os-container-builder/pkg/lock"
+	"github.com/vmware-samples/photon-os-container-builder/pkg/logging"
 	"github.com/vmware-samples/photon-os-container-builder/pkg/system"
 )
 
 const (
 	defaultRequestTimeout = 10 * time.Second
+	DefaultJobTimeout     = 90 * time.Second
+
+	JobDone    = "done"
+	JobTimeout = "timeout"
 )
 
 type Unit struct {
 	Command string
 	Name    string
+	Timeout time.Duration
+	Result  string
+}
+
+type UnitStatus struct {
+	Name          string    `json:"name"`
+	Description   string    `json:"description"`
+	LoadState     string    `json:"load_state"`
+	ActiveState   string    `json:"active_state"`
+	SubState      string    `json:"sub_state"`
+	UnitFileState string    `json:"unit_file_state"`
+	MainPID       uint32    `json:"main_pid"`
+	ControlGroup  string    `json:"control_group,omitempty"`
+	ActiveSince   time.Time `json:"active_since,omitempty"`
+	Result        string    `json:"result"`
+}
+
+func (u *Unit) log() *log.Entry {
+	return log.WithFields(log.Fields{
+		logging.FieldUnit:      u.Name,
+		logging.FieldOperation: u.Command,
+	})
 }
 
 func (u *Unit) appendSuffixIfMissing() {
-	ok := strings.HasSuffix(u.Name, ".service")
-	if !ok {
-		u.Name += ".service"
+	for _, s := range []string{".service", ".target", ".slice"} {
+		if strings.HasSuffix(u.Name, s) {
+			return
+		}
 	}
+
+	u.Name += ".service"
 }
 
-func SetupContainerService(container string, network string, link string, machine string, ephemeral bool) error {
-	ctx, cancel := context.WithTimeout(context.Background(), defaultRequestTimeout)
-	defer cancel()
+func busError(err error) error {
+	return errdefs.Errorf(errdefs.ErrBus, "failed to connect to the system bus: %w", err)
+}
 
-	c, err := sd.NewSystemdConnectionContext(ctx)
-	if err != nil {
-		log.Errorf("Failed to establish connection with system bus: %v", err)
+// unitError gives errors of systemd about a unit their kind.
+func unitError(err error) error {
+	var e dbus.Error
+	if !errors.As(err, &e) {
 		return err
 	}
-	defer c.Close()
 
-	if err := system.CreateUnitFile(container, network, link, machine, ephemeral); err != nil {
+	switch e.Name {
+	case "org.freedesktop.systemd1.NoSuchUnit", "org.freedesktop.systemd1.LoadFailed":
+		return errdefs.Wrap(errdefs.ErrNotFound, err)
+	case "org.freedesktop.DBus.Error.AccessDenied":
+		return errdefs.Wrap(errdefs.ErrPermission, err)
+	case "org.freedesktop.systemd1.UnitMasked":
+		return errdefs.Wrap(errdefs.ErrConflict, err)
+	}
+
+	return err
+}
+
+// SetupContainerService writes the service unit of container, and its network unit
+// into the root directory o.Directory, and reloads the manager.
+func SetupContainerService(container string, network string, link string, machine string, ephemeral bool, o *system.UnitOptions) error {
+	if o == nil || o.Directory == "" {
+		return fmt.Errorf("no root directory given for the service unit of '%s'", container)
+	}
+
+	if err := system.CreateUnitFile(container, network, link, machine, ephemeral, o); err != nil {
 		return err
 	}
 
-	if err := system.CreateNetworkUnitFile(container, network, link); err != nil {
+	if err := system.CreateNetworkUnitFile(o.Directory, container, network, link); err != nil {
 		return err
 	}
 
-	if err := c.ReloadContext(ctx); err != nil {
+	return Reload()
+}
+
+// SetupTarget writes the target of a group of containers and reloads the manager.
+func SetupTarget(target string, description string, wants []string) error {
+	if err := system.CreateTargetFile(target, description, wants); err != nil {
 		return err
 	}
 
-	return nil
+	return Reload()
 }
 
+// Reload reloads the unit files. Concurrent cntrctl processes reload one at a time.
+func Reload() error {
+	return system.Do("systemctl daemon-reload", func() error {
+		l, err := lock.Global(context.Background(), "daemon-reload")
+		if err != nil {
+			return err
+		}
+		defer l.Release()
+
+		ctx, cancel := context.WithTimeout(context.Background(), defaultRequestTimeout)
+		defer cancel()
+
+		c, err := sd.NewSystemdConnectionContext(ctx)
+		if err != nil {
+			log.WithError(err).Error("Failed to establish connection with system bus")
+			return busError(err)
+		}
+		defer c.Close()
+
+		return c.ReloadContext(ctx)
+	})
+}
+
+// ApplyCommand runs Command on the unit over D-Bus and waits for the job.
 func (u *Unit) ApplyCommand() error {
-	ctx, cancel := context.WithTimeout(context.Background(), defaultRequestTimeout)
+	u.appendSuffixIfMissing()
+
+	return system.Do(system.CommandLine("systemctl", u.Command, u.Name), u.apply)
+}
+
+func (u *Unit) apply() error {
+	if u.Timeout == 0 {
+		u.Timeout = DefaultJobTimeout
+	}
+
+	// The bus connection is bound to ctx, it has to outlive the job we wait for.
+	ctx, cancel := context.WithTimeout(context.Background(), defaultRequestTimeout+u.Timeout)
 	defer cancel()
 
 	c, err := sd.NewSystemdConnectionContext(ctx)
 	if err != nil {
-		log.Errorf("Failed to establish connection with system bus: %v", err)
-		return err
+		log.WithError(err).Error("Failed to establish connection with system bus")
+		return busError(err)
 	}
 	defer c.Close()
 
-	u.appendSuffixIfMissing()
-
-	ch := make(chan string)
+	ch := make(chan string, 1)
 	switch u.Command {
 	case "start":
 		jid, err := c.StartUnitContext(ctx, u.Name, "replace", ch)
 		if err != nil {
-			log.Errorf("Failed to start systemd unit='%s': %v", u.Name, err)
-			return err
+			u.log().WithError(err).Error("Failed to queue job")
+			return unitError(err)
 		}
 
-		log.Debugf("Successfully executed 'start' on systemd unit='%s' job_id='%d'", u.Name, jid)
+		u.log().WithField("job_id", jid).Debug("Queued job")
+		return u.wait(ch)
 
 	case "stop":
 		jid, err := c.StopUnitContext(ctx, u.Name, "fail", ch)
 		if err != nil {
-			log.Errorf("Failed to stop systemd unit='%s': %v", u.Name, err)
-			return err
+			u.log().WithError(err).Error("Failed to queue job")
+			return unitError(err)
 		}
 
-		log.Debugf("Successfully executed 'stop' on systemd unit='%s' job_id='%d'", u.Name, jid)
+		u.log().WithField("job_id", jid).Debug("Queued job")
+		return u.wait(ch)
 
 	case "restart":
 		jid, err := c.RestartUnitContext(ctx, u.Name, "replace", ch)
 		if err != nil {
-			log.Errorf("Failed to restart systemd unit='%s': %v", u.Name, err)
-			return err
+			u.log().WithError(err).Error("Failed to queue job")
+			return unitError(err)
 		}
 
-		log.Debugf("Successfully executed 'restart' on systemd unit='%s' job_id='%d'", u.Name, jid)
+		u.log().WithField("job_id", jid).Debug("Queued job")
+		return u.wait(ch)
 
 	case "try-restart":
 		jid, err := c.TryRestartUnitContext(ctx, u.Name, "replace", ch)
 		if err != nil {
-			log.Errorf("Failed to try restart systemd unit='%s': %v", u.Name, err)
-			return err
+			u.log().WithError(err).Error("Failed to queue job")
+			return unitError(err)
 		}
 
-		log.Debugf("Successfully executed 'try-restart' on systemd unit='%s' job_id='%d'", u.Name, jid)
+		u.log().WithField("job_id", jid).Debug("Queued job")
+		return u.wait(ch)
 
 	case "reload-or-restart":
 		jid, err := c.ReloadOrRestartUnitContext(ctx, u.Name, "replace", ch)
 		if err != nil {
-			log.Errorf("Failed to reload or restart systemd unit='%s': %v", u.Name, err)
-			return err
+			u.log().WithError(err).Error("Failed to queue job")
+			return unitError(err)
 		}
 
-		log.Debugf("Successfully executed 'reload-or-restart' on systemd unit='%s' job_id='%d'", u.Name, jid)
+		u.log().WithField("job_id", jid).Debug("Queued job")
+		return u.wait(ch)
 
 	case "reload":
 		jid, err := c.ReloadUnitContext(ctx, u.Name, "replace", ch)
 		if err != nil {
-			log.Errorf("Failed to reload systemd unit='%s': %v", u.Name, err)
-			return err
+			u.log().WithError(err).Error("Failed to queue job")
+			return unitError(err)
 		}
 
-		log.Debugf("Successfully executed 'reload' on systemd unit='%s' job_id='%d'", u.Name, jid)
+		u.log().WithField("job_id", jid).Debug("Queued job")
+		return u.wait(ch)
 
 	case "enable":
 		install, changes, err := c.EnableUnitFilesContext(ctx, []string{u.Name}, false, true)
 		if err != nil {
-			log.Errorf("Failed to enable systemd unit='%s': %v", u.Name, err)
-			return err
+			u.log().WithError(err).Error("Failed to enable unit file")
+			return unitError(err)
 		}
 
-		log.Debugf("Successfully executed 'enable' on systemd unit='%s' install='%t' changes='%s'", u.Name, install, changes)
+		u.log().WithFields(log.Fields{"install": install, "changes": changes}).Debug("Applied unit file change")
 
 	case "disable":
 		changes, err := c.DisableUnitFilesContext(ctx, []string{u.Name}, false)
 		if err != nil {
-			log.Errorf("Failed to disable systemd unit='%s': %v", u.Name, err)
-			return err
+			u.log().WithError(err).Error("Failed to disable unit file")
+			return unitError(err)
 		}
 
-		log.Debugf("Successfully executed 'disable' on systemd unit='%s' changes='%s'", u.Name, changes)
+		u.log().WithField("changes", changes).Debug("Applied unit file change")
 
 	case "mask":
 		changes, err := c.MaskUnitFilesContext(ctx, []string{u.Name}, false, true)
 		if err != nil {
-			log.Errorf("Failed to mask systemd unit='%s': %v", u.Name, err)
-			return err
+			u.log().WithError(err).Error("Failed to mask unit file")
+			return unitError(err)
 		}
 
-		log.Debugf("Successfully executed 'mask' on systemd unit='%s' changes='%s'", u.Name, changes)
+		u.log().WithField("changes", changes).Debug("Applied unit file change")
 
 	case "unmask":
 		changes, err := c.UnmaskUnitFilesContext(ctx, []string{u.Name}, false)
 		if err != nil {
-			log.Errorf("Failed to unmask systemd unit='%s': %v", u.Name, err)
-			return err
+			u.log().WithError(err).Error("Failed to unmask unit file")
+			return unitError(err)
 		}
 
-		log.Debugf("Successfully executed 'unmask' on systemd unit='%s' changes='%s'", u.Name, changes)
+		u.log().WithField("changes", changes).Debug("Applied unit file change")
 
 	default:
-		log.Errorf("Unknown unit command='%s' for systemd unit='%s'", u.Command, u.Name)
-		return errors.New("unknown unit command")
+		u.log().Error("Unknown unit command")
+		return errdefs.New(errdefs.ErrInvalid, "unknown unit command")
 	}
 
+	if err := c.ReloadContext(ctx); err != nil {
+		u.log().WithError(err).Error("Failed to reload systemd manager")
+		return err
+	}
+
+	u.Result = JobDone
 	return nil
 }
+
+func (u *Unit) wait(ch chan string) error {
+	select {
+	case u.Result = <-ch:
+	case <-time.After(u.Timeout):
+		u.Result = JobTimeout
+	}
+
+	if u.Result != JobDone {
+		u.log().WithField("result", u.Result).Debug("Job finished")
+		err := fmt.Errorf("job '%s' on unit '%s' finished with result '%s'", u.Command, u.Name, u.Result)
+		if u.Result == JobTimeout {
+			return errdefs.Wrap(errdefs.ErrTimeout, err)
+		}
+
+		return err
+	}
+
+	return nil
+}
+
+func (u *Unit) Status() (*UnitStatus, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), defaultRequestTimeout)
+	defer cancel()
+
+	c, err := sd.NewSystemdConnectionContext(ctx)
+	if err != nil {
+		log.WithError(err).Error("Failed to establish connection with system bus")
+		return nil, busError(err)
+	}
+	defer c.Close()
+
+	u.appendSuffixIfMissing()
+
+	p, err := c.GetUnitPropertiesContext(ctx, u.Name)
+	if err != nil {
+		u.log().WithError(err).Error("Failed to get unit properties")
+		return nil, err
+	}
+
+	sp, err := c.GetUnitTypePropertiesContext(ctx, u.Name, "Service")
+	if err != nil {
+		u.log().WithError(err).Error("Failed to get service properties")
+		return nil, err
+	}
+
+	s := UnitStatus{
+		Name:          u.Name,
+		Description:   propertyString(p, "Description"),
+		LoadState:     propertyString(p, "LoadState"),
+		ActiveState:   propertyString(p, "ActiveState"),
+		SubState:      propertyString(p, "SubState"),
+		UnitFileState: propertyString(p, "UnitFileState"),
+		Result:        propertyString(sp, "Result"),
+	}
+
+	if pid, ok := sp["MainPID"].(uint32); ok {
+		s.MainPID = pid
+	}
+	s.ControlGroup = propertyString(sp, "ControlGroup")
+
+	if t, ok := p["ActiveEnterTimestamp"].(uint64); ok && t > 0 && s.ActiveState == "active" {
+		s.ActiveSince = time.UnixMicro(int64(t))
+	}
+
+	return &s, nil
+}
+
+func propertyString(p map[string]interface{}, key string) string {
+	v, _ := p[key].(string)
+	return v
+}
@@ -0,0 +1,184 @@
+// SPDX-License-Identifier: BSD-2
+// Copyright 2023 VMware, Inc.
+
+package systemd
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"time"
+
+	sd "github.com/coreos/go-systemd/v22/dbus"
+	"github.com/godbus/dbus/v5"
+	log "github.com/sirupsen/logrus"
+
+	"github.com/vmware-samples/photon-os-container-builder/pkg/bus"
+	"github.com/vmware-samples/photon-os-container-builder/pkg/errdefs"
+	"github.com/vmware-samples/photon-os-container-builder/pkg/parser"
+)
+
+const (
+	pollInterval = time.Second
+)
+
+type WaitError struct {
+	Machine string
+	Target  string
+	State   string
+	Reason  string
+	Failed  []string
+	Jobs    []string
+}
+
+func (e *WaitError) Error() string {
+	t := e.Target
+	if t == "" {
+		t = "system"
+	}
+
+	s := fmt.Sprintf("container '%s' %s waiting for %s (state '%s')", e.Machine, e.Reason, t, e.State)
+	if len(e.Failed) > 0 {
+		s += "\nfailed units:\n  " + strings.Join(e.Failed, "\n  ")
+	}
+	if len(e.Jobs) > 0 {
+		s += "\npending jobs:\n  " + strings.Join(e.Jobs, "\n  ")
+	}
+
+	return s
+}
+
+// Unwrap makes a container that did not come up in time an errdefs.ErrTimeout.
+func (e *WaitError) Unwrap() error {
+	if e.Reason == "timed out" {
+		return errdefs.ErrTimeout
+	}
+
+	return nil
+}
+
+// NormalizeTarget appends '.target' to names without a unit type suffix, so that
+// 'network-online' waits for network-online.target.
+func NormalizeTarget(target string) string {
+	if target == "" || strings.Contains(target, ".") {
+		return target
+	}
+
+	return target + ".target"
+}
+
+func containerConnection(machine string) (*sd.Conn, error) {
+	pid, err := parser.ParseGroupLeader(machine)
+	if err != nil {
+		return nil, err
+	}
+
+	return sd.NewConnection(func() (*dbus.Conn, error) {
+		return bus.ContainerSystemdPrivateConn(pid)
+	})
+}
+
+func diagnose(ctx context.Context, c *sd.Conn, e *WaitError) *WaitError {
+	if units, err := c.ListUnitsFilteredContext(ctx, []string{"failed"}); err == nil {
+		for _, u := range units {
+			e.Failed = append(e.Failed, u.Name+" ("+u.SubState+")")
+		}
+	}
+
+	if jobs, err := c.ListJobsContext(ctx); err == nil {
+		for _, j := range jobs {
+			e.Jobs = append(e.Jobs, j.Unit+" "+j.JobType+" "+j.Status)
+		}
+	}
+
+	return e
+}
+
+// check reports whether target is reached inside the container. An empty target waits
+// for the container's boot to finish.
+func check(ctx context.Context, c *sd.Conn, machine string, target string) (string, bool, *WaitError) {
+	if target == "" {
+		p, err := c.SystemStateContext(ctx)
+		if err != nil {
+			return "unknown", false, nil
+		}
+
+		state, _ := p.Value.Value().(string)
+		switch state {
+		case "running":
+			return state, true, nil
+		case "degraded":
+			return state, false, diagnose(ctx, c, &WaitError{Machine: machine, State: state, Reason: "degraded"})
+		}
+
+		return state, false, nil
+	}
+
+	p, err := c.GetUnitPropertiesContext(ctx, target)
+	if err != nil {
+		return "unknown", false, nil
+	}
+
+	state := propertyString(p, "ActiveState")
+	switch state {
+	case "active":
+		return state, true, nil
+	case "failed":
+		return state, false, diagnose(ctx, c, &WaitError{Machine: machine, Target: target, State: state, Reason: "failed"})
+	}
+
+	return state, false, nil
+}
+
+// WaitForContainer polls the systemd instance running inside machine until target
+// becomes active or timeout expires.
+func WaitForContainer(machine string, target string, timeout time.Duration) error {
+	ctx, cancel := context.WithTimeout(context.Background(), timeout)
+	defer cancel()
+
+	target = NormalizeTarget(target)
+
+	var c *sd.Conn
+	defer func() {
+		if c != nil {
+			c.Close()
+		}
+	}()
+
+	state := "unknown"
+	for {
+		if c == nil {
+			var err error
+			if c, err = containerConnection(machine); err != nil {
+				log.WithField("machine", machine).WithError(err).Debug("Waiting for systemd to come up")
+				state = "not running"
+				c = nil
+			}
+		}
+
+		if c != nil {
+			var ok bool
+			var werr *WaitError
+
+			if state, ok, werr = check(ctx, c, machine, target); ok {
+				return nil
+			}
+			if werr != nil {
+				return werr
+			}
+		}
+
+		select {
+		case <-ctx.Done():
+			e := &WaitError{Machine: machine, Target: target, State: state, Reason: "timed out"}
+			if c != nil {
+				dctx, dcancel := context.WithTimeout(context.Background(), defaultRequestTimeout)
+				defer dcancel()
+
+				e = diagnose(dctx, c, e)
+			}
+			return e
+		case <-time.After(pollInterval):
+		}
+	}
+}
@@ -0,0 +1,295 @@
+// SPDX-License-Identifier: BSD-2
+// Copyright 2023 VMware, Inc.
+
+// Package txn journals multi-step operations on a container so a failed or
+// interrupted operation can be rolled back or resumed.
+package txn
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/fs"
+	"os"
+	"path"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/vmware-samples/photon-os-container-builder/pkg/errdefs"
+	"github.com/vmware-samples/photon-os-container-builder/pkg/rpm"
+	"github.com/vmware-samples/photon-os-container-builder/pkg/system"
+	"github.com/vmware-samples/photon-os-container-builder/pkg/systemd"
+)
+
+const (
+	StatePending = "pending"
+	StateDone    = "done"
+)
+
+// Undo is what rolling back a step does: remove paths, then reload systemd when unit
+// files were among them.
+type Undo struct {
+	Remove []string `json:"remove,omitempty"`
+	Reload bool     `json:"reload,omitempty"`
+}
+
+// Step is recorded as pending with its undo before it runs, so its partial effects
+// are rolled back too.
+type Step struct {
+	Name  string `json:"name"`
+	State string `json:"state"`
+	Undo  Undo   `json:"undo"`
+}
+
+type Journal struct {
+	Operation string          `json:"operation"`
+	Container string          `json:"container"`
+	PID       int             `json:"pid"`
+	Started   time.Time       `json:"started"`
+	Params    json.RawMessage `json:"params"`
+	Steps     []Step          `json:"steps"`
+
+	file string
+}
+
+func Path(dir string, container string) string {
+	return path.Join(dir, container+".json")
+}
+
+// Begin starts journaling operation on container. params are recorded to resume it.
+// Callers hold the lock of container, so an existing journal means an earlier
+// operation was interrupted.
+func Begin(dir string, operation string, container string, params interface{}) (*Journal, error) {
+	p, err := json.Marshal(params)
+	if err != nil {
+		return nil, err
+	}
+
+	j := &Journal{
+		Operation: operation,
+		Container: container,
+		PID:       os.Getpid(),
+		Started:   time.Now().UTC(),
+		Params:    p,
+		file:      Path(dir, container),
+	}
+
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return nil, err
+	}
+
+	f, err := os.OpenFile(j.file, os.O_WRONLY|os.O_CREATE|os.O_EXCL, 0644)
+	if err != nil {
+		if errors.Is(err, fs.ErrExist) {
+			return nil, errdefs.Errorf(errdefs.ErrConflict,
+				"an interrupted %s of '%s' is pending, resume or roll it back with 'cntrctl recover'", operation, container)
+		}
+		return nil, err
+	}
+	f.Close()
+
+	return j, j.save()
+}
+
+// Load reads the journal of container and takes it over from the process that
+// wrote it. Callers hold the lock of container, so that process has exited.
+func Load(dir string, container string) (*Journal, error) {
+	j, err := read(Path(dir, container))
+	if err != nil {
+		if errors.Is(err, fs.ErrNotExist) {
+			return nil, errdefs.Errorf(errdefs.ErrNotFound, "no interrupted operation of '%s'", container)
+		}
+		return nil, err
+	}
+
+	j.PID = os.Getpid()
+	return j, j.save()
+}
+
+// List returns the journals in dir.
+func List(dir string) ([]*Journal, error) {
+	files, err := filepath.Glob(path.Join(dir, "*.json"))
+	if err != nil {
+		return nil, err
+	}
+	sort.Strings(files)
+
+	var r []*Journal
+	for _, f := range files {
+		j, err := read(f)
+		if err != nil {
+			return nil, err
+		}
+		r = append(r, j)
+	}
+
+	return r, nil
+}
+
+func read(file string) (*Journal, error) {
+	b, err := os.ReadFile(file)
+	if err != nil {
+		return nil, err
+	}
+
+	j := Journal{file: file}
+	if err := json.Unmarshal(b, &j); err != nil {
+		return nil, fmt.Errorf("failed to parse journal '%s': %w", file, err)
+	}
+
+	return &j, nil
+}
+
+func (j *Journal) save() error {
+	b, err := json.MarshalIndent(j, "", "  ")
+	if err != nil {
+		return err
+	}
+
+	// Written next to the journal and renamed so a crash never leaves it truncated
+	tmp := j.file + ".tmp"
+	if err := os.WriteFile(tmp, append(b, '\n'), 0644); err != nil {
+		return err
+	}
+
+	return os.Rename(tmp, j.file)
+}
+
+// Done reports whether step name has completed.
+func (j *Journal) Done(name string) bool {
+	for _, s := range j.Steps {
+		if s.Name == name {
+			return s.State == StateDone
+		}
+	}
+
+	return false
+}
+
+// Pending returns the names of the completed steps and the step that was
+// interrupted, if any.
+func (j *Journal) Pending() ([]string, string) {
+	var done []string
+	for _, s := range j.Steps {
+		if s.State != StateDone {
+			return done, s.Name
+		}
+		done = append(done, s.Name)
+	}
+
+	return done, ""
+}
+
+// Step runs f unless an earlier run of the journal completed it. The journal may be
+// nil, f then just runs. Cancelling ctx stops before the step.
+func (j *Journal) Step(ctx context.Context, name string, undo Undo, f func() error) error {
+	if err := ctx.Err(); err != nil {
+		return fmt.Errorf("interrupted before %s: %w", name, err)
+	}
+
+	if j == nil {
+		return f()
+	}
+
+	if j.Done(name) {
+		return nil
+	}
+
+	i := len(j.Steps)
+	for k, s := range j.Steps {
+		if s.Name == name {
+			i = k
+		}
+	}
+	if i == len(j.Steps) {
+		j.Steps = append(j.Steps, Step{Name: name})
+	}
+	j.Steps[i].State = StatePending
+	j.Steps[i].Undo = undo
+
+	if err := j.save(); err != nil {
+		return fmt.Errorf("failed to write journal: %w", err)
+	}
+
+	if err := f(); err != nil {
+		return err
+	}
+
+	j.Steps[i].State = StateDone
+	if err := j.save(); err != nil {
+		return fmt.Errorf("failed to write journal: %w", err)
+	}
+
+	return nil
+}
+
+// Rollback undoes the recorded steps in reverse order and removes the journal. The
+// journal is kept when undoing fails so rolling back can be retried.
+func (j *Journal) Rollback() error {
+	if j == nil {
+		return nil
+	}
+
+	var errs []error
+	reload := false
+	for i := len(j.Steps) - 1; i >= 0; i-- {
+		s := j.Steps[i]
+		for _, p := range s.Undo.Remove {
+			// A rootfs of an install that was killed may still have the shared cache mounted
+			if err := rpm.UnmountCache(p); err != nil {
+				errs = append(errs, fmt.Errorf("failed to undo %s: %w", s.Name, err))
+				continue
+			}
+			if err := system.RemoveAll(p); err != nil {
+				errs = append(errs, fmt.Errorf("failed to undo %s: %w", s.Name, err))
+			}
+		}
+		reload = reload || s.Undo.Reload
+	}
+
+	if reload {
+		if err := systemd.Reload(); err != nil {
+			errs = append(errs, fmt.Errorf("failed to reload systemd: %w", err))
+		}
+	}
+
+	if len(errs) > 0 {
+		return errors.Join(errs...)
+	}
+
+	return j.remove()
+}
+
+// Commit removes the journal of a completed operation.
+func (j *Journal) Commit() error {
+	if j == nil {
+		return nil
+	}
+
+	return j.remove()
+}
+
+func (j *Journal) remove() error {
+	if err := os.Remove(j.file); err != nil && !errors.Is(err, fs.ErrNotExist) {
+		return err
+	}
+
+	return nil
+}
+
+// String describes the progress of the journal.
+func (j *Journal) String() string {
+	done, pending := j.Pending()
+	s := fmt.Sprintf("%s of '%s' started %s", j.Operation, j.Container, j.Started.Local().Format(time.RFC3339))
+	if len(done) > 0 {
+		s += ", completed " + strings.Join(done, ",")
+	}
+	if pending != "" {
+		s += ", interrupted during " + pending
+	}
+
+	return s
+}